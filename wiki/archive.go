@@ -0,0 +1,334 @@
+package wiki
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Archives are gzipped tar files holding a manifest, then a JSON file for each page, with its history,
+// and then the attachments of the pages, if any:
+//
+//	manifest.json
+//	pages/{id}.json
+//	attachments/{id}/{name}
+//
+// Pages come after their parents, so that they can be imported in order.
+const (
+	ARCHIVE_FORMAT_VERSION = 1
+	ARCHIVE_MANIFEST = "manifest.json"
+	ARCHIVE_PAGES_DIR = "pages/"
+	ARCHIVE_ATTACHMENTS_DIR = "attachments/"
+	ARCHIVE_PAGE_SUFFIX = ".json"
+)
+
+type archiveManifest struct {
+	FormatVersion	int
+	Exported		time.Time
+	Pages			int
+}
+
+type archivedPage struct {
+	Page		*Page
+	Revisions	[]*Revision
+	Checksum	string  // as computed by PageChecksum, to detect damaged archives
+}
+
+// What to do when importing a page whose id is already in the store
+type ImportPolicy int
+
+const (
+	IMPORT_FAIL ImportPolicy = iota  // stop importing
+	IMPORT_SKIP  // keep the page in the store, and its attachments
+	IMPORT_REPLACE  // replace the page in the store, and its attachments
+)
+
+var importPolicyNames = map[string]ImportPolicy{
+	"fail": IMPORT_FAIL,
+	"skip": IMPORT_SKIP,
+	"replace": IMPORT_REPLACE,
+}
+
+// Parses the name of an import policy: fail, skip or replace
+func ParseImportPolicy(name string) (ImportPolicy, error) {
+	policy, found := importPolicyNames[name]
+	if !found {
+		return 0, fmt.Errorf("unknown import policy %q", name)
+	}
+	return policy, nil
+}
+
+// Counts of an export or an import
+type ArchiveReport struct {
+	Pages		int  // pages exported or imported, including the replaced ones
+	Replaced	int  // pages that replaced those with the same id
+	Skipped		int  // pages not imported because their id was already in the store
+	Attachments	int
+}
+
+// Writes all the pages of a store, with their history, into an archive. Attachments are written too,
+// unless attachments is nil. Pages in the trash are not exported.
+func ExportArchive(store PageStore, attachments AttachmentStore, writer io.Writer) (*ArchiveReport, error) {
+	ids, err := listParentsFirst(store)
+	if err != nil {
+		return nil, err
+	}
+
+	compressor := gzip.NewWriter(writer)
+	archive := tar.NewWriter(compressor)
+	now := time.Now()
+	err = writeArchiveJson(archive, ARCHIVE_MANIFEST, now, &archiveManifest{ARCHIVE_FORMAT_VERSION, now, len(ids)})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ArchiveReport{}
+	for _, id := range ids {
+		page, revisions, checksum, err := readWithChecksum(store, id)
+		if err != nil {
+			return report, err
+		}
+		err = writeArchiveJson(archive, ARCHIVE_PAGES_DIR+string(id)+ARCHIVE_PAGE_SUFFIX, now, &archivedPage{page, revisions, checksum})
+		if err != nil {
+			return report, err
+		}
+		report.Pages++
+	}
+
+	if attachments != nil {
+		for _, id := range ids {
+			count, err := exportAttachments(archive, attachments, id)
+			report.Attachments += count
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	err = archive.Close()
+	if err == nil {
+		err = compressor.Close()
+	}
+	return report, err
+}
+
+func writeArchiveJson(archive *tar.Writer, name string, modified time.Time, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: modified})
+	if err != nil {
+		return err
+	}
+	_, err = archive.Write(content)
+	return err
+}
+
+func exportAttachments(archive *tar.Writer, attachments AttachmentStore, id PageId) (int, error) {
+	list, err := attachments.List(id)
+	if err != nil {
+		return 0, err
+	}
+
+	for k, attachment := range list {
+		content, attachment, err := attachments.Open(id, attachment.Name)
+		if err != nil {
+			return k, err
+		}
+		name := ARCHIVE_ATTACHMENTS_DIR + string(id) + "/" + attachment.Name
+		err = archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: attachment.Size, ModTime: attachment.Modified})
+		if err == nil {
+			_, err = io.Copy(archive, content)
+		}
+		content.Close()
+		if err != nil {
+			return k, err
+		}
+	}
+	return len(list), nil
+}
+
+// Imports the pages of an archive into a store, with their ids, metadata and history, applying the policy to
+// the pages whose id is already in the store. Attachments are imported too, unless attachments is nil.
+// All the pages are read and checked before importing any, so that a damaged archive, or a page already in
+// the store with IMPORT_FAIL, leaves the store as it was; the attachments that follow them are not checked
+// beforehand, and the report tells what was imported if they fail.
+func ImportArchive(store PageStore, attachments AttachmentStore, reader io.Reader, policy ImportPolicy) (*ArchiveReport, error) {
+	decompressor, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, InvalidArchiveError{err.Error()}
+	}
+	archive := tar.NewReader(decompressor)
+
+	header, err := archive.Next()
+	if err != nil || header.Name != ARCHIVE_MANIFEST {
+		return nil, InvalidArchiveError{"missing manifest"}
+	}
+	var manifest archiveManifest
+	err = json.NewDecoder(archive).Decode(&manifest)
+	if err != nil {
+		return nil, InvalidArchiveError{"invalid manifest: " + err.Error()}
+	}
+	if manifest.FormatVersion != ARCHIVE_FORMAT_VERSION {
+		return nil, InvalidArchiveError{fmt.Sprintf("unsupported format version %d", manifest.FormatVersion)}
+	}
+
+	pages, header, err := readArchivedPages(archive)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) != manifest.Pages {
+		return nil, InvalidArchiveError{fmt.Sprintf("expected %d pages, found %d", manifest.Pages, len(pages))}
+	}
+	if policy == IMPORT_FAIL {
+		for _, archived := range pages {
+			exists, err := pageExists(store, archived.Page.Id)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				return nil, ExistingPageError{archived.Page.Id}
+			}
+		}
+	}
+
+	report := &ArchiveReport{}
+	skipped := make(map[PageId]bool)
+	for _, archived := range pages {
+		err = importArchivedPage(store, attachments, archived, policy, report, skipped)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	for header != nil {
+		switch {
+		case header.Typeflag == tar.TypeDir:
+		case strings.HasPrefix(header.Name, ARCHIVE_ATTACHMENTS_DIR):
+			err = importArchivedAttachment(attachments, header.Name, archive, report, skipped)
+		default:  // including pages after the attachments
+			err = InvalidArchiveError{fmt.Sprintf("unexpected file %q", header.Name)}
+		}
+		if err != nil {
+			return report, err
+		}
+
+		header, err = archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, InvalidArchiveError{err.Error()}
+		}
+	}
+	return report, nil
+}
+
+// Reads the pages at the start of an archive, returning them with the header of the file after them, or
+// nil if there is none
+func readArchivedPages(archive *tar.Reader) ([]*archivedPage, *tar.Header, error) {
+	var pages []*archivedPage
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return pages, nil, nil
+		}
+		if err != nil {
+			return nil, nil, InvalidArchiveError{err.Error()}
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if !strings.HasPrefix(header.Name, ARCHIVE_PAGES_DIR) {
+			return pages, header, nil
+		}
+
+		var archived archivedPage
+		err = json.NewDecoder(archive).Decode(&archived)
+		if err != nil || archived.Page == nil {
+			return nil, nil, InvalidArchiveError{fmt.Sprintf("invalid page: %v", err)}
+		}
+		if PageChecksum(archived.Page, archived.Revisions) != archived.Checksum {
+			return nil, nil, InvalidArchiveError{fmt.Sprintf("damaged page %q", archived.Page.Id)}
+		}
+		pages = append(pages, &archived)
+	}
+}
+
+func pageExists(store PageStore, id PageId) (bool, error) {
+	_, err := store.Read(id)
+	if _, unexistent := err.(UnexistentPageError); unexistent {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func importArchivedPage(store PageStore, attachments AttachmentStore, archived *archivedPage, policy ImportPolicy,
+		report *ArchiveReport, skipped map[PageId]bool) error {
+	page := archived.Page
+	exists, err := pageExists(store, page.Id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		switch policy {
+		case IMPORT_SKIP:
+			skipped[page.Id] = true
+			report.Skipped++
+			return nil
+		case IMPORT_FAIL:
+			return ExistingPageError{page.Id}
+		}
+	}
+
+	err = store.Import(page, archived.Revisions)
+	if err != nil {
+		return err
+	}
+	if exists && attachments != nil {  // replaced by those in the archive
+		err = attachments.DeleteAll(page.Id)
+		if err != nil {
+			return err
+		}
+	}
+	report.Pages++
+	if exists {
+		report.Replaced++
+	}
+	return nil
+}
+
+func importArchivedAttachment(attachments AttachmentStore, name string, reader io.Reader, report *ArchiveReport,
+		skipped map[PageId]bool) error {
+	id, attachmentName, found := strings.Cut(strings.TrimPrefix(name, ARCHIVE_ATTACHMENTS_DIR), "/")
+	if !found || !ValidPageId(PageId(id)) {
+		return InvalidArchiveError{fmt.Sprintf("unexpected file %q", name)}
+	}
+	if attachments == nil || skipped[PageId(id)] {
+		_, err := io.Copy(ioutil.Discard, reader)
+		return err
+	}
+
+	_, err := attachments.Put(PageId(id), attachmentName, reader)
+	if err != nil {
+		return err
+	}
+	report.Attachments++
+	return nil
+}
+
+// Returned when importing an archive that was not written by ExportArchive, or that is damaged
+type InvalidArchiveError struct {
+    Reason string
+}
+
+func (err InvalidArchiveError) Error() string {
+    return "invalid archive: " + err.Reason
+}
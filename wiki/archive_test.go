@@ -0,0 +1,111 @@
+package wiki
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func mustExport(t *testing.T, store PageStore, attachments AttachmentStore) []byte {
+	var archive bytes.Buffer
+	_, err := ExportArchive(store, attachments, &archive)
+	if err != nil {
+		t.Fatalf("ExportArchive: %s", err)
+	}
+	return archive.Bytes()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	store, attachments := NewMemoryStore(), NewMemoryAttachmentStore()
+	root := mustCreateChild(t, store, "Root", "")
+	child := mustCreateChild(t, store, "Child", root)
+	_, err := attachments.Put(child, "notes.txt", strings.NewReader("Some notes"))
+	if err != nil {
+		t.Fatalf("AttachmentStore.Put: %s", err)
+	}
+
+	var archive bytes.Buffer
+	report, err := ExportArchive(store, attachments, &archive)
+	if err != nil || report.Pages != 2 || report.Attachments != 1 {
+		t.Fatalf("ExportArchive: expected 2 pages and 1 attachment, found %+v (%v)", report, err)
+	}
+
+	imported, importedAttachments := NewMemoryStore(), NewMemoryAttachmentStore()
+	report, err = ImportArchive(imported, importedAttachments, &archive, IMPORT_FAIL)
+	if err != nil || report.Pages != 2 || report.Attachments != 1 {
+		t.Fatalf("ImportArchive: expected 2 pages and 1 attachment, found %+v (%v)", report, err)
+	}
+	err = VerifyMigration(store, imported)
+	if err != nil {
+		t.Errorf("ImportArchive: %s", err)
+	}
+	content, _, err := importedAttachments.Open(child, "notes.txt")
+	if err != nil {
+		t.Fatalf("AttachmentStore.Open: %s", err)
+	}
+	defer content.Close()
+	if data, _ := ioutil.ReadAll(content); string(data) != "Some notes" {
+		t.Errorf("ImportArchive: unexpected attachment content %q", data)
+	}
+}
+
+func TestImportArchivePolicies(t *testing.T) {
+	store := NewMemoryStore()
+	id := mustCreateChild(t, store, "Page", "")
+	archive := mustExport(t, store, nil)
+
+	page, _ := store.Read(id)
+	page.Body = "Changed"
+	store.Update(page)
+
+	_, err := ImportArchive(store, nil, bytes.NewReader(archive), IMPORT_FAIL)
+	if existingErr, ok := err.(ExistingPageError); !ok || existingErr.Id != id {
+		t.Errorf("ImportArchive: expected ExistingPageError for page %q, found %v", id, err)
+	}
+
+	other := NewMemoryStore()
+	otherId := mustCreateChild(t, other, "Other page", "")
+	if err := other.Import(page, nil); err != nil {
+		t.Fatalf("PageStore.Import: %s", err)
+	}
+	_, err = ImportArchive(store, nil, bytes.NewReader(mustExport(t, other, nil)), IMPORT_FAIL)
+	if _, ok := err.(ExistingPageError); !ok {
+		t.Errorf("ImportArchive: expected ExistingPageError for page %q, found %v", id, err)
+	}
+	if _, err := store.Read(otherId); err == nil {
+		t.Errorf("ImportArchive: page %q was imported despite the failure", otherId)
+	}
+
+	report, err := ImportArchive(store, nil, bytes.NewReader(archive), IMPORT_SKIP)
+	if err != nil || report.Skipped != 1 || report.Pages != 0 {
+		t.Errorf("ImportArchive: expected 1 page skipped, found %+v (%v)", report, err)
+	}
+	if page, _ := store.Read(id); page.Body != "Changed" {
+		t.Errorf("ImportArchive: skipped page %q was replaced", id)
+	}
+
+	report, err = ImportArchive(store, nil, bytes.NewReader(archive), IMPORT_REPLACE)
+	if err != nil || report.Replaced != 1 {
+		t.Errorf("ImportArchive: expected 1 page replaced, found %+v (%v)", report, err)
+	}
+	if page, _ := store.Read(id); page.Body == "Changed" {
+		t.Errorf("ImportArchive: page %q was not replaced", id)
+	}
+}
+
+func TestImportInvalidArchive(t *testing.T) {
+	_, err := ImportArchive(NewMemoryStore(), nil, strings.NewReader("not an archive"), IMPORT_FAIL)
+	if _, ok := err.(InvalidArchiveError); !ok {
+		t.Errorf("ImportArchive: expected InvalidArchiveError, found %v", err)
+	}
+
+	store := NewMemoryStore()
+	mustCreateChild(t, store, "Page", "")
+	archive := mustExport(t, store, nil)
+	truncated := archive[:len(archive)/2]
+	_, err = ImportArchive(NewMemoryStore(), nil, bytes.NewReader(truncated), IMPORT_FAIL)
+	if err == nil {
+		t.Errorf("ImportArchive: expected an error importing a truncated archive")
+	}
+}
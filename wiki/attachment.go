@@ -0,0 +1,221 @@
+package wiki
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Storage strategy for the files attached to wiki pages, kept apart from the pages themselves
+type AttachmentStore interface {
+	Put(page PageId, name string, content io.Reader) (*Attachment, error)  // replaces any attachment with the same name
+	Open(page PageId, name string) (io.ReadSeekCloser, *Attachment, error)
+	List(page PageId) ([]*Attachment, error)  // sorted by name
+	Delete(page PageId, name string) error
+	DeleteAll(page PageId) error  // when the page is purged
+}
+
+type Attachment struct {
+	Name		string
+	Size		int64
+	Modified	time.Time
+}
+
+const (
+	MAX_ATTACHMENT_NAME_LEN = 255
+	DEFAULT_ATTACHMENT_NAME = "attachment"
+)
+
+var (
+	attachmentNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+	invalidAttachmentNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+)
+
+// Content type of the attachment, as told by its extension
+func (attachment *Attachment) ContentType() string {
+	return mime.TypeByExtension(path.Ext(attachment.Name))
+}
+
+// Images are embedded in pages; other attachments are linked
+func (attachment *Attachment) IsImage() bool {
+	return strings.HasPrefix(attachment.ContentType(), "image/")
+}
+
+// Attachment names are used as they are in file names and URLs: they are made of ASCII letters,
+// digits, '.', '_' and '-', and do not start with a dot. They cannot look like temporary files either,
+// which are removed when the store is opened.
+func ValidAttachmentName(name string) bool {
+	return len(name) <= MAX_ATTACHMENT_NAME_LEN && attachmentNamePattern.MatchString(name) && !isTempFile(name)
+}
+
+// Turns the name of an uploaded file into a valid attachment name
+func SanitizeAttachmentName(filename string) string {
+	name := path.Base(strings.Replace(filename, "\\", "/", -1))  // browsers may send full paths
+	name = invalidAttachmentNameChars.ReplaceAllString(strings.Join(strings.Fields(name), "-"), "")
+	name = strings.TrimLeft(name, ".")
+	for isTempFile(name) {  // replacing may form the infix again, as in "a.tmp.tmp-"
+		name = strings.Replace(name, TEMP_FILE_INFIX, "-"+TEMP_FILE_INFIX[1:], -1)
+	}
+	if len(name) > MAX_ATTACHMENT_NAME_LEN {
+		name = name[len(name)-MAX_ATTACHMENT_NAME_LEN:]  // keeping the extension
+		name = strings.TrimLeft(name, ".")
+	}
+	if name == "" {
+		name = DEFAULT_ATTACHMENT_NAME
+	}
+	return name
+}
+
+// Purges the pages deleted before the given time from the trash, together with their attachments
+func PurgeTrashWithAttachments(store PageStore, attachments AttachmentStore, before time.Time) (int, error) {
+	trash, err := store.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, trashed := range trash {
+		if !trashed.Deleted.Before(before) {
+			continue
+		}
+		err = store.Purge(trashed.Id)
+		if _, ok := err.(NotInTrashError); ok {  // restored or purged meanwhile
+			continue
+		}
+		if err == nil {
+			err = attachments.DeleteAll(trashed.Id)
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Attachments are stored in a directory per page, named after its id
+type diskAttachmentStore struct {
+	path string
+}
+
+// Opens an attachment store in the given directory, creating it if needed
+func NewDiskAttachmentStore(path string) (AttachmentStore, error) {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = removeTempFiles(path, TEMP_FILE_MIN_AGE)
+	if err != nil {
+		return nil, err
+	}
+	return &diskAttachmentStore{path}, nil
+}
+
+func (store *diskAttachmentStore) Put(page PageId, name string, content io.Reader) (*Attachment, error) {
+	if !ValidAttachmentName(name) {
+		return nil, InvalidAttachmentNameError{name}
+	}
+
+	err := os.MkdirAll(store.getPageDir(page), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := store.getFilename(page, name)
+	_, err = copyToFileAtomically(filename, content, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Attachment{Name: name, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (store *diskAttachmentStore) Open(page PageId, name string) (io.ReadSeekCloser, *Attachment, error) {
+	if !ValidAttachmentName(name) {
+		return nil, nil, UnexistentAttachmentError{page, name}
+	}
+
+	file, err := os.Open(store.getFilename(page, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, UnexistentAttachmentError{page, name}
+		}
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, &Attachment{Name: name, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (store *diskAttachmentStore) List(page PageId) ([]*Attachment, error) {
+	files, err := ioutil.ReadDir(store.getPageDir(page))
+	if err != nil {
+		if os.IsNotExist(err) {  // nothing attached yet
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var result []*Attachment
+	for _, file := range files {  // already sorted by name
+		if file.Mode().IsRegular() && ValidAttachmentName(file.Name()) {
+			result = append(result, &Attachment{Name: file.Name(), Size: file.Size(), Modified: file.ModTime()})
+		}
+	}
+	return result, nil
+}
+
+func (store *diskAttachmentStore) Delete(page PageId, name string) error {
+	if !ValidAttachmentName(name) {
+		return UnexistentAttachmentError{page, name}
+	}
+
+	err := os.Remove(store.getFilename(page, name))
+	if os.IsNotExist(err) {
+		return UnexistentAttachmentError{page, name}
+	}
+	return err
+}
+
+func (store *diskAttachmentStore) DeleteAll(page PageId) error {
+	return os.RemoveAll(store.getPageDir(page))
+}
+
+func (store *diskAttachmentStore) getPageDir(page PageId) string {
+	return store.path + "/" + string(page)
+}
+
+func (store *diskAttachmentStore) getFilename(page PageId, name string) string {
+	return store.getPageDir(page) + "/" + name
+}
+
+type UnexistentAttachmentError struct {
+    Page PageId
+    Name string
+}
+
+func (err UnexistentAttachmentError) Error() string {
+    return fmt.Sprintf("unexistent attachment %q of page %q", err.Name, err.Page)
+}
+
+type InvalidAttachmentNameError struct {
+    Name string
+}
+
+func (err InvalidAttachmentNameError) Error() string {
+    return fmt.Sprintf("invalid attachment name %q", err.Name)
+}
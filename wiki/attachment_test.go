@@ -0,0 +1,63 @@
+package wiki
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeAttachmentName(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"screen.png": "screen.png",
+		"Screen Shot 2020-01-01.png": "Screen-Shot-2020-01-01.png",
+		"C:\\Users\\me\\build.log": "build.log",
+		"../../etc/passwd": "passwd",
+		".htaccess": "htaccess",
+		"informe-año.pdf": "informe-ao.pdf",
+		"???": DEFAULT_ATTACHMENT_NAME,
+		"report.tmp-2024.txt": "report-tmp-2024.txt",
+		"a.tmp.tmp-1": "a-tmp-tmp-1",
+	} {
+		found := SanitizeAttachmentName(filename)
+		if found != expected {
+			t.Errorf("SanitizeAttachmentName(%q): expected %q, found %q", filename, expected, found)
+		}
+		if !ValidAttachmentName(found) {
+			t.Errorf("SanitizeAttachmentName(%q): invalid name %q", filename, found)
+		}
+	}
+}
+
+func TestAttachmentNamesLikeTempFiles(t *testing.T) {
+	path := t.TempDir()
+	store, err := NewDiskAttachmentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Put("abc", "report"+TEMP_FILE_INFIX+"2024.txt", strings.NewReader("Report"))
+	if _, ok := err.(InvalidAttachmentNameError); !ok {
+		t.Errorf("Put: expected InvalidAttachmentNameError, found %v", err)
+	}
+
+	name := SanitizeAttachmentName("report" + TEMP_FILE_INFIX + "2024.txt")
+	_, err = store.Put("abc", name, strings.NewReader("Report"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)  // older than the temporary files left by interrupted writes
+	err = os.Chtimes(filepath.Join(path, "abc", name), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewDiskAttachmentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.List("abc")
+	if err != nil || len(list) != 1 || list[0].Name != name {
+		t.Errorf("List after reopening: expected %q, found %v (%v)", name, list, err)
+	}
+}
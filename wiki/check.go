@@ -0,0 +1,486 @@
+package wiki
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Damaged and stray files are moved into this directory of the store, keeping their relative paths
+const QUARANTINE_DIR = "quarantine"
+
+// What Check does about the problems found
+type CheckMode int
+
+const (
+	CHECK_REPORT CheckMode = iota  // only report them
+	CHECK_QUARANTINE  // move the damaged and stray files into the quarantine directory
+	CHECK_REPAIR  // repair what can be repaired, and quarantine the rest
+)
+
+var checkModeNames = map[string]CheckMode{
+	"report": CHECK_REPORT,
+	"quarantine": CHECK_QUARANTINE,
+	"repair": CHECK_REPAIR,
+}
+
+// Parses the name of a check mode: report, quarantine or repair
+func ParseCheckMode(name string) (CheckMode, error) {
+	mode, found := checkModeNames[name]
+	if !found {
+		return 0, fmt.Errorf("unknown check mode %q", name)
+	}
+	return mode, nil
+}
+
+type ProblemKind int
+
+const (
+	INVALID_PAGE_FILE ProblemKind = iota  // not a JSON page; repaired from the history of the page
+	MISMATCHED_PAGE_ID  // the page has another id than its file; repaired by setting the id of the file
+	INVALID_REVISION_FILE  // repaired from the page, if it is its current revision
+	STRAY_FILE  // not written by the store; the history of a page in the trash is repaired by moving it there
+	DANGLING_PARENT  // repaired by moving the page to the top level
+	DANGLING_LINK  // to an unexistent page, which cannot be repaired
+	DUPLICATE_TITLE  // shared with another page since before titles were unique; renamed by hand
+)
+
+var problemKindNames = []string{"invalid page file", "mismatched page id", "invalid revision file", "stray file",
+	"dangling parent", "dangling link", "duplicate title"}
+
+func (kind ProblemKind) String() string {
+	return problemKindNames[kind]
+}
+
+type Problem struct {
+	Kind		ProblemKind
+	Filename	string  // relative to the storage directory
+	Id			PageId  // of the page with the problem, "" for stray files
+	Detail		string
+	Fix			string  // what was done about the problem, "" if nothing was done
+}
+
+type CheckReport struct {
+	Pages		int  // page files checked
+	Problems	[]*Problem  // sorted by filename
+}
+
+// Links to pages have the format of the ids given by NewPageId; other references are titles
+var pageIdReferencePattern = regexp.MustCompile(fmt.Sprintf(`^[0-9a-f]{%d}$`, 2*PAGE_ID_LEN))
+
+// Scans the storage directory of a disk store for page and revision files that cannot be read, pages
+// whose id does not match their file, stray files, parents and links to unexistent pages, and titles
+// shared by several pages, as matched with the given normalization, and does about them what the mode
+// says. Pages are locked while they are repaired, but the repairs are decided from a scan that does
+// not lock them, so this is best run while the wiki is not being edited. The trash is not checked.
+// Reports leave the directory as it is, with the temporary files of interrupted writes among the
+// stray files; the other modes first remove them as opening the store does.
+func Check(path string, mode CheckMode, normalization TitleNormalization) (*CheckReport, error) {
+	store, err := openCheckedStore(path, mode, normalization)
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &checker{store: store, mode: mode, report: &CheckReport{}, pages: make(map[PageId]*Page),
+		exists: make(map[PageId]bool), trashed: make(map[PageId]bool)}
+	var histories []PageId
+	var strays []string
+	ignored := func(name string, file os.FileInfo) bool {
+		return name == LOCK_DIR || name == TRASH_DIR || name == QUARANTINE_DIR || name == LAYOUT_FILE ||
+			isTempFile(file.Name()) && time.Since(file.ModTime()) < TEMP_FILE_MIN_AGE  // may belong to a write in progress
+	}
+	err = readPageDirs(path, store.layout, func(dir string, files []os.FileInfo) error {
+		for _, file := range files {
+			name := dir + file.Name()
+			id, suffix := pageFileId(file)
+			switch {
+			case ignored(name, file):
+				continue
+			case suffix == "" || store.layout.pageDir(id) != dir:  // or in the wrong shard
+				strays = append(strays, name)
+			case suffix == FILE_SUFFIX:
+				checker.exists[id] = true
+			default:
+				histories = append(histories, id)
+			}
+		}
+		return nil
+	}, func(name string, file os.FileInfo) {
+		if !ignored(name, file) {
+			strays = append(strays, name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = checker.listTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]PageId, 0, len(checker.exists))
+	for id := range checker.exists {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		err = checker.checkPage(id)
+		if err != nil {
+			return checker.report, err
+		}
+	}
+	checker.report.Pages = len(ids)
+
+	for _, id := range histories {
+		if _, err := os.Stat(checker.store.getHistoryDir(id)); checker.exists[id] || os.IsNotExist(err) {  // or quarantined with its page
+			continue
+		}
+		err = checker.checkOrphanHistory(id)
+		if err != nil {
+			return checker.report, err
+		}
+	}
+	for _, name := range strays {
+		err = checker.add(STRAY_FILE, name, "", describeStray(name, "not a page file"), checker.quarantine(name), nil)
+		if err != nil {
+			return checker.report, err
+		}
+	}
+
+	err = checker.checkReferences()
+	if err == nil {
+		err = checker.checkTitles()
+	}
+	sort.SliceStable(checker.report.Problems, func(i, j int) bool {
+		return checker.report.Problems[i].Filename < checker.report.Problems[j].Filename
+	})
+	return checker.report, err
+}
+
+// Opens the store to check. Reports need no indexes or locks, which are only used to write, and opening
+// the store would remove temporary files and create the lock directory.
+func openCheckedStore(path string, mode CheckMode, normalization TitleNormalization) (*diskStore, error) {
+	if mode != CHECK_REPORT {
+		return openDiskStore(path, FLAT_LAYOUT, normalization)
+	}
+	layout, _, err := findLayout(path, FLAT_LAYOUT)
+	if err != nil {
+		return nil, err
+	}
+	return &diskStore{path: path, layout: layout, titles: newTitleIndex(normalization), generation: -1}, nil
+}
+
+func describeStray(name string, detail string) string {
+	if isTempFile(filepath.Base(name)) {
+		return "temporary file left by an interrupted write"
+	}
+	return detail
+}
+
+type checker struct {
+	store	*diskStore
+	mode	CheckMode
+	report	*CheckReport
+	pages	map[PageId]*Page  // those that could be read, by the id of their file
+	exists	map[PageId]bool  // pages with a file, unless it was quarantined
+	trashed	map[PageId]bool
+}
+
+func (checker *checker) listTrash() error {
+	files, err := ioutil.ReadDir(checker.store.getTrashDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), FILE_SUFFIX) {
+			checker.trashed[PageId(strings.TrimSuffix(file.Name(), FILE_SUFFIX))] = true
+		}
+	}
+	return nil
+}
+
+// Reports a problem, and fixes it by quarantine or repair, as the mode says; either may be nil if not possible
+func (checker *checker) add(kind ProblemKind, filename string, id PageId, detail string, quarantine, repair func() (string, error)) error {
+	problem := &Problem{kind, filename, id, detail, ""}
+	checker.report.Problems = append(checker.report.Problems, problem)
+
+	fix := quarantine
+	if checker.mode == CHECK_REPAIR && repair != nil {
+		fix = repair
+	}
+	if checker.mode == CHECK_REPORT || fix == nil {
+		return nil
+	}
+	var err error
+	problem.Fix, err = fix()
+	return err
+}
+
+// Writes to the files of a page holding its lock and the index lock, so that other processes see the changes
+func (checker *checker) write(id PageId, write func() error) error {
+	unlock, err := checker.store.locks.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return checker.store.updateIndex(write)
+}
+
+// Moves a file into the quarantine directory, next to any previous file with the same name
+func (checker *checker) moveToQuarantine(filename string) (string, error) {
+	quarantined := filepath.Join(QUARANTINE_DIR, filename)
+	target := filepath.Join(checker.store.path, quarantined)
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(target); err == nil {
+		quarantined += "." + time.Now().Format("20060102150405.000000000")
+		target = filepath.Join(checker.store.path, quarantined)
+	}
+	return "moved to " + quarantined, os.Rename(filepath.Join(checker.store.path, filename), target)
+}
+
+func (checker *checker) quarantine(filename string) func() (string, error) {
+	return func() (string, error) {
+		return checker.moveToQuarantine(filename)
+	}
+}
+
+// Quarantines the file of a page with its history, if any
+func (checker *checker) quarantinePage(id PageId) func() (string, error) {
+	return func() (fix string, err error) {
+		err = checker.write(id, func() error {
+			fix, err = checker.moveToQuarantine(checker.store.getRelativeFilename(id, FILE_SUFFIX))
+			if err != nil {
+				return err
+			}
+			_, err = os.Stat(checker.store.getHistoryDir(id))
+			if os.IsNotExist(err) {
+				return nil
+			}
+			_, err = checker.moveToQuarantine(checker.store.getRelativeFilename(id, HISTORY_SUFFIX))
+			fix += ", with its history"
+			return err
+		})
+		delete(checker.exists, id)
+		delete(checker.pages, id)
+		return fix, err
+	}
+}
+
+func (checker *checker) checkPage(id PageId) error {
+	filename := checker.store.getRelativeFilename(id, FILE_SUFFIX)
+	content, err := ioutil.ReadFile(checker.store.getPageFilename(id))
+	if err != nil {
+		return err
+	}
+	var page *Page
+	jsonErr := json.Unmarshal(content, &page)
+	if jsonErr != nil || page == nil {
+		revisions, err := checker.checkHistory(id, nil)
+		if err != nil {
+			return err
+		}
+		detail := "not a page"
+		if jsonErr != nil {
+			detail = jsonErr.Error()
+		}
+		return checker.add(INVALID_PAGE_FILE, filename, id, detail, checker.quarantinePage(id), checker.restorePage(id, revisions))
+	}
+
+	checker.pages[id] = page
+	if page.Id != id {
+		err = checker.add(MISMATCHED_PAGE_ID, filename, id, fmt.Sprintf("the file holds page %q", page.Id),
+			checker.quarantinePage(id), func() (string, error) {
+				page.Id = id
+				return "page id set to that of the file", checker.write(id, func() error {
+					return checker.store.writePageToFile(page)
+				})
+			})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = checker.checkHistory(id, checker.pages[id])
+	return err
+}
+
+// Restores a page from the last revision in its history, quarantining the damaged file, or quarantines
+// the page if it has no history
+func (checker *checker) restorePage(id PageId, revisions []*Revision) func() (string, error) {
+	if len(revisions) == 0 {
+		return checker.quarantinePage(id)
+	}
+	return func() (string, error) {
+		first, last := revisions[0], revisions[len(revisions)-1]
+		page := &Page{Id: id, Title: last.Title, Body: last.Body, Version: last.Number,
+			Created: first.Timestamp, Modified: last.Timestamp, Author: last.Author}
+		err := checker.write(id, func() error {
+			_, err := checker.moveToQuarantine(checker.store.getRelativeFilename(id, FILE_SUFFIX))
+			if err != nil {
+				return err
+			}
+			return checker.store.writePageToFile(page)
+		})
+		checker.pages[id] = page
+		return fmt.Sprintf("restored from revision %d, without tags or parent; the damaged file was quarantined", last.Number), err
+	}
+}
+
+// Checks the revision files of a page, returning the readable ones sorted by number. The current
+// revision can be repaired from the page, if it was read.
+func (checker *checker) checkHistory(id PageId, page *Page) ([]*Revision, error) {
+	historyDir := checker.store.getHistoryDir(id)
+	files, err := ioutil.ReadDir(historyDir)
+	if os.IsNotExist(err) {  // pages written before history was kept have none
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []*Revision
+	for _, file := range files {
+		name := file.Name()
+		filename := checker.store.getRelativeFilename(id, HISTORY_SUFFIX) + "/" + name
+		number, numberErr := strconv.Atoi(strings.TrimSuffix(name, FILE_SUFFIX))
+		if isTempFile(name) && time.Since(file.ModTime()) < TEMP_FILE_MIN_AGE {
+			continue
+		}
+		if !file.Mode().IsRegular() || !strings.HasSuffix(name, FILE_SUFFIX) || numberErr != nil {
+			err = checker.add(STRAY_FILE, filename, id, describeStray(name, "not a revision file"), checker.quarantine(filename), nil)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		revision, err := readRevisionFromFile(historyDir + "/" + name)
+		corrupted, isCorrupted := err.(CorruptedFileError)
+		if err != nil && !isCorrupted {
+			return nil, err
+		}
+		var detail string
+		switch {
+		case isCorrupted:
+			detail = corrupted.Cause.Error()
+		case revision.Number != number:
+			detail = fmt.Sprintf("the file holds revision %d", revision.Number)
+		default:
+			revisions = append(revisions, revision)
+			continue
+		}
+
+		var repair func() (string, error)
+		if page != nil && page.Version == number {
+			repair = func() (string, error) {
+				return "rewritten from the page", checker.write(id, func() error {
+					return checker.store.writeRevision(page, number, page.Modified)
+				})
+			}
+		}
+		err = checker.add(INVALID_REVISION_FILE, filename, id, detail, checker.quarantine(filename), repair)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Sort(revisionsByNumber(revisions))
+	return revisions, nil
+}
+
+// A history without a page is left behind by a deletion interrupted between moving the page and its
+// history to the trash
+func (checker *checker) checkOrphanHistory(id PageId) error {
+	filename := checker.store.getRelativeFilename(id, HISTORY_SUFFIX)
+	_, err := os.Stat(checker.store.getTrashedFilename(id, HISTORY_SUFFIX))
+	if !checker.trashed[id] || !os.IsNotExist(err) {
+		return checker.add(STRAY_FILE, filename, id, "history of an unexistent page", checker.quarantine(filename), nil)
+	}
+
+	return checker.add(STRAY_FILE, filename, id, "history of a page in the trash", checker.quarantine(filename),
+		func() (string, error) {
+			return "moved to the trash", checker.write(id, func() error {
+				return os.Rename(checker.store.getHistoryDir(id), checker.store.getTrashedFilename(id, HISTORY_SUFFIX))
+			})
+		})
+}
+
+// Checks the parents and the links of the pages that could be read
+func (checker *checker) checkReferences() error {
+	for _, id := range checker.sortedPages() {
+		page := checker.pages[id]
+		filename := checker.store.getRelativeFilename(id, FILE_SUFFIX)
+		if page.Parent != "" && !checker.exists[page.Parent] {
+			err := checker.add(DANGLING_PARENT, filename, id, "the parent is "+checker.describeMissing(page.Parent), nil,
+				func() (string, error) {
+					page.Parent = ""
+					return "moved to the top level", checker.write(id, func() error {
+						return checker.store.writePageToFile(page)
+					})
+				})
+			if err != nil {
+				return err
+			}
+		}
+
+		reported := make(map[string]bool)
+		for _, link := range pageLinkPattern.FindAllString(page.Body, -1) {
+			ref := parseLink(link).ref
+			if !pageIdReferencePattern.MatchString(ref) || checker.exists[PageId(ref)] || reported[ref] {
+				continue
+			}
+			reported[ref] = true
+			err := checker.add(DANGLING_LINK, filename, id, "links to "+checker.describeMissing(PageId(ref)), nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the ids of the pages that could be read, sorted
+func (checker *checker) sortedPages() []PageId {
+	ids := make([]PageId, 0, len(checker.pages))
+	for id := range checker.pages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Reports the pages that share the title of a page with a lower id, which keeps it
+func (checker *checker) checkTitles() error {
+	owners := make(map[string]PageId)
+	for _, id := range checker.sortedPages() {
+		page := checker.pages[id]
+		title := checker.store.titles.normalization.Normalize(page.Title)
+		owner, found := owners[title]
+		if !found {
+			owners[title] = id
+			continue
+		}
+		err := checker.add(DUPLICATE_TITLE, checker.store.getRelativeFilename(id, FILE_SUFFIX), id,
+			fmt.Sprintf("the title %q is also that of page %q", page.Title, owner), nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (checker *checker) describeMissing(id PageId) string {
+	if checker.trashed[id] {
+		return fmt.Sprintf("page %q, which is in the trash", id)
+	}
+	return fmt.Sprintf("unexistent page %q", id)
+}
@@ -0,0 +1,232 @@
+package wiki
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Damages a store with a page of each kind of problem, returning their ids
+func setupDamagedStore(t *testing.T) (store *diskStore, corrupted, mismatched, orphan PageId) {
+	store = setupPageStore()
+	mustCreate := func(page *Page) PageId {
+		id, err := store.Create(page)
+		if err != nil {
+			t.Fatalf("PageStore.Create: %s", err)
+		}
+		return id
+	}
+	write := func(filename string, content []byte) {
+		err := ioutil.WriteFile(filepath.Join(store.path, filename), content, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	corrupted = mustCreate(&Page{Title: "Corrupted", Body: "Original body"})
+	mismatched = mustCreate(&Page{Title: "Mismatched", Body: "Links to [" + string(corrupted) + "][] and [abcdef012345][]."})
+	orphan = mustCreate(&Page{Title: "Orphan", Parent: mismatched})
+
+	write(string(corrupted)+FILE_SUFFIX, []byte("{not json"))
+	page, _ := store.Read(mismatched)
+	page.Id = "000000000000"
+	content, _ := json.Marshal(page)
+	write(string(mismatched)+FILE_SUFFIX, content)
+	page, _ = store.Read(orphan)
+	page.Parent = "abcdef012345"
+	content, _ = json.Marshal(page)
+	write(string(orphan)+FILE_SUFFIX, content)
+	write(string(orphan)+HISTORY_SUFFIX+"/1"+FILE_SUFFIX, nil)
+	write("notes.txt", []byte("Stray"))
+	return store, corrupted, mismatched, orphan
+}
+
+type expectedProblem struct {
+	filename	string
+	kind		ProblemKind
+}
+
+func assertProblems(t *testing.T, report *CheckReport, expected []expectedProblem) {
+	if len(report.Problems) != len(expected) {
+		t.Errorf("Check: expected %d problems, found %d", len(expected), len(report.Problems))
+	}
+	found := make(map[expectedProblem]bool)
+	for _, problem := range report.Problems {
+		found[expectedProblem{problem.Filename, problem.Kind}] = true
+	}
+	for _, problem := range expected {
+		if !found[problem] {
+			t.Errorf("Check: expected %s in %s", problem.kind, problem.filename)
+		}
+	}
+}
+
+func TestCheckReport(t *testing.T) {
+	store, corrupted, mismatched, orphan := setupDamagedStore(t)
+	defer cleanPageStore(store)
+
+	report, err := Check(store.path, CHECK_REPORT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	if report.Pages != 3 {
+		t.Errorf("Check: expected 3 pages, found %d", report.Pages)
+	}
+	assertProblems(t, report, []expectedProblem{
+		{string(corrupted) + FILE_SUFFIX, INVALID_PAGE_FILE},
+		{string(mismatched) + FILE_SUFFIX, MISMATCHED_PAGE_ID},
+		{string(mismatched) + FILE_SUFFIX, DANGLING_LINK},
+		{string(orphan) + FILE_SUFFIX, DANGLING_PARENT},
+		{string(orphan) + HISTORY_SUFFIX + "/1" + FILE_SUFFIX, INVALID_REVISION_FILE},
+		{"notes.txt", STRAY_FILE},
+	})
+	for _, problem := range report.Problems {
+		if problem.Fix != "" {
+			t.Errorf("Check: unexpected fix %q when only reporting", problem.Fix)
+		}
+	}
+}
+
+func TestCheckRepair(t *testing.T) {
+	store, corrupted, mismatched, orphan := setupDamagedStore(t)
+	defer cleanPageStore(store)
+
+	report, err := Check(store.path, CHECK_REPAIR, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	for _, problem := range report.Problems {
+		if (problem.Fix == "") != (problem.Kind == DANGLING_LINK) {
+			t.Errorf("Check: unexpected fix %q of %s in %s", problem.Fix, problem.Kind, problem.Filename)
+		}
+	}
+
+	store, err = newDiskStore(store.path)  // with the repaired indexes
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Read(corrupted)
+	if err != nil || page.Body != "Original body" || page.Version != 1 {
+		t.Errorf("Check: expected the page to be restored from its history, found %+v (%v)", page, err)
+	}
+	page, err = store.Read(mismatched)
+	if err != nil || page.Title != "Mismatched" {
+		t.Errorf("Check: expected the page id to be repaired, found %+v (%v)", page, err)
+	}
+	page, err = store.Read(orphan)
+	if err != nil || page.Parent != "" {
+		t.Errorf("Check: expected the page to be moved to the top level, found %+v (%v)", page, err)
+	}
+	_, err = store.ReadRevision(orphan, 1)
+	if err != nil {
+		t.Errorf("Check: expected the revision to be rewritten (%v)", err)
+	}
+	_, err = os.Stat(filepath.Join(store.path, QUARANTINE_DIR, "notes.txt"))
+	if err != nil {
+		t.Errorf("Check: expected the stray file to be quarantined (%v)", err)
+	}
+
+	report, err = Check(store.path, CHECK_REPORT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	assertProblems(t, report, []expectedProblem{{string(mismatched) + FILE_SUFFIX, DANGLING_LINK}})
+}
+
+func TestCheckQuarantine(t *testing.T) {
+	store, corrupted, _, orphan := setupDamagedStore(t)
+	defer cleanPageStore(store)
+
+	_, err := Check(store.path, CHECK_QUARANTINE, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	for _, filename := range []string{string(corrupted) + FILE_SUFFIX, string(corrupted) + HISTORY_SUFFIX} {
+		_, err = os.Stat(filepath.Join(store.path, QUARANTINE_DIR, filename))
+		if err != nil {
+			t.Errorf("Check: expected %s to be quarantined (%v)", filename, err)
+		}
+	}
+
+	report, err := Check(store.path, CHECK_REPORT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	assertProblems(t, report, []expectedProblem{{string(orphan) + FILE_SUFFIX, DANGLING_PARENT}})
+}
+
+func TestCheckReportLeavesStoreAsIs(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	_, err := store.Create(&Page{Title: "Sample Page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftover := "notes.txt" + TEMP_FILE_INFIX + "123"
+	err = ioutil.WriteFile(filepath.Join(store.path, leftover), []byte("Partial"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * TEMP_FILE_MIN_AGE)
+	err = os.Chtimes(filepath.Join(store.path, leftover), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.RemoveAll(filepath.Join(store.path, LOCK_DIR))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(store.path, CHECK_REPORT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	assertProblems(t, report, []expectedProblem{{leftover, STRAY_FILE}})
+	_, err = os.Stat(filepath.Join(store.path, leftover))
+	if err != nil {
+		t.Errorf("Check: expected the temporary file to be kept when only reporting (%v)", err)
+	}
+	_, err = os.Stat(filepath.Join(store.path, LOCK_DIR))
+	if !os.IsNotExist(err) {
+		t.Errorf("Check: expected no lock directory to be created when only reporting (%v)", err)
+	}
+}
+
+func TestCheckDuplicateTitles(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	first, err := store.Create(&Page{Title: "Sample Page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Create(&Page{Title: "Other Page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := store.Read(second)
+	page.Title = "sample page"  // written before titles were unique
+	err = store.writePageToFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicate := first
+	if second > first {
+		duplicate = second
+	}
+
+	report, err := Check(store.path, CHECK_REPORT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	assertProblems(t, report, []expectedProblem{{string(duplicate) + FILE_SUFFIX, DUPLICATE_TITLE}})
+
+	report, err = Check(store.path, CHECK_REPORT, EXACT_TITLES)
+	if err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	assertProblems(t, report, nil)
+}
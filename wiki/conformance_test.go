@@ -0,0 +1,65 @@
+package wiki_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"github.com/joansais/go-practices/wiki"
+	"github.com/joansais/go-practices/wiki/storetest"
+)
+
+func TestDiskStoreConformance(t *testing.T) {
+	storetest.RunPageStoreTests(t, func(t *testing.T) wiki.PageStore {
+		store, err := wiki.NewDiskStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestShardedDiskStoreConformance(t *testing.T) {
+	storetest.RunPageStoreTests(t, func(t *testing.T) wiki.PageStore {
+		store, err := wiki.NewDiskStoreWith(t.TempDir(), wiki.SHARDED_LAYOUT, wiki.DEFAULT_TITLE_NORMALIZATION)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.RunPageStoreTests(t, func(t *testing.T) wiki.PageStore {
+		return wiki.NewMemoryStore()
+	})
+}
+
+func TestDiskAttachmentStoreConformance(t *testing.T) {
+	storetest.RunAttachmentStoreTests(t, func(t *testing.T) wiki.AttachmentStore {
+		store, err := wiki.NewDiskAttachmentStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestMemoryAttachmentStoreConformance(t *testing.T) {
+	storetest.RunAttachmentStoreTests(t, func(t *testing.T) wiki.AttachmentStore {
+		return wiki.NewMemoryAttachmentStore()
+	})
+}
+
+func TestEncryptedStoreConformance(t *testing.T) {
+	storetest.RunPageStoreTests(t, func(t *testing.T) wiki.PageStore {
+		keys, err := wiki.ParseKeyRing(strings.NewReader("k1 " + base64.StdEncoding.EncodeToString(make([]byte, wiki.KEY_LEN))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, err := wiki.NewEncryptedStore(wiki.NewMemoryStore(), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
@@ -0,0 +1,76 @@
+package wiki
+
+import (
+	"strings"
+)
+
+type DiffOp int
+
+const (
+	DIFF_EQUAL DiffOp = iota
+	DIFF_INSERT
+	DIFF_DELETE
+)
+
+type DiffLine struct {
+	Op		DiffOp
+	Text	string
+}
+
+func (line DiffLine) IsInsert() bool { return line.Op == DIFF_INSERT }
+func (line DiffLine) IsDelete() bool { return line.Op == DIFF_DELETE }
+
+// Computes a line-oriented diff between two texts, based on their longest common subsequence
+func diffLines(from, to string) []DiffLine {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var result []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			result = append(result, DiffLine{DIFF_EQUAL, a[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			result = append(result, DiffLine{DIFF_DELETE, a[i]})
+			i++
+		} else {
+			result = append(result, DiffLine{DIFF_INSERT, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, DiffLine{DIFF_DELETE, a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, DiffLine{DIFF_INSERT, b[j]})
+	}
+
+	return result
+}
+
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
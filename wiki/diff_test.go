@@ -0,0 +1,42 @@
+package wiki
+
+import (
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	from := "first line\r\nsecond line\r\nthird line\r\n"
+	to := "first line\r\nthird line\r\nfourth line\r\n"
+
+	expected := []DiffLine{
+		DiffLine{DIFF_EQUAL, "first line"},
+		DiffLine{DIFF_DELETE, "second line"},
+		DiffLine{DIFF_EQUAL, "third line"},
+		DiffLine{DIFF_INSERT, "fourth line"}}
+
+	obtained := diffLines(from, to)
+	if len(obtained) != len(expected) {
+		t.Errorf("diffLines: expected %d lines, obtained %d", len(expected), len(obtained))
+		return
+	}
+	for k := range expected {
+		if obtained[k] != expected[k] {
+			t.Errorf("diffLines: expected %v, obtained %v", expected[k], obtained[k])
+			return
+		}
+	}
+}
+
+func TestDiffLinesEmpty(t *testing.T) {
+	obtained := diffLines("", "single line")
+	if len(obtained) != 1 || obtained[0] != (DiffLine{DIFF_INSERT, "single line"}) {
+		t.Errorf("diffLines: expected a single inserted line, obtained %v", obtained)
+		return
+	}
+
+	obtained = diffLines("same text", "same text")
+	if len(obtained) != 1 || obtained[0] != (DiffLine{DIFF_EQUAL, "same text"}) {
+		t.Errorf("diffLines: expected a single equal line, obtained %v", obtained)
+		return
+	}
+}
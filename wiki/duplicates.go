@@ -0,0 +1,28 @@
+package wiki
+
+// Finds the titles shared by several pages, which may exist in stores written before titles
+// were unique. Titles are compared as the store matches them. Returns the ids of the
+// pages sharing each duplicated (normalized) title, sorted by id.
+func FindDuplicateTitles(store PageStore) (map[string][]PageId, error) {
+	pagesByTitle := make(map[string][]PageId)
+	iterator := IteratePages(store)
+	for iterator.Next() {
+		id := iterator.Id()
+		page, err := store.Read(id)
+		if err != nil {
+			return nil, err
+		}
+		title := store.TitleMatching().Normalize(page.Title)
+		pagesByTitle[title] = append(pagesByTitle[title], id)
+	}
+	if iterator.Err() != nil {
+		return nil, iterator.Err()
+	}
+
+	for title, ids := range pagesByTitle {
+		if len(ids) < 2 {
+			delete(pagesByTitle, title)
+		}
+	}
+	return pagesByTitle, nil
+}
@@ -0,0 +1,42 @@
+package wiki
+
+import (
+	"testing"
+)
+
+func TestFindDuplicateTitles(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	pages := []*Page{  // written directly, as titles were not unique before
+		&Page{Id: "000000000001", Title: "Shared Title", Body: "First page."},
+		&Page{Id: "000000000002", Title: "Unique Title", Body: "Second page."},
+		&Page{Id: "000000000003", Title: "shared  TITLE", Body: "Third page."}}
+	for _, page := range pages {
+		err := store.writePageToFile(page)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	store, err := newDiskStore(store.path)  // as the files were there when it was opened
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates, err := FindDuplicateTitles(store)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(duplicates) != 1 {
+		t.Errorf("FindDuplicateTitles: expected 1 duplicated title, found %d", len(duplicates))
+		return
+	}
+	ids := duplicates["shared title"]  // normalized
+	if len(ids) != 2 || ids[0] != "000000000001" || ids[1] != "000000000003" {
+		t.Errorf("FindDuplicateTitles: unexpected pages %v", ids)
+		return
+	}
+}
@@ -0,0 +1,690 @@
+package wiki
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key files hold a key per line, as an id and the base64 encoding of KEY_LEN random bytes, separated
+// by a space. The first key encrypts; the others only decrypt what was encrypted before it was added.
+// Lines starting with # are comments.
+const (
+	KEY_LEN = 32  // in bytes, for AES-256
+	KEY_ID_LEN = 4  // in bytes
+	ENCRYPTED_VALUE_PREFIX = "$aesgcm$"  // followed by the key id, $ and the base64 encoding of the nonce and ciphertext
+)
+
+type KeyRing struct {
+	keys	[]*encryptionKey  // the first one encrypts
+}
+
+type encryptionKey struct {
+	id		string
+	aead	cipher.AEAD
+}
+
+// Reads the keys of a key file
+func LoadKeyRing(filename string) (*KeyRing, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseKeyRing(file)
+}
+
+func ParseKeyRing(reader io.Reader) (*KeyRing, error) {
+	ring := &KeyRing{}
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 || !pageIdPattern.MatchString(fields[0]) || ids[fields[0]] {
+			return nil, InvalidKeyError{line, "expected a unique id and a key"}
+		}
+		secret, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(secret) != KEY_LEN {
+			return nil, InvalidKeyError{line, fmt.Sprintf("expected the base64 encoding of %d bytes", KEY_LEN)}
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys = append(ring.keys, &encryptionKey{fields[0], aead})
+		ids[fields[0]] = true
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+	if len(ring.keys) == 0 {
+		return nil, InvalidKeyError{0, "no keys"}
+	}
+	return ring, nil
+}
+
+// Generates a key and adds it at the beginning of a key file, creating it if needed, so that it
+// becomes the current key. Returns the id of the key.
+func AddKey(filename string) (string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id, line, err := newKeyLine()
+	if err != nil {
+		return "", err
+	}
+	content = append([]byte(line), content...)
+	_, err = ParseKeyRing(strings.NewReader(string(content)))  // do not write a key file that cannot be loaded
+	if err != nil {
+		return "", err
+	}
+	return id, writeFileAtomically(filename, content, 0600)
+}
+
+func newKeyLine() (string, string, error) {
+	id := make([]byte, KEY_ID_LEN)
+	secret := make([]byte, KEY_LEN)
+	_, err := rand.Read(id)
+	if err == nil {
+		_, err = rand.Read(secret)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(id), fmt.Sprintf("%s %s\n", hex.EncodeToString(id), base64.StdEncoding.EncodeToString(secret)), nil
+}
+
+func (ring *KeyRing) current() *encryptionKey {
+	return ring.keys[0]
+}
+
+func (ring *KeyRing) find(id string) *encryptionKey {
+	for _, key := range ring.keys {
+		if key.id == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Encrypts a value with the current key, authenticating the additional data along with it
+func (ring *KeyRing) encrypt(value string, data []byte) (string, error) {
+	key := ring.current()
+	nonce := make([]byte, key.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), data)
+	return ENCRYPTED_VALUE_PREFIX + key.id + "$" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypts a value encrypted with any key of the ring, with the same additional data
+func (ring *KeyRing) decrypt(value string, data []byte) (string, error) {
+	if !strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX) {
+		return "", errors.New("value not encrypted")
+	}
+	keyId, encoded, found := strings.Cut(strings.TrimPrefix(value, ENCRYPTED_VALUE_PREFIX), "$")
+	if !found {
+		return "", errors.New("invalid encrypted value")
+	}
+	key := ring.find(keyId)
+	if key == nil {
+		return "", fmt.Errorf("unknown key %q", keyId)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext, data)
+	if err != nil {
+		return "", errors.New("authentication failed")
+	}
+	return string(plaintext), nil
+}
+
+// Returns if a value was encrypted with the current key
+func (ring *KeyRing) encryptedWithCurrentKey(value string) bool {
+	return strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX+ring.current().id+"$")
+}
+
+// The data authenticated along with a value: its field, and the page and revision it belongs to, so that
+// values cannot be swapped between fields or pages, nor moved to other revisions of their page
+func additionalData(field string, id PageId, number int) []byte {
+	return []byte(field + "\x00" + string(id) + "\x00" + strconv.Itoa(number))
+}
+
+// Wraps a page store so that titles and bodies, of pages and revisions, are encrypted with AES-GCM before
+// they are written to it. Tags, authors, timestamps and parents are not encrypted.
+//
+// The values of a page are bound to its version, and those of revisions to their number, which the store
+// keeps equal for the last revision, whose values are those of the page. Pages are thus created and
+// imported with the ids and versions they will have, and updates of pages whose last revision is not
+// numbered as their version, as some imported pages, rewrite them with a version that follows both.
+//
+// Since encrypted titles cannot be searched, titles are found, and kept unique, through an in-memory index
+// of the decrypted titles, built when the store is opened by decrypting them all. Titles changed by other
+// processes sharing the underlying store are not seen until it is opened again, so only one process may
+// use an encrypted store at a time.
+//
+// Values in clear, as written before the store was encrypted, cannot be read, lest they be planted in the
+// underlying store; they are only accepted while RotateKeys encrypts them.
+type encryptedStore struct {
+	store	PageStore
+	keys	*KeyRing
+	migrating	bool  // if values in clear are accepted
+	mutex	sync.Mutex  // serializes the writes that may change titles, from the title check to the index update
+	titles	*titleIndex
+}
+
+func NewEncryptedStore(store PageStore, keys *KeyRing) (PageStore, error) {
+	return newEncryptedStore(store, keys, false)
+}
+
+func newEncryptedStore(store PageStore, keys *KeyRing, migrating bool) (*encryptedStore, error) {
+	encrypted := &encryptedStore{store: store, keys: keys, migrating: migrating, titles: newTitleIndex(store.TitleMatching())}
+
+	summaries, err := encrypted.ListSummaries(ListOptions{})
+	if unreadableErr, ok := err.(UnreadablePagesError); ok {
+		for _, err := range unreadableErr.Errors {
+			log.Println(err)  // do not prevent the rest of the wiki from being used
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		encrypted.titles.put(summary.Id, summary.Title)
+	}
+	return encrypted, nil
+}
+
+func (store *encryptedStore) encrypt(field string, id PageId, number int, value string) (string, error) {
+	return store.keys.encrypt(value, additionalData(field, id, number))
+}
+
+func (store *encryptedStore) decrypt(field string, id PageId, number int, value string) (string, error) {
+	if store.migrating && !strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX) {
+		return value, nil
+	}
+	return store.keys.decrypt(value, additionalData(field, id, number))
+}
+
+// Encrypts a page as it will be written with a version
+func (store *encryptedStore) encryptPage(page *Page, version int) (*Page, error) {
+	encrypted := *page
+	var err error
+	encrypted.Title, err = store.encrypt("title", page.Id, version, page.Title)
+	if err == nil {
+		encrypted.Body, err = store.encrypt("body", page.Id, version, page.Body)
+	}
+	return &encrypted, err
+}
+
+func (store *encryptedStore) decryptPage(page *Page) (*Page, error) {
+	decrypted := *page
+	var err error
+	decrypted.Title, err = store.decrypt("title", page.Id, page.Version, page.Title)
+	if err == nil {
+		decrypted.Body, err = store.decrypt("body", page.Id, page.Version, page.Body)
+	}
+	if err != nil {
+		return nil, DecryptionError{page.Id, err.Error()}
+	}
+	return &decrypted, nil
+}
+
+func (store *encryptedStore) encryptRevisions(id PageId, revisions []*Revision) ([]*Revision, error) {
+	result := make([]*Revision, len(revisions))
+	for k, revision := range revisions {
+		encrypted := *revision
+		var err error
+		encrypted.Title, err = store.encrypt("title", id, revision.Number, revision.Title)
+		if err == nil {
+			encrypted.Body, err = store.encrypt("body", id, revision.Number, revision.Body)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[k] = &encrypted
+	}
+	return result, nil
+}
+
+func (store *encryptedStore) decryptRevision(id PageId, revision *Revision) (*Revision, error) {
+	decrypted := *revision
+	var err error
+	decrypted.Title, err = store.decrypt("title", id, revision.Number, revision.Title)
+	if err == nil {
+		decrypted.Body, err = store.decrypt("body", id, revision.Number, revision.Body)
+	}
+	if err != nil {
+		return nil, DecryptionError{id, fmt.Sprintf("revision %d: %s", revision.Number, err)}
+	}
+	return &decrypted, nil
+}
+
+// Copies back the fields set by the underlying store when writing a page
+func copyWrittenFields(page, written *Page) {
+	page.Id, page.Version, page.Created, page.Modified, page.Tags = written.Id, written.Version, written.Created, written.Modified, written.Tags
+}
+
+// Writes a page with its history through the underlying store, once encrypted
+func (store *encryptedStore) importEncrypted(page *Page, revisions []*Revision) error {
+	encrypted, err := store.encryptPage(page, page.Version)
+	if err != nil {
+		return err
+	}
+	encryptedRevisions, err := store.encryptRevisions(page.Id, revisions)
+	if err != nil {
+		return err
+	}
+	err = store.store.Import(encrypted, encryptedRevisions)
+	if err != nil {
+		return err
+	}
+	copyWrittenFields(page, encrypted)
+	return nil
+}
+
+// Pages are imported with a new id, since the id is encrypted along with them
+func (store *encryptedStore) Create(page *Page) (PageId, error) {
+	id, err := NewPageId()
+	if err != nil {
+		return "", err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if owner, found := store.titles.findOwner("", page.Title); found {
+		return "", DuplicateTitleError{page.Title, owner}
+	}
+	_, err = store.store.Read(id)
+	if _, unexistent := err.(UnexistentPageError); !unexistent {
+		if err == nil {
+			err = fmt.Errorf("new page id %q already taken", id)
+		}
+		return "", err
+	}
+
+	now := time.Now()
+	created := *page
+	created.Id, created.Version, created.Created, created.Modified = id, 1, now, now
+	revision := &Revision{Number: 1, Timestamp: now, Title: page.Title, Body: page.Body, Author: page.Author}
+	err = store.importEncrypted(&created, []*Revision{revision})
+	if err != nil {
+		return "", err
+	}
+	copyWrittenFields(page, &created)
+	store.titles.put(id, page.Title)
+	return id, nil
+}
+
+func (store *encryptedStore) Read(id PageId) (*Page, error) {
+	page, err := store.store.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	return store.decryptPage(page)
+}
+
+func (store *encryptedStore) Update(page *Page) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if owner, found := store.titles.findOwner(page.Id, page.Title); found {
+		return DuplicateTitleError{page.Title, owner}
+	}
+
+	revisions, err := store.store.ListRevisions(page.Id)
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(revisions) > 0 {
+		next = revisions[len(revisions)-1].Number + 1
+	}
+	if next != page.Version+1 {
+		err = store.updateRenumbered(page, revisions, next)
+	} else {
+		err = store.updateInPlace(page)
+	}
+	if err != nil {
+		return err
+	}
+	store.titles.put(page.Id, page.Title)
+	return nil
+}
+
+func (store *encryptedStore) updateInPlace(page *Page) error {
+	encrypted, err := store.encryptPage(page, page.Version+1)
+	if err != nil {
+		return err
+	}
+	err = store.store.Update(encrypted)
+	if conflictErr, ok := err.(ConflictError); ok {
+		current, err := store.decryptPage(conflictErr.Current)
+		if err != nil {
+			return err
+		}
+		return ConflictError{Current: current, Rejected: page}
+	}
+	if err != nil {
+		return err
+	}
+	copyWrittenFields(page, encrypted)
+	return nil
+}
+
+// Updates a page whose last revision is not numbered as its version by importing it, with its history,
+// under a version that follows both. The revisions are kept as they were encrypted.
+func (store *encryptedStore) updateRenumbered(page *Page, revisions []*Revision, next int) error {
+	current, err := store.store.Read(page.Id)
+	if err != nil {
+		return err
+	}
+	if current.Version != page.Version {
+		decrypted, err := store.decryptPage(current)
+		if err != nil {
+			return err
+		}
+		return ConflictError{Current: decrypted, Rejected: page}
+	}
+
+	now := time.Now()
+	updated := *page
+	updated.Version, updated.Created, updated.Modified = max(next, page.Version+1), current.Created, now
+	revision := &Revision{Number: updated.Version, Timestamp: now, Title: page.Title, Body: page.Body, Author: page.Author}
+	encrypted, err := store.encryptRevisions(page.Id, []*Revision{revision})
+	if err != nil {
+		return err
+	}
+	encryptedPage, err := store.encryptPage(&updated, updated.Version)
+	if err != nil {
+		return err
+	}
+	err = store.store.Import(encryptedPage, append(revisions, encrypted...))
+	if err != nil {
+		return err
+	}
+	copyWrittenFields(page, encryptedPage)
+	return nil
+}
+
+func (store *encryptedStore) Import(page *Page, revisions []*Revision) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if owner, found := store.titles.findOwner(page.Id, page.Title); found {
+		return DuplicateTitleError{page.Title, owner}
+	}
+	err := store.importEncrypted(page, revisions)
+	if err != nil {
+		return err
+	}
+	store.titles.put(page.Id, page.Title)
+	return nil
+}
+
+func (store *encryptedStore) Delete(id PageId) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	err := store.store.Delete(id)
+	if err != nil {
+		return err
+	}
+	store.titles.remove(id)
+	return nil
+}
+
+func (store *encryptedStore) ListAll() ([]PageId, error) {
+	return store.store.ListAll()
+}
+
+func (store *encryptedStore) ListRange(options ListOptions) ([]PageId, error) {
+	return store.store.ListRange(options)
+}
+
+// Pages whose title cannot be decrypted are reported as unreadable
+func (store *encryptedStore) ListSummaries(options ListOptions) ([]*PageSummary, error) {
+	summaries, err := store.store.ListSummaries(options)
+	return store.decryptSummaries(summaries, err)
+}
+
+// Titles are decrypted on every call, which costs no reads of the underlying store
+func (store *encryptedStore) ListIndexedSummaries() ([]*PageSummary, error) {
+	summaries, err := store.store.ListIndexedSummaries()
+	return store.decryptSummaries(summaries, err)
+}
+
+// Decrypts the titles of summaries listed by the underlying store, adding those that cannot be
+// decrypted to the unreadable pages
+func (store *encryptedStore) decryptSummaries(summaries []*PageSummary, err error) ([]*PageSummary, error) {
+	unreadableErr, partial := err.(UnreadablePagesError)
+	if err != nil && !partial {
+		return nil, err
+	}
+	if !partial {
+		unreadableErr = UnreadablePagesError{make(map[PageId]error)}
+	}
+
+	result := make([]*PageSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		title, err := store.decrypt("title", summary.Id, summary.Version, summary.Title)
+		if err != nil {
+			unreadableErr.Errors[summary.Id] = DecryptionError{summary.Id, err.Error()}
+			continue
+		}
+		decrypted := *summary
+		decrypted.Title = title
+		result = append(result, &decrypted)
+	}
+	if len(unreadableErr.Errors) > 0 {
+		return result, unreadableErr
+	}
+	return result, nil
+}
+
+func (store *encryptedStore) FindByTitle(title string) (PageId, error) {
+	return store.titles.find(title), nil
+}
+
+func (store *encryptedStore) FindByTag(tag string) ([]PageId, error) {
+	return store.store.FindByTag(tag)
+}
+
+func (store *encryptedStore) FindChildren(id PageId) ([]PageId, error) {
+	return store.store.FindChildren(id)
+}
+
+func (store *encryptedStore) ListTags() (map[string]int, error) {
+	return store.store.ListTags()
+}
+
+func (store *encryptedStore) TitleMatching() TitleNormalization {
+	return store.store.TitleMatching()
+}
+
+func (store *encryptedStore) ListRevisions(id PageId) ([]*Revision, error) {
+	revisions, err := store.store.ListRevisions(id)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Revision, len(revisions))
+	for k, revision := range revisions {
+		result[k], err = store.decryptRevision(id, revision)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (store *encryptedStore) ReadRevision(id PageId, number int) (*Revision, error) {
+	revision, err := store.store.ReadRevision(id, number)
+	if err != nil {
+		return nil, err
+	}
+	return store.decryptRevision(id, revision)
+}
+
+func (store *encryptedStore) ListTrash() ([]*TrashedPage, error) {
+	trash, err := store.store.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*TrashedPage, len(trash))
+	for k, trashed := range trash {
+		title, err := store.decrypt("title", trashed.Id, trashed.Version, trashed.Title)
+		if err != nil {
+			return nil, DecryptionError{trashed.Id, err.Error()}
+		}
+		result[k] = &TrashedPage{Id: trashed.Id, Title: title, Version: trashed.Version, Deleted: trashed.Deleted}
+	}
+	return result, nil
+}
+
+// The title of a page in the trash is checked before restoring it, since the underlying store
+// cannot compare encrypted titles
+func (store *encryptedStore) Restore(id PageId) error {
+	trash, err := store.ListTrash()
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, trashed := range trash {
+		if trashed.Id != id {
+			continue
+		}
+		if owner, found := store.titles.findOwner(id, trashed.Title); found {
+			return DuplicateTitleError{trashed.Title, owner}
+		}
+		err = store.store.Restore(id)
+		if err != nil {
+			return err
+		}
+		store.titles.put(id, trashed.Title)
+		return nil
+	}
+	return store.store.Restore(id)  // not in the trash, or deleted meanwhile
+}
+
+func (store *encryptedStore) Purge(id PageId) error {
+	return store.store.Purge(id)
+}
+
+func (store *encryptedStore) PurgeTrash(before time.Time) (int, error) {
+	return store.store.PurgeTrash(before)
+}
+
+// Re-encrypts the pages of a store, with their history, with the current key of the ring, including
+// pages stored in clear. Returns the number of pages rewritten. Pages edited meanwhile may lose the edit,
+// so this is best run while the wiki is not being edited. Pages in the trash are not re-encrypted, so
+// previous keys are still needed until they are restored and re-encrypted, or purged.
+func RotateKeys(store PageStore, keys *KeyRing) (int, error) {
+	encrypted, err := newEncryptedStore(store, keys, true)
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	iterator := IteratePages(store)
+	for iterator.Next() {
+		id := iterator.Id()
+		current, err := encrypted.encryptedWithCurrentKey(id)
+		if _, unexistent := err.(UnexistentPageError); unexistent {  // deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return rewritten, err
+		}
+		if current {
+			continue
+		}
+
+		page, err := encrypted.Read(id)
+		if err != nil {
+			return rewritten, err
+		}
+		revisions, err := encrypted.ListRevisions(id)
+		if err != nil {
+			return rewritten, err
+		}
+		err = encrypted.Import(page, revisions)
+		if err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+	return rewritten, iterator.Err()
+}
+
+// Returns if a page and its history are encrypted with the current key
+func (store *encryptedStore) encryptedWithCurrentKey(id PageId) (bool, error) {
+	page, err := store.store.Read(id)
+	if err != nil {
+		return false, err
+	}
+	revisions, err := store.store.ListRevisions(id)
+	if err != nil {
+		return false, err
+	}
+
+	values := []string{page.Title, page.Body}
+	for _, revision := range revisions {
+		values = append(values, revision.Title, revision.Body)
+	}
+	for _, value := range values {
+		if !store.keys.encryptedWithCurrentKey(value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Returned when a line of a key file is not a valid key
+type InvalidKeyError struct {
+    Line int  // 0 if the file has no keys
+    Reason string
+}
+
+func (err InvalidKeyError) Error() string {
+    if err.Line == 0 {
+        return "invalid key file: " + err.Reason
+    }
+    return fmt.Sprintf("invalid key at line %d: %s", err.Line, err.Reason)
+}
+
+// Returned when a page cannot be decrypted with the keys of the ring
+type DecryptionError struct {
+    Id PageId
+    Reason string
+}
+
+func (err DecryptionError) Error() string {
+    return fmt.Sprintf("cannot decrypt page %q: %s", err.Id, err.Reason)
+}
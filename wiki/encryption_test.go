@@ -0,0 +1,219 @@
+package wiki
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustParseKeyRing(t *testing.T, lines ...string) *KeyRing {
+	keys, err := ParseKeyRing(strings.NewReader(strings.Join(lines, "")))
+	if err != nil {
+		t.Fatalf("ParseKeyRing: %s", err)
+	}
+	return keys
+}
+
+func mustNewKeyLine(t *testing.T) string {
+	_, line, err := newKeyLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return line
+}
+
+func TestEncryptedStore(t *testing.T) {
+	underlying := NewMemoryStore()
+	store, err := NewEncryptedStore(underlying, mustParseKeyRing(t, mustNewKeyLine(t)))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %s", err)
+	}
+	id, err := store.Create(&Page{Title: "Runbook", Body: "The password is in the vault."})
+	if err != nil {
+		t.Fatalf("PageStore.Create: %s", err)
+	}
+
+	stored, _ := underlying.Read(id)
+	revision, _ := underlying.ReadRevision(id, 1)
+	for _, value := range []string{stored.Title, stored.Body, revision.Title, revision.Body} {
+		if !strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX) || strings.Contains(value, "vault") {
+			t.Errorf("NewEncryptedStore: expected an encrypted value, found %q", value)
+		}
+	}
+
+	page, err := store.Read(id)
+	if err != nil || page.Title != "Runbook" || page.Body != "The password is in the vault." {
+		t.Errorf("PageStore.Read: expected the decrypted page, found %+v (%v)", page, err)
+	}
+	found, err := store.FindByTitle("runbook")
+	if err != nil || found != id {
+		t.Errorf("PageStore.FindByTitle: expected %q, found %q (%v)", id, found, err)
+	}
+
+	reopened, err := NewEncryptedStore(underlying, mustParseKeyRing(t, mustNewKeyLine(t)))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %s", err)
+	}
+	_, err = reopened.Read(id)
+	if _, ok := err.(DecryptionError); !ok {
+		t.Errorf("PageStore.Read: expected DecryptionError with another key, found %v", err)
+	}
+	_, err = reopened.ListSummaries(ListOptions{})
+	if unreadableErr, ok := err.(UnreadablePagesError); !ok || unreadableErr.Errors[id] == nil {
+		t.Errorf("PageStore.ListSummaries: expected UnreadablePagesError with another key, found %v", err)
+	}
+}
+
+func TestEncryptedStoreBindsValuesToPages(t *testing.T) {
+	underlying := NewMemoryStore()
+	store, err := NewEncryptedStore(underlying, mustParseKeyRing(t, mustNewKeyLine(t)))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %s", err)
+	}
+	first, _ := store.Create(&Page{Title: "First", Body: "First body."})
+	second, _ := store.Create(&Page{Title: "Second", Body: "Second body."})
+	page, _ := store.Read(first)
+	page.Body = "Changed body."
+	if err := store.Update(page); err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+
+	stored, _ := underlying.Read(first)
+	other, _ := underlying.Read(second)
+	previous, _ := underlying.ReadRevision(first, 1)
+	revisions, _ := underlying.ListRevisions(first)
+	for name, body := range map[string]string{"another page": other.Body, "a previous revision": previous.Body} {
+		tampered := *stored
+		tampered.Body = body
+		if err := underlying.Import(&tampered, revisions); err != nil {
+			t.Fatalf("PageStore.Import: %s", err)
+		}
+		_, err = store.Read(first)
+		if _, ok := err.(DecryptionError); !ok {
+			t.Errorf("PageStore.Read: expected DecryptionError for the body of %s, found %v", name, err)
+		}
+	}
+}
+
+// Imported pages may have a version other than the number of their last revision
+func TestEncryptedStoreUpdateOfImportedPage(t *testing.T) {
+	underlying := NewMemoryStore()
+	store, err := NewEncryptedStore(underlying, mustParseKeyRing(t, mustNewKeyLine(t)))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %s", err)
+	}
+	page := &Page{Id: "000000000001", Title: "Imported", Body: "Imported body.", Version: 5}
+	err = store.Import(page, []*Revision{{Number: 1, Title: page.Title, Body: page.Body}})
+	if err != nil {
+		t.Fatalf("PageStore.Import: %s", err)
+	}
+
+	page.Body = "Updated body."
+	err = store.Update(&Page{Id: page.Id, Title: page.Title, Body: page.Body, Version: 4})
+	if _, ok := err.(ConflictError); !ok {
+		t.Errorf("PageStore.Update: expected ConflictError for a previous version, found %v", err)
+	}
+	err = store.Update(page)
+	if err != nil || page.Version != 6 {
+		t.Fatalf("PageStore.Update: expected version 6, found %d (%v)", page.Version, err)
+	}
+	read, err := store.Read(page.Id)
+	if err != nil || read.Body != page.Body || read.Version != 6 {
+		t.Errorf("PageStore.Read: expected the updated page, found %+v (%v)", read, err)
+	}
+	revisions, err := store.ListRevisions(page.Id)
+	if err != nil || len(revisions) != 2 || revisions[1].Number != 6 || revisions[1].Body != page.Body {
+		t.Fatalf("PageStore.ListRevisions: expected revisions 1 and 6, found %+v (%v)", revisions, err)
+	}
+
+	page.Body = "Updated again."
+	err = store.Update(page)
+	if err != nil || page.Version != 7 {
+		t.Errorf("PageStore.Update: expected version 7, found %d (%v)", page.Version, err)
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	underlying := NewMemoryStore()
+	id, err := underlying.Create(&Page{Title: "Clear Page", Body: "Written before encryption."})
+	if err != nil {
+		t.Fatalf("PageStore.Create: %s", err)
+	}
+
+	first := mustNewKeyLine(t)
+	store, err := NewEncryptedStore(underlying, mustParseKeyRing(t, first))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %s", err)
+	}
+	_, err = store.Read(id)
+	if _, ok := err.(DecryptionError); !ok {
+		t.Errorf("PageStore.Read: expected DecryptionError for a page in clear, found %v", err)
+	}
+	if found, _ := store.FindByTitle("Clear Page"); found != "" {
+		t.Errorf("PageStore.FindByTitle: expected no page for a title in clear, found %q", found)
+	}
+
+	rewritten, err := RotateKeys(underlying, mustParseKeyRing(t, first))
+	if err != nil || rewritten != 1 {
+		t.Fatalf("RotateKeys: expected 1 page rewritten, found %d (%v)", rewritten, err)
+	}
+	stored, _ := underlying.Read(id)
+	if !strings.HasPrefix(stored.Body, ENCRYPTED_VALUE_PREFIX) {
+		t.Fatalf("RotateKeys: expected the page to be encrypted, found %q", stored.Body)
+	}
+
+	second := mustNewKeyLine(t)
+	keys := mustParseKeyRing(t, second, first)
+	rewritten, err = RotateKeys(underlying, keys)
+	if err != nil || rewritten != 1 {
+		t.Fatalf("RotateKeys: expected 1 page rewritten, found %d (%v)", rewritten, err)
+	}
+	rewritten, err = RotateKeys(underlying, keys)
+	if err != nil || rewritten != 0 {
+		t.Fatalf("RotateKeys: expected no pages rewritten with the same key, found %d (%v)", rewritten, err)
+	}
+
+	store, err = NewEncryptedStore(underlying, mustParseKeyRing(t, second))  // the first key is no longer needed
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %s", err)
+	}
+	page, err := store.Read(id)
+	if err != nil || page.Body != "Written before encryption." {
+		t.Errorf("PageStore.Read: expected the page to be readable with the new key, found %+v (%v)", page, err)
+	}
+	found, _ := store.FindByTitle("Clear Page")
+	if found != id {
+		t.Errorf("PageStore.FindByTitle: expected %q, found %q", id, found)
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "wiki.keys")
+
+	first, err := AddKey(filename)
+	if err != nil {
+		t.Fatalf("AddKey: %s", err)
+	}
+	second, err := AddKey(filename)
+	if err != nil {
+		t.Fatalf("AddKey: %s", err)
+	}
+	keys, err := LoadKeyRing(filename)
+	if err != nil || len(keys.keys) != 2 || keys.current().id != second || keys.find(first) == nil {
+		t.Fatalf("LoadKeyRing: expected keys %s and %s, found %+v (%v)", second, first, keys, err)
+	}
+
+	for _, content := range []string{"", "# no keys\n", "k1\n", "k1 c2hvcnQ=\n", first + " " + strings.Repeat("A", 44) + "\n" + first + " " + strings.Repeat("A", 44) + "\n"} {
+		_, err = ParseKeyRing(strings.NewReader(content))
+		if _, ok := err.(InvalidKeyError); !ok {
+			t.Errorf("ParseKeyRing(%q): expected InvalidKeyError, found %v", content, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package wiki
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	TEMP_FILE_INFIX = ".tmp-"
+)
+
+// Writes a file through a temporary file that is synced and then renamed over the target,
+// so that a crash or a full disk never leaves a truncated file behind
+func writeFileAtomically(filename string, content []byte, perm os.FileMode) error {
+	_, err := copyToFileAtomically(filename, bytes.NewReader(content), perm)
+	return err
+}
+
+// Like writeFileAtomically, for content read from a stream; returns the number of bytes written
+func copyToFileAtomically(filename string, content io.Reader, perm os.FileMode) (size int64, err error) {
+	dir := filepath.Dir(filename)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(filename)+TEMP_FILE_INFIX)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	size, err = io.Copy(tmp, content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Rename(tmp.Name(), filename)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, syncDir(dir)  // make the rename itself durable
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// Removes the temporary files left behind by writes interrupted by a crash. Files modified
+// less than minAge ago are kept, since they may belong to a write still in progress in another process.
+func removeTempFiles(dir string, minAge time.Duration) error {
+	limit := time.Now().Add(-minAge)
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && isTempFile(info.Name()) && info.ModTime().Before(limit) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func isTempFile(name string) bool {
+	return strings.Contains(name, TEMP_FILE_INFIX)
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package wiki
+
+import (
+	"os"
+)
+
+// Advisory file locks are not supported on this platform: pages are only locked within the process,
+// so the storage directory must not be shared by several processes
+
+func flock(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func funlock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,429 @@
+package wiki
+
+import (
+	"sort"
+	"sync"
+)
+
+// In-memory index of page titles, kept up to date by the store on every write.
+// Titles are indexed and looked up by their normalized form.
+type titleIndex struct {
+	mutex		sync.RWMutex
+	titles		map[PageId]string  // normalized
+	pages		map[string][]PageId  // sorted by id, since pages written before titles were unique may share them
+	normalization	TitleNormalization
+}
+
+func newTitleIndex(normalization TitleNormalization) *titleIndex {
+	return &titleIndex{titles: make(map[PageId]string), pages: make(map[string][]PageId), normalization: normalization}
+}
+
+// Replaces the contents of the index with those of another one, built from scratch
+func (index *titleIndex) replace(other *titleIndex) {
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.titles = other.titles
+	index.pages = other.pages
+}
+
+func (index *titleIndex) put(id PageId, title string) {
+	title = index.normalization.Normalize(title)
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.removeLocked(id)
+	index.titles[id] = title
+
+	ids := append(index.pages[title], id)
+	sort.Sort(pageIdList(ids))
+	index.pages[title] = ids
+}
+
+func (index *titleIndex) remove(id PageId) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.removeLocked(id)
+}
+
+func (index *titleIndex) removeLocked(id PageId) {
+	title, found := index.titles[id]
+	if !found {
+		return
+	}
+	delete(index.titles, id)
+
+	ids := index.pages[title]
+	for k := range ids {
+		if ids[k] == id {
+			ids = append(ids[:k:k], ids[k+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(index.pages, title)
+	} else {
+		index.pages[title] = ids
+	}
+}
+
+// Indexes the page title unless another page already has it, in which case that page's id is returned
+func (index *titleIndex) putUnique(id PageId, title string) (owner PageId, ok bool) {
+	title = index.normalization.Normalize(title)
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	for _, other := range index.pages[title] {
+		if other != id {
+			return other, false
+		}
+	}
+
+	index.removeLocked(id)
+	index.titles[id] = title
+	index.pages[title] = append(index.pages[title], id)
+	sort.Sort(pageIdList(index.pages[title]))
+	return id, true
+}
+
+// Returns the id of the page with the given title, or "" if there is none
+func (index *titleIndex) find(title string) PageId {
+	title = index.normalization.Normalize(title)
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	ids := index.pages[title]
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
+// Returns the page that has the given title, unless it is the given page or that page has the title too,
+// as pages sharing their title since before titles were unique can still be edited
+func (index *titleIndex) findOwner(id PageId, title string) (PageId, bool) {
+	title = index.normalization.Normalize(title)
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	if current, found := index.titles[id]; found && current == title {
+		return "", false
+	}
+	ids := index.pages[title]
+	if len(ids) == 0 || ids[0] == id {
+		return "", false
+	}
+	return ids[0], true
+}
+
+func (index *titleIndex) sameTitle(title1, title2 string) bool {
+	return index.normalization.Normalize(title1) == index.normalization.Normalize(title2)
+}
+
+// In-memory index of page tags, kept up to date by the store on every write.
+// Tags are expected to be normalized.
+type tagIndex struct {
+	mutex	sync.RWMutex
+	tags	map[PageId][]string
+	pages	map[string][]PageId  // sorted by id
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{tags: make(map[PageId][]string), pages: make(map[string][]PageId)}
+}
+
+func (index *tagIndex) put(id PageId, tags []string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.removeLocked(id)
+	if len(tags) == 0 {
+		return
+	}
+	index.tags[id] = append([]string(nil), tags...)
+	for _, tag := range tags {
+		ids := append(index.pages[tag], id)
+		sort.Sort(pageIdList(ids))
+		index.pages[tag] = ids
+	}
+}
+
+func (index *tagIndex) remove(id PageId) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.removeLocked(id)
+}
+
+func (index *tagIndex) removeLocked(id PageId) {
+	for _, tag := range index.tags[id] {
+		ids := index.pages[tag]
+		for k := range ids {
+			if ids[k] == id {
+				ids = append(ids[:k:k], ids[k+1:]...)
+				break
+			}
+		}
+		if len(ids) == 0 {
+			delete(index.pages, tag)
+		} else {
+			index.pages[tag] = ids
+		}
+	}
+	delete(index.tags, id)
+}
+
+// Returns the ids of the pages with the given tag, sorted
+func (index *tagIndex) find(tag string) []PageId {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return append([]PageId(nil), index.pages[tag]...)
+}
+
+// Returns the number of pages with each tag
+func (index *tagIndex) counts() map[string]int {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	result := make(map[string]int, len(index.pages))
+	for tag, ids := range index.pages {
+		result[tag] = len(ids)
+	}
+	return result
+}
+
+// Replaces the contents of the index with those of another one, built from scratch
+func (index *tagIndex) replace(other *tagIndex) {
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.tags = other.tags
+	index.pages = other.pages
+}
+
+// In-memory index of the page tree, kept up to date by the store on every write.
+// Every page is indexed, so that it also tells which pages exist.
+type treeIndex struct {
+	mutex		sync.RWMutex
+	parents		map[PageId]PageId  // "" for top-level pages
+	children	map[PageId][]PageId  // sorted by id
+}
+
+func newTreeIndex() *treeIndex {
+	return &treeIndex{parents: make(map[PageId]PageId), children: make(map[PageId][]PageId)}
+}
+
+func (index *treeIndex) put(id PageId, parent PageId) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.removeLocked(id)
+	index.parents[id] = parent
+	if parent != "" {
+		ids := append(index.children[parent], id)
+		sort.Sort(pageIdList(ids))
+		index.children[parent] = ids
+	}
+}
+
+func (index *treeIndex) remove(id PageId) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.removeLocked(id)
+}
+
+func (index *treeIndex) removeLocked(id PageId) {
+	parent, found := index.parents[id]
+	if !found {
+		return
+	}
+	delete(index.parents, id)
+
+	ids := index.children[parent]
+	for k := range ids {
+		if ids[k] == id {
+			ids = append(ids[:k:k], ids[k+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(index.children, parent)
+	} else {
+		index.children[parent] = ids
+	}
+}
+
+func (index *treeIndex) exists(id PageId) bool {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	_, found := index.parents[id]
+	return found
+}
+
+// Returns the ids of the children of a page, sorted
+func (index *treeIndex) find(id PageId) []PageId {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return append([]PageId(nil), index.children[id]...)
+}
+
+// Checks that a page can be moved under the given parent: it must exist, and not be the page itself or one of its descendants
+func (index *treeIndex) checkParent(id PageId, parent PageId) error {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	if parent == "" {
+		return nil
+	}
+	if _, found := index.parents[parent]; !found {
+		return InvalidParentError{id, parent, "unexistent page"}
+	}
+	visited := make(map[PageId]bool)
+	for ancestor := parent; ancestor != "" && !visited[ancestor]; ancestor = index.parents[ancestor] {
+		if ancestor == id {
+			return InvalidParentError{id, parent, "the page cannot be moved under itself or one of its descendants"}
+		}
+		visited[ancestor] = true
+	}
+	return nil
+}
+
+// Replaces the contents of the index with those of another one, built from scratch
+func (index *treeIndex) replace(other *treeIndex) {
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.parents = other.parents
+	index.children = other.children
+}
+
+// In-memory list of the ids of all the pages, including those that cannot be read, kept up to date by
+// the store on every write, so that ranges of pages are listed without reading the store directory.
+type idIndex struct {
+	mutex	sync.RWMutex
+	ids		[]PageId  // sorted
+}
+
+func newIdIndex() *idIndex {
+	return &idIndex{}
+}
+
+func (index *idIndex) put(id PageId) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	k := sort.Search(len(index.ids), func(k int) bool { return index.ids[k] >= id })
+	if k < len(index.ids) && index.ids[k] == id {
+		return
+	}
+	index.ids = append(index.ids, "")
+	copy(index.ids[k+1:], index.ids[k:])
+	index.ids[k] = id
+}
+
+func (index *idIndex) remove(id PageId) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	k := sort.Search(len(index.ids), func(k int) bool { return index.ids[k] >= id })
+	if k < len(index.ids) && index.ids[k] == id {
+		index.ids = append(index.ids[:k], index.ids[k+1:]...)
+	}
+}
+
+// Returns a copy of the ids in a range
+func (index *idIndex) selectRange(options ListOptions) []PageId {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return append([]PageId(nil), selectRange(index.ids, options)...)
+}
+
+// Replaces the contents of the index with those of another one, built from scratch
+func (index *idIndex) replace(other *idIndex) {
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.ids = other.ids
+}
+
+// In-memory summaries of the pages, kept up to date by the store on every write, so that pages can be
+// sorted by their metadata without reading them. The errors of the pages that could not be read when the
+// index was built are kept instead, until the pages are written again.
+type summaryIndex struct {
+	mutex		sync.RWMutex
+	summaries	map[PageId]*PageSummary
+	unreadable	map[PageId]error
+}
+
+func newSummaryIndex() *summaryIndex {
+	return &summaryIndex{summaries: make(map[PageId]*PageSummary), unreadable: make(map[PageId]error)}
+}
+
+func (index *summaryIndex) put(page *Page) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	delete(index.unreadable, page.Id)
+	index.summaries[page.Id] = summarizePage(page)
+}
+
+func (index *summaryIndex) putUnreadable(id PageId, err error) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	delete(index.summaries, id)
+	index.unreadable[id] = err
+}
+
+func (index *summaryIndex) remove(id PageId) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	delete(index.summaries, id)
+	delete(index.unreadable, id)
+}
+
+// Returns copies of the summaries, sorted by id, and an UnreadablePagesError if some pages could not be read
+func (index *summaryIndex) list() ([]*PageSummary, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	result := make([]*PageSummary, 0, len(index.summaries))
+	for _, summary := range index.summaries {
+		copied := *summary
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	if len(index.unreadable) > 0 {
+		unreadable := make(map[PageId]error, len(index.unreadable))
+		for id, err := range index.unreadable {
+			unreadable[id] = err
+		}
+		return result, UnreadablePagesError{unreadable}
+	}
+	return result, nil
+}
+
+// Replaces the contents of the index with those of another one, built from scratch
+func (index *summaryIndex) replace(other *summaryIndex) {
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.summaries = other.summaries
+	index.unreadable = other.unreadable
+}
+
+type pageIdList []PageId
+
+func (list pageIdList) Len() int           { return len(list) }
+func (list pageIdList) Less(i, j int) bool { return list[i] < list[j] }
+func (list pageIdList) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
@@ -0,0 +1,93 @@
+package wiki
+
+import (
+	"sort"
+)
+
+const DEFAULT_ITERATOR_BATCH_SIZE = 100
+
+// Selects a range of the pages of a store, in id order: those after After or, if Before is given, those
+// before Before. At most Limit pages are listed, the closest to the cursor; 0 means no limit.
+type ListOptions struct {
+	After	PageId
+	Before	PageId
+	Limit	int
+}
+
+// Applies the options to a list of ids sorted by id, for stores that cannot do it while listing
+func selectRange(ids []PageId, options ListOptions) []PageId {
+	if options.Before != "" {
+		end := sort.Search(len(ids), func(k int) bool { return ids[k] >= options.Before })
+		ids = ids[:end]
+		if options.Limit > 0 && len(ids) > options.Limit {
+			ids = ids[len(ids)-options.Limit:]
+		}
+	} else {
+		start := sort.Search(len(ids), func(k int) bool { return ids[k] > options.After })
+		ids = ids[start:]
+		if options.Limit > 0 && len(ids) > options.Limit {
+			ids = ids[:options.Limit]
+		}
+	}
+	return ids
+}
+
+// Iterates over the ids of all the pages of a store, in id order, listing them in batches
+// so that they are never all in memory. Pages created or deleted meanwhile may be missed.
+//
+//	iterator := IteratePages(store)
+//	for iterator.Next() {
+//		id := iterator.Id()
+//		...
+//	}
+//	if iterator.Err() != nil {
+//		...
+//	}
+type PageIterator struct {
+	store		PageStore
+	batchSize	int
+	batch		[]PageId
+	position	int
+	done		bool
+	err			error
+}
+
+func IteratePages(store PageStore) *PageIterator {
+	return IteratePagesInBatches(store, DEFAULT_ITERATOR_BATCH_SIZE)
+}
+
+func IteratePagesInBatches(store PageStore, batchSize int) *PageIterator {
+	return &PageIterator{store: store, batchSize: batchSize, position: -1}
+}
+
+// Advances to the next page, returning false when there are no more pages or listing them failed
+func (iterator *PageIterator) Next() bool {
+	if iterator.err != nil {
+		return false
+	}
+	iterator.position++
+	if iterator.position < len(iterator.batch) {
+		return true
+	}
+	if iterator.done {
+		return false
+	}
+
+	var after PageId
+	if len(iterator.batch) > 0 {
+		after = iterator.batch[len(iterator.batch)-1]
+	}
+	iterator.batch, iterator.err = iterator.store.ListRange(ListOptions{After: after, Limit: iterator.batchSize})
+	iterator.position = 0
+	iterator.done = len(iterator.batch) < iterator.batchSize
+	return iterator.err == nil && len(iterator.batch) > 0
+}
+
+func (iterator *PageIterator) Id() PageId {
+	return iterator.batch[iterator.position]
+}
+
+// Returns the error that ended the iteration, if any
+func (iterator *PageIterator) Err() error {
+	return iterator.err
+}
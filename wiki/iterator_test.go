@@ -0,0 +1,36 @@
+package wiki
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPageIterator(t *testing.T) {
+	store := NewMemoryStore()
+	for n := 0; n < 7; n++ {
+		_, err := store.Create(&Page{Title: fmt.Sprintf("Page %d", n)})
+		if err != nil {
+			t.Fatalf("PageStore.Create: %s", err)
+		}
+	}
+	expected, _ := store.ListAll()
+
+	for _, batchSize := range []int{1, 3, 7, 10} {
+		var found []PageId
+		iterator := IteratePagesInBatches(store, batchSize)
+		for iterator.Next() {
+			found = append(found, iterator.Id())
+		}
+		if iterator.Err() != nil {
+			t.Fatalf("PageIterator with batches of %d: %s", batchSize, iterator.Err())
+		}
+		if fmt.Sprint(found) != fmt.Sprint(expected) {
+			t.Errorf("PageIterator with batches of %d: expected %v, found %v", batchSize, expected, found)
+		}
+	}
+
+	iterator := IteratePages(NewMemoryStore())
+	if iterator.Next() {
+		t.Errorf("PageIterator of an empty store: unexpected page %q", iterator.Id())
+	}
+}
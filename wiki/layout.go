@@ -0,0 +1,304 @@
+package wiki
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Records the layout of a store directory, for the stores created since layouts can be chosen
+const LAYOUT_FILE = "layout"
+
+// Where a disk store puts the files of its pages. The flat layout gets slow with tens of thousands of
+// pages, since every lookup goes through one huge directory.
+type DiskLayout int
+
+const (
+	FLAT_LAYOUT DiskLayout = iota  // all the files in the store directory, as in abcdef123456.wiki
+	SHARDED_LAYOUT  // in two levels of directories named after the start of the page id, as in ab/cd/abcdef123456.wiki
+)
+
+var diskLayoutNames = []string{"flat", "sharded"}
+
+// Parses the name of a disk layout: flat or sharded
+func ParseDiskLayout(name string) (DiskLayout, error) {
+	for layout, layoutName := range diskLayoutNames {
+		if name == layoutName {
+			return DiskLayout(layout), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown disk layout %q", name)
+}
+
+func (layout DiskLayout) String() string {
+	return diskLayoutNames[layout]
+}
+
+// Names of shard directories; ids shorter than the two levels are padded with underscores
+var shardDirPattern = regexp.MustCompile(`^[a-zA-Z0-9_]{2}$`)
+
+// Directory of the files of a page, relative to the store directory: "" or ending in a slash
+func (layout DiskLayout) pageDir(id PageId) string {
+	if layout == FLAT_LAYOUT {
+		return ""
+	}
+	padded := string(id) + "____"
+	return padded[:2] + "/" + padded[2:4] + "/"
+}
+
+func isShardDir(file os.FileInfo) bool {
+	return file.IsDir() && shardDirPattern.MatchString(file.Name())
+}
+
+// Returns the id of a page file or history directory, and its suffix, or "" for other files
+func pageFileId(file os.FileInfo) (PageId, string) {
+	var suffix string
+	switch {
+	case file.Mode().IsRegular() && strings.HasSuffix(file.Name(), FILE_SUFFIX):
+		suffix = FILE_SUFFIX
+	case file.IsDir() && strings.HasSuffix(file.Name(), HISTORY_SUFFIX):
+		suffix = HISTORY_SUFFIX
+	default:
+		return "", ""
+	}
+	id := PageId(strings.TrimSuffix(file.Name(), suffix))
+	if !ValidPageId(id) {
+		return "", ""
+	}
+	return id, suffix
+}
+
+// Calls visit with the files of each directory where the layout puts pages, named relative to the store
+// directory, and stray, if not nil, with the other files of the store directory and the first level of
+// shard directories
+func readPageDirs(path string, layout DiskLayout, visit func(dir string, files []os.FileInfo) error,
+		stray func(name string, file os.FileInfo)) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	if layout == FLAT_LAYOUT {
+		return visit("", files)
+	}
+
+	for _, shard := range files {
+		if !isShardDir(shard) {
+			if stray != nil {
+				stray(shard.Name(), shard)
+			}
+			continue
+		}
+		subshards, err := ioutil.ReadDir(path + "/" + shard.Name())
+		if err != nil {
+			return err
+		}
+		for _, subshard := range subshards {
+			dir := shard.Name() + "/" + subshard.Name()
+			if !isShardDir(subshard) {
+				if stray != nil {
+					stray(dir, subshard)
+				}
+				continue
+			}
+			files, err := ioutil.ReadDir(path + "/" + dir)
+			if err != nil {
+				return err
+			}
+			err = visit(dir+"/", files)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A page file or history directory found in a store directory
+type pageFile struct {
+	id			PageId
+	suffix		string  // FILE_SUFFIX or HISTORY_SUFFIX
+	filename	string  // relative to the store directory
+}
+
+// Lists the page files and history directories of a store directory in the places of the layout
+func listPageFiles(path string, layout DiskLayout) ([]*pageFile, error) {
+	var result []*pageFile
+	err := readPageDirs(path, layout, func(dir string, files []os.FileInfo) error {
+		for _, file := range files {
+			id, suffix := pageFileId(file)
+			if suffix != "" && layout.pageDir(id) == dir {
+				result = append(result, &pageFile{id, suffix, dir + file.Name()})
+			}
+		}
+		return nil
+	}, nil)
+	return result, err
+}
+
+// Reads the layout recorded in a store directory
+func readLayoutFile(path string) (layout DiskLayout, found bool, err error) {
+	content, err := ioutil.ReadFile(path + "/" + LAYOUT_FILE)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	layout, err = ParseDiskLayout(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, false, CorruptedFileError{path + "/" + LAYOUT_FILE, err}
+	}
+	return layout, true, nil
+}
+
+func writeLayoutFile(path string, layout DiskLayout) error {
+	return writeFileAtomically(path+"/"+LAYOUT_FILE, []byte(layout.String()+"\n"), 0600)
+}
+
+// Finds out the layout of a store directory: the recorded one or, in directories written before layouts
+// were recorded, the one of the pages found. Directories without pages get the given layout.
+func detectLayout(path string, layout DiskLayout) (DiskLayout, error) {
+	detected, unrecorded, err := findLayout(path, layout)
+	if err != nil || !unrecorded {
+		return detected, err
+	}
+	return layout, writeLayoutFile(path, layout)
+}
+
+// Like detectLayout, without recording the given layout in directories without pages, which are
+// returned as unrecorded
+func findLayout(path string, layout DiskLayout) (detected DiskLayout, unrecorded bool, err error) {
+	recorded, found, err := readLayoutFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+	flat, sharded, err := findPageFiles(path, !found || recorded == FLAT_LAYOUT)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch {
+	case flat && sharded, found && recorded == FLAT_LAYOUT && sharded, found && recorded == SHARDED_LAYOUT && flat:
+		return 0, false, MixedLayoutError{path}
+	case found:
+		return recorded, false, nil
+	case flat:
+		return FLAT_LAYOUT, false, nil
+	case sharded:
+		return SHARDED_LAYOUT, false, nil
+	}
+	return layout, true, nil
+}
+
+// Tells whether there are pages in the places of each layout. Shard directories are only looked into if
+// asked, since it takes reading them all.
+func findPageFiles(path string, shards bool) (flat bool, sharded bool, err error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return false, false, err
+	}
+	for _, file := range files {
+		if _, suffix := pageFileId(file); suffix != "" {
+			flat = true
+		}
+	}
+	if !shards {
+		return flat, false, nil
+	}
+
+	list, err := listPageFiles(path, SHARDED_LAYOUT)
+	return flat, len(list) > 0, err
+}
+
+// Moves the files of the pages of a disk store, with their history, to the places of another layout,
+// returning how many files and history directories were moved. Pages in the trash stay where they are.
+// No other process may use the store meanwhile, since it would not find the pages; an interrupted
+// migration is resumed by running it again, and the store cannot be opened until then.
+func MigrateDiskLayout(path string, layout DiskLayout) (int, error) {
+	lockDir := path + "/" + LOCK_DIR
+	err := os.MkdirAll(lockDir, 0700)
+	if err != nil {
+		return 0, err
+	}
+	file, err := lockFile(lockDir+"/"+INDEX_LOCK_FILE, true)  // keeps out the writers that honor it
+	if err != nil {
+		return 0, err
+	}
+	defer unlockFile(file)
+
+	var moves []*pageFile
+	for _, from := range []DiskLayout{FLAT_LAYOUT, SHARDED_LAYOUT} {
+		if from == layout {
+			continue
+		}
+		list, err := listPageFiles(path, from)
+		if err != nil {
+			return 0, err
+		}
+		moves = append(moves, list...)
+	}
+	sort.SliceStable(moves, func(i, j int) bool {  // histories first, as pages without history are valid
+		return moves[i].suffix == HISTORY_SUFFIX && moves[j].suffix != HISTORY_SUFFIX
+	})
+
+	for k, move := range moves {
+		target := layout.pageDir(move.id) + string(move.id) + move.suffix
+		if _, err := os.Stat(path + "/" + target); !os.IsNotExist(err) {
+			if err == nil {
+				err = fmt.Errorf("cannot move %s: %s already exists", move.filename, target)
+			}
+			return k, err
+		}
+		err = os.MkdirAll(path+"/"+layout.pageDir(move.id), 0700)
+		if err == nil {
+			err = os.Rename(path+"/"+move.filename, path+"/"+target)
+		}
+		if err != nil {
+			return k, err
+		}
+	}
+
+	if layout == FLAT_LAYOUT {
+		err = removeEmptyShardDirs(path)
+		if err != nil {
+			return len(moves), err
+		}
+	}
+	return len(moves), writeLayoutFile(path, layout)
+}
+
+// Removes the shard directories left empty by a migration to the flat layout
+func removeEmptyShardDirs(path string) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, shard := range files {
+		if !isShardDir(shard) {
+			continue
+		}
+		subshards, err := ioutil.ReadDir(path + "/" + shard.Name())
+		if err != nil {
+			return err
+		}
+		for _, subshard := range subshards {
+			os.Remove(path + "/" + shard.Name() + "/" + subshard.Name())  // fails unless empty
+		}
+		os.Remove(path + "/" + shard.Name())
+	}
+	return nil
+}
+
+// Returned when opening a store directory with pages in the places of both layouts, as left by an
+// interrupted migration
+type MixedLayoutError struct {
+    Path string
+}
+
+func (err MixedLayoutError) Error() string {
+    return fmt.Sprintf("%s has pages in both the flat and the sharded layout; complete the migration of its layout", err.Path)
+}
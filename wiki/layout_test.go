@@ -0,0 +1,225 @@
+package wiki
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func assertExists(t *testing.T, path string, exists bool) {
+	_, err := os.Stat(path)
+	if exists && err != nil {
+		t.Errorf("expected %s to exist: %s", path, err)
+	}
+	if !exists && !os.IsNotExist(err) {
+		t.Errorf("expected %s not to exist", path)
+	}
+}
+
+func TestShardedLayout(t *testing.T) {
+	path := t.TempDir()
+	store, err := openDiskStore(path, SHARDED_LAYOUT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.Create(&Page{Title: "Sharded", Body: "First"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Import(&Page{Id: "x", Title: "Short id", Body: "Imported"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, filepath.Join(path, string(id[:2]), string(id[2:4]), string(id)+FILE_SUFFIX), true)
+	assertExists(t, filepath.Join(path, string(id[:2]), string(id[2:4]), string(id)+HISTORY_SUFFIX), true)
+	assertExists(t, filepath.Join(path, "x_", "__", "x"+FILE_SUFFIX), true)
+	assertExists(t, filepath.Join(path, string(id)+FILE_SUFFIX), false)
+
+	err = store.Delete(id)
+	if err == nil {
+		err = store.Restore(id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := newDiskStore(path)  // the layout is detected
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.layout != SHARDED_LAYOUT {
+		t.Errorf("newDiskStore: expected the sharded layout, found %s", reopened.layout)
+	}
+	ids, err := reopened.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PageId{id, "x"}
+	if len(ids) != 2 || ids[0] != expected[0] || ids[1] != expected[1] {
+		t.Errorf("ListAll: expected %v, found %v", expected, ids)
+	}
+	revisions, err := reopened.ListRevisions(id)
+	if err != nil || len(revisions) != 1 {
+		t.Errorf("ListRevisions(%q): expected 1 revision, found %d (%v)", id, len(revisions), err)
+	}
+}
+
+func TestDetectLayoutWithoutLayoutFile(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+	_, err := store.Create(&Page{Title: "Legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(store.path, LAYOUT_FILE))  // as written before layouts were recorded
+
+	reopened, err := openDiskStore(store.path, SHARDED_LAYOUT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.layout != FLAT_LAYOUT {
+		t.Errorf("openDiskStore: expected the flat layout of the pages found, found %s", reopened.layout)
+	}
+}
+
+func TestMigrateDiskLayout(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+	var ids []PageId
+	for _, title := range []string{"One", "Two", "Three"} {
+		id, err := store.Create(&Page{Title: title, Body: "Body of " + title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	page, _ := store.Read(ids[0])
+	page.Body = "Edited"
+	err := store.Update(page)
+	if err == nil {
+		err = store.Delete(ids[2])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := MigrateDiskLayout(store.path, SHARDED_LAYOUT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 4 {
+		t.Errorf("MigrateDiskLayout: expected 4 files moved, found %d", moved)
+	}
+	assertExists(t, filepath.Join(store.path, string(ids[0])+FILE_SUFFIX), false)
+	sharded, err := newDiskStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sharded.layout != SHARDED_LAYOUT {
+		t.Errorf("newDiskStore: expected the sharded layout, found %s", sharded.layout)
+	}
+	revisions, err := sharded.ListRevisions(ids[0])
+	if err != nil || len(revisions) != 2 {
+		t.Errorf("ListRevisions(%q): expected 2 revisions, found %d (%v)", ids[0], len(revisions), err)
+	}
+	found, err := sharded.FindByTitle("Two")
+	if err != nil || found != ids[1] {
+		t.Errorf("FindByTitle(%q): expected %q, found %q (%v)", "Two", ids[1], found, err)
+	}
+	err = sharded.Restore(ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err = MigrateDiskLayout(store.path, FLAT_LAYOUT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 6 {
+		t.Errorf("MigrateDiskLayout: expected 6 files moved back, found %d", moved)
+	}
+	assertExists(t, filepath.Join(store.path, string(ids[0][:2])), false)
+	flat, err := newDiskStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := flat.ListAll()
+	if err != nil || len(all) != 3 {
+		t.Errorf("ListAll: expected 3 pages, found %v (%v)", all, err)
+	}
+}
+
+func TestResumeInterruptedLayoutMigration(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+	var ids []PageId
+	for _, title := range []string{"Moved", "Left behind"} {
+		id, err := store.Create(&Page{Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	shardDir := filepath.Join(store.path, SHARDED_LAYOUT.pageDir(ids[0]))
+	err := os.MkdirAll(shardDir, 0700)
+	if err == nil {
+		err = os.Rename(store.getPageFilename(ids[0]), filepath.Join(shardDir, string(ids[0])+FILE_SUFFIX))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newDiskStore(store.path)
+	if _, ok := err.(MixedLayoutError); !ok {
+		t.Fatalf("newDiskStore: expected MixedLayoutError, found %v", err)
+	}
+	_, err = MigrateDiskLayout(store.path, SHARDED_LAYOUT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharded, err := newDiskStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		_, err = sharded.Read(id)
+		if err != nil {
+			t.Errorf("Read(%q): %s", id, err)
+		}
+	}
+}
+
+func TestCheckShardedLayout(t *testing.T) {
+	path := t.TempDir()
+	store, err := openDiskStore(path, SHARDED_LAYOUT, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.Create(&Page{Title: "Sharded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	misplaced := filepath.Join("zz", "zz", string(id)+FILE_SUFFIX)
+	content, _ := ioutil.ReadFile(store.getPageFilename(id))
+	err = os.MkdirAll(filepath.Join(path, "zz", "zz"), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(path, misplaced), content, 0600)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(path, "zz", "notes.txt"), nil, 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(path, CHECK_QUARANTINE, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pages != 1 {
+		t.Errorf("Check: expected 1 page, found %d", report.Pages)
+	}
+	assertProblems(t, report, []expectedProblem{{misplaced, STRAY_FILE}, {"zz/notes.txt", STRAY_FILE}})
+	assertExists(t, filepath.Join(path, QUARANTINE_DIR, misplaced), true)
+}
@@ -0,0 +1,102 @@
+package wiki
+
+import (
+	"os"
+	"sync"
+)
+
+// Per-page reader/writer locks, serializing writers (and keeping readers away from them) both
+// within this process, through mutexes, and across processes sharing the storage directory,
+// through advisory locks on lock files
+type pageLocks struct {
+	dir		string
+	mutex	sync.Mutex
+	locks	map[PageId]*pageLock
+}
+
+type pageLock struct {
+	sync.RWMutex
+	refs int  // goroutines holding or waiting for the lock
+}
+
+func newPageLocks(dir string) *pageLocks {
+	return &pageLocks{dir: dir, locks: make(map[PageId]*pageLock)}
+}
+
+// Locks a page for writing; the returned function releases the lock
+func (locks *pageLocks) lock(id PageId) (unlock func(), err error) {
+	return locks.acquire(id, true)
+}
+
+func (locks *pageLocks) acquire(id PageId, exclusive bool) (func(), error) {
+	lock := locks.ref(id)
+	if exclusive {
+		lock.Lock()
+	} else {
+		lock.RLock()
+	}
+
+	release := func() {
+		if exclusive {
+			lock.Unlock()
+		} else {
+			lock.RUnlock()
+		}
+		locks.unref(id)
+	}
+
+	file, err := lockFile(locks.dir+"/"+string(id)+LOCK_FILE_SUFFIX, exclusive)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(file)
+		release()
+	}, nil
+}
+
+func (locks *pageLocks) ref(id PageId) *pageLock {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	lock, found := locks.locks[id]
+	if !found {
+		lock = &pageLock{}
+		locks.locks[id] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+func (locks *pageLocks) unref(id PageId) {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	lock := locks.locks[id]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(locks.locks, id)
+	}
+}
+
+// Opens (creating it if needed) and locks a file, shared or exclusively, blocking until the lock is granted
+func lockFile(filename string, exclusive bool) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = flock(file, exclusive)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockFile(file *os.File) {
+	funlock(file)
+	file.Close()
+}
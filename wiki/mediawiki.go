@@ -0,0 +1,449 @@
+package wiki
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A page of a MediaWiki XML dump, as written by Special:Export or dumpBackup.php
+type mediaWikiPage struct {
+	Title		string	`xml:"title"`
+	Namespace	int		`xml:"ns"`
+	Redirect	*struct {
+		Title	string	`xml:"title,attr"`
+	}	`xml:"redirect"`
+	Revisions	[]mediaWikiRevision	`xml:"revision"`
+}
+
+type mediaWikiRevision struct {
+	Timestamp	string	`xml:"timestamp"`
+	Username	string	`xml:"contributor>username"`
+	Ip			string	`xml:"contributor>ip"`
+	Text		string	`xml:"text"`
+}
+
+// Counts of an import of a MediaWiki dump, and the pages that could not be imported or converted cleanly
+type MediaWikiReport struct {
+	Imported	int
+	Redirects	int  // redirect pages, which are not imported: links to them lead to their targets
+	Skipped		int  // pages outside the main namespace, such as talk, user or template pages
+	Issues		[]*MediaWikiIssue  // in dump order
+}
+
+type MediaWikiIssue struct {
+	Title		string
+	Id			PageId  // "" if the page was not imported
+	Problems	[]string
+}
+
+const MEDIAWIKI_MAIN_NAMESPACE = 0
+
+// Imports the pages of the main namespace of a MediaWiki XML dump, with their history, converting their
+// wikitext into Markdown. Links are resolved to the imported pages, following redirects, or else to the
+// pages of the store with the same title. Categories become tags. Pages whose title is already in the
+// store are not imported, nor are those whose title matches that of an earlier page of the dump.
+func ImportMediaWiki(store PageStore, reader io.Reader) (*MediaWikiReport, error) {
+	normalization := store.TitleMatching()
+	pages, redirects, report, err := readMediaWikiDump(reader, normalization)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]PageId, len(pages))
+	kept := make(map[string]*mediaWikiPage, len(pages))  // the first page with each title key
+	for _, page := range pages {
+		key := mediaWikiTitleKey(page.Title, normalization)
+		if kept[key] != nil {
+			continue
+		}
+		kept[key] = page
+		ids[key], err = NewPageId()
+		if err != nil {
+			return nil, err
+		}
+	}
+	resolve := func(title string) (PageId, error) {
+		key := mediaWikiTitleKey(title, normalization)
+		for n := 0; n < len(redirects) && redirects[key] != ""; n++ {  // bounded, in case of redirect loops
+			key = redirects[key]
+		}
+		if id, found := ids[key]; found {
+			return id, nil
+		}
+		return store.FindByTitle(strings.Replace(title, "_", " ", -1))
+	}
+
+	for _, dumped := range pages {
+		key := mediaWikiTitleKey(dumped.Title, normalization)
+		if first := kept[key]; first != dumped {
+			problem := fmt.Sprintf("not imported: the title matches that of the earlier page %q", first.Title)
+			report.Issues = append(report.Issues, &MediaWikiIssue{dumped.Title, "", []string{problem}})
+			continue
+		}
+		id := ids[key]
+		page, revisions, problems, err := convertMediaWikiPage(dumped, id, resolve)
+		if err != nil {
+			return report, err
+		}
+		if page == nil {
+			report.Issues = append(report.Issues, &MediaWikiIssue{dumped.Title, "", problems})
+			continue
+		}
+
+		err = store.Import(page, revisions)
+		if duplicateErr, ok := err.(DuplicateTitleError); ok {
+			problem := fmt.Sprintf("not imported: the title is already used by page %s", duplicateErr.Id)
+			report.Issues = append(report.Issues, &MediaWikiIssue{dumped.Title, "", []string{problem}})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Imported++
+		if len(problems) > 0 {
+			report.Issues = append(report.Issues, &MediaWikiIssue{page.Title, id, problems})
+		}
+	}
+	return report, nil
+}
+
+// Reads the pages of the main namespace and the redirects, by title key
+func readMediaWikiDump(reader io.Reader, normalization TitleNormalization) ([]*mediaWikiPage, map[string]string, *MediaWikiReport, error) {
+	var pages []*mediaWikiPage
+	redirects := make(map[string]string)
+	report := &MediaWikiReport{}
+
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+
+		page := &mediaWikiPage{}
+		err = decoder.DecodeElement(page, &start)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch {
+		case page.Namespace != MEDIAWIKI_MAIN_NAMESPACE:
+			report.Skipped++
+		case page.Redirect != nil:
+			redirects[mediaWikiTitleKey(page.Title, normalization)] = mediaWikiTitleKey(page.Redirect.Title, normalization)
+			report.Redirects++
+		case redirectTarget(page) != "":  // older dumps have no <redirect> element
+			redirects[mediaWikiTitleKey(page.Title, normalization)] = mediaWikiTitleKey(redirectTarget(page), normalization)
+			report.Redirects++
+		default:
+			pages = append(pages, page)
+		}
+	}
+	return pages, redirects, report, nil
+}
+
+var wikiRedirectPattern = regexp.MustCompile(`(?i)^\s*#REDIRECT\s*:?\s*\[\[([^\[\]|#]+)`)
+
+// Returns the target of a page whose last revision is a redirect, or ""
+func redirectTarget(page *mediaWikiPage) string {
+	if len(page.Revisions) == 0 {
+		return ""
+	}
+	submatches := wikiRedirectPattern.FindStringSubmatch(page.Revisions[len(page.Revisions)-1].Text)
+	if submatches == nil {
+		return ""
+	}
+	return submatches[1]
+}
+
+// MediaWiki titles are the same with underscores or spaces, and with a lowercase or uppercase first letter
+func mediaWikiTitleKey(title string, normalization TitleNormalization) string {
+	title = strings.TrimSpace(strings.Replace(title, "_", " ", -1))
+	if title == "" {
+		return ""
+	}
+	first, size := utf8.DecodeRuneInString(title)
+	return normalization.Normalize(string(unicode.ToUpper(first)) + title[size:])
+}
+
+// Converts a dumped page and its revisions. Returns a nil page, with the reason, if it cannot be imported.
+// The problems are those of the last revision.
+func convertMediaWikiPage(dumped *mediaWikiPage, id PageId, resolve func(string) (PageId, error)) (*Page, []*Revision, []string, error) {
+	if len(dumped.Revisions) == 0 {
+		return nil, nil, []string{"not imported: the dump has no revisions of the page"}, nil
+	}
+
+	page := &Page{Id: id, Title: strings.Replace(dumped.Title, "_", " ", -1), Version: len(dumped.Revisions)}
+	var revisions []*Revision
+	var problems []string
+	for k, dumpedRevision := range dumped.Revisions {
+		timestamp, err := time.Parse(time.RFC3339, dumpedRevision.Timestamp)
+		if err != nil {
+			return nil, nil, []string{fmt.Sprintf("not imported: invalid timestamp %q", dumpedRevision.Timestamp)}, nil
+		}
+		author := dumpedRevision.Username
+		if author == "" {
+			author = dumpedRevision.Ip
+		}
+
+		var body string
+		var tags []string
+		body, tags, problems, err = convertWikitext(dumpedRevision.Text, resolve)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		revisions = append(revisions, &Revision{Number: k + 1, Timestamp: timestamp, Title: page.Title, Body: body, Author: author})
+		page.Body, page.Tags, page.Author = body, tags, author
+	}
+	page.Created, page.Modified = revisions[0].Timestamp, revisions[len(revisions)-1].Timestamp
+	return page, revisions, problems, nil
+}
+
+var (
+	wikiHeadingPattern = regexp.MustCompile(`^(=+)\s*(.*?)\s*(=+)\s*$`)
+	wikiListPattern = regexp.MustCompile(`^([*#]+)\s*(.*)$`)
+	wikiPreStartPattern = regexp.MustCompile(`^\s*<(pre|source|syntaxhighlight)\b(?:[^>]*\blang="?([a-zA-Z0-9_+-]+)"?)?[^>]*>(.*)$`)
+	wikiPreEndPattern = regexp.MustCompile(`</(pre|source|syntaxhighlight)>`)
+	wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|]*)(?:\|([^\[\]]*))?\]\](\pL*)`)
+	wikiExternalLinkPattern = regexp.MustCompile(`\[((?:https?|ftp|mailto):[^\s\]]+)(?:\s+([^\]]*))?\]`)
+	wikiBoldItalicPattern = regexp.MustCompile(`'''''(.+?)'''''`)
+	wikiBoldPattern = regexp.MustCompile(`'''(.+?)'''`)
+	wikiItalicPattern = regexp.MustCompile(`''(.+?)''`)
+	wikiMagicWordPattern = regexp.MustCompile(`__[A-Z]+__`)
+	wikiTagPattern = regexp.MustCompile(`<([a-zA-Z]+)[\s/>]`)
+)
+
+// HTML elements that Markdown keeps as they are; other tags are MediaWiki extensions, such as <ref> or <nowiki>
+var markdownHtmlTags = map[string]bool{
+	"b": true, "i": true, "u": true, "s": true, "del": true, "ins": true, "sub": true, "sup": true,
+	"small": true, "big": true, "code": true, "tt": true, "br": true, "span": true, "div": true, "p": true,
+	"blockquote": true, "strike": true, "hr": true,
+}
+
+// Converts wikitext into Markdown with references to page ids, returning the categories as tags, and
+// a description of the constructs that could not be converted, which are kept as they are
+func convertWikitext(text string, resolve func(string) (PageId, error)) (string, []string, []string, error) {
+	converter := &wikitextConverter{resolve: resolve, problemSet: make(map[string]bool)}
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		err := converter.convertLine(line)
+		if err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if converter.inPre {
+		converter.problem("unterminated preformatted block")
+		converter.write("```", "code")
+	}
+	return strings.TrimSpace(strings.Join(converter.lines, "\n")) + "\n", NormalizeTags(converter.tags), converter.problems, nil
+}
+
+type wikitextConverter struct {
+	resolve		func(string) (PageId, error)
+	lines		[]string
+	block		string  // kind of the last line written: "", "text", "list", "code", "rule" or "heading"
+	inPre		bool
+	tags		[]string
+	problems	[]string
+	problemSet	map[string]bool
+}
+
+func (converter *wikitextConverter) problem(description string) {
+	if !converter.problemSet[description] {
+		converter.problemSet[description] = true
+		converter.problems = append(converter.problems, description)
+	}
+}
+
+// Writes a line, separated by a blank line from a previous block of another kind, as Markdown requires
+// except around headings
+func (converter *wikitextConverter) write(line, block string) {
+	if block != converter.block && converter.block != "" && converter.block != "heading" && block != "" &&
+		block != "heading" && converter.lines[len(converter.lines)-1] != "" {
+		converter.lines = append(converter.lines, "")
+	}
+	converter.lines = append(converter.lines, line)
+	converter.block = block
+}
+
+func (converter *wikitextConverter) convertLine(line string) error {
+	if converter.inPre {
+		if end := wikiPreEndPattern.FindStringIndex(line); end != nil {
+			converter.inPre = false
+			if content := line[:end[0]]; strings.TrimSpace(content) != "" {
+				converter.write(content, "code")
+			}
+			converter.write("```", "code")
+			return nil
+		}
+		converter.write(line, "code")
+		return nil
+	}
+
+	if submatches := wikiPreStartPattern.FindStringSubmatch(line); submatches != nil {
+		converter.write("```"+submatches[2], "code")
+		converter.inPre = true
+		if strings.TrimSpace(submatches[3]) == "" {
+			return nil
+		}
+		return converter.convertLine(submatches[3])
+	}
+
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "":
+		converter.write("", "")
+		return nil
+	case strings.HasPrefix(line, " "):  // preformatted text
+		converter.write("    "+line[1:], "code")
+		return nil
+	case strings.HasPrefix(trimmed, "----"):
+		converter.write("---", "rule")
+		return nil
+	case strings.HasPrefix(trimmed, "{|") || strings.HasPrefix(trimmed, "|") || strings.HasPrefix(trimmed, "!"):
+		converter.problem("tables are not converted")
+		converter.write(line, "text")
+		return nil
+	case strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, ":"):
+		converter.problem("definition lists and indentation are not converted")
+	}
+
+	if submatches := wikiHeadingPattern.FindStringSubmatch(trimmed); submatches != nil {
+		level := len(submatches[1])
+		if len(submatches[3]) < level {
+			level = len(submatches[3])
+		}
+		if level > 6 {
+			level = 6
+		}
+		text, err := converter.convertInline(submatches[2])
+		if err != nil {
+			return err
+		}
+		converter.write(strings.Repeat("#", level)+" "+text, "heading")
+		return nil
+	}
+
+	if submatches := wikiListPattern.FindStringSubmatch(line); submatches != nil {
+		markers := submatches[1]
+		marker := "-"
+		if strings.HasSuffix(markers, "#") {
+			marker = "1."
+		}
+		text, err := converter.convertInline(submatches[2])
+		if err != nil {
+			return err
+		}
+		converter.write(strings.Repeat("    ", len(markers)-1)+marker+" "+text, "list")
+		return nil
+	}
+
+	text, err := converter.convertInline(line)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {  // only categories or magic words
+		return nil
+	}
+	converter.write(text, "text")
+	return nil
+}
+
+func (converter *wikitextConverter) convertInline(text string) (string, error) {
+	if strings.Contains(text, "{{") {
+		converter.problem("templates are not converted")
+	}
+	for _, submatches := range wikiTagPattern.FindAllStringSubmatch(text, -1) {
+		if tag := strings.ToLower(submatches[1]); !markdownHtmlTags[tag] {
+			converter.problem(fmt.Sprintf("<%s> tags are not converted", tag))
+		}
+	}
+	text = wikiMagicWordPattern.ReplaceAllString(text, "")
+
+	var err error
+	text = wikiLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		if err != nil {
+			return link
+		}
+		var converted string
+		converted, err = converter.convertLink(wikiLinkPattern.FindStringSubmatch(link))
+		return converted
+	})
+	if err != nil {
+		return "", err
+	}
+
+	text = wikiExternalLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		submatches := wikiExternalLinkPattern.FindStringSubmatch(link)
+		if submatches[2] == "" {
+			return "<" + submatches[1] + ">"
+		}
+		return fmt.Sprintf("[%s](%s)", submatches[2], submatches[1])
+	})
+
+	text = wikiBoldItalicPattern.ReplaceAllString(text, "***$1***")
+	text = wikiBoldPattern.ReplaceAllString(text, "**$1**")
+	text = wikiItalicPattern.ReplaceAllString(text, "*$1*")
+	return text, nil
+}
+
+// Converts [[target|text]]trail into a page reference
+func (converter *wikitextConverter) convertLink(submatches []string) (string, error) {
+	target, text, trail := strings.TrimSpace(submatches[1]), submatches[2], submatches[3]
+
+	if namespace, name, found := strings.Cut(target, ":"); found && !strings.HasPrefix(target, ":") {
+		switch strings.ToLower(strings.TrimSpace(namespace)) {
+		case "category":
+			converter.tags = append(converter.tags, name)
+			return "", nil
+		case "file", "image", "media":
+			converter.problem("images and files are not imported")
+			return submatches[0], nil
+		default:
+			converter.problem("links to other namespaces or wikis are not converted")
+			if text == "" {
+				text = target
+			}
+			return text + trail, nil
+		}
+	}
+	target = strings.TrimPrefix(target, ":")
+
+	if page, section, found := strings.Cut(target, "#"); found {
+		if page == "" {
+			converter.problem("links to sections are not converted")
+			if text == "" {
+				text = section
+			}
+			return text + trail, nil
+		}
+		converter.problem("links to sections lead to the whole page")
+		target = page
+	}
+	id, err := converter.resolve(target)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		converter.problem("links to missing pages: " + target)
+		id = PageId(target)  // as when editing, the link will lead to a page created with the title later
+	}
+	if text == "" && trail == "" {  // shown with the title of the page
+		return "[" + string(id) + "][]", nil
+	}
+	if text == "" {
+		text = target
+	}
+	return "[" + text + trail + "][" + string(id) + "]", nil
+}
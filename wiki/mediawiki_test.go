@@ -0,0 +1,167 @@
+package wiki
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMediaWikiDump = `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.10/" version="0.10">
+  <siteinfo><sitename>Old KB</sitename></siteinfo>
+  <page>
+    <title>Main Page</title>
+    <ns>0</ns>
+    <id>1</id>
+    <revision>
+      <timestamp>2015-03-01T10:00:00Z</timestamp>
+      <contributor><username>Admin</username></contributor>
+      <text xml:space="preserve">Welcome.</text>
+    </revision>
+    <revision>
+      <timestamp>2016-05-02T11:30:00Z</timestamp>
+      <contributor><ip>10.0.0.1</ip></contributor>
+      <text xml:space="preserve">== Topics ==
+See [[Build_server|the build server]] and the [[deploy]]s.
+[[Category:Home]]</text>
+    </revision>
+  </page>
+  <page>
+    <title>Build server</title>
+    <ns>0</ns>
+    <id>2</id>
+    <revision>
+      <timestamp>2015-04-01T09:00:00Z</timestamp>
+      <contributor><username>Ops</username></contributor>
+      <text xml:space="preserve">{{Stub}}
+Ask in [[Existing page]] or [[Nowhere]].</text>
+    </revision>
+  </page>
+  <page>
+    <title>Deploy</title>
+    <ns>0</ns>
+    <redirect title="Build server" />
+    <revision>
+      <timestamp>2015-04-02T09:00:00Z</timestamp>
+      <contributor><username>Ops</username></contributor>
+      <text xml:space="preserve">#REDIRECT [[Build server]]</text>
+    </revision>
+  </page>
+  <page>
+    <title>Talk:Main Page</title>
+    <ns>1</ns>
+    <revision>
+      <timestamp>2015-04-03T09:00:00Z</timestamp>
+      <contributor><username>Ops</username></contributor>
+      <text xml:space="preserve">Nice.</text>
+    </revision>
+  </page>
+</mediawiki>`
+
+func TestImportMediaWiki(t *testing.T) {
+	store := NewMemoryStore()
+	existing := mustCreateChild(t, store, "Existing page", "")
+
+	report, err := ImportMediaWiki(store, strings.NewReader(testMediaWikiDump))
+	if err != nil {
+		t.Fatalf("ImportMediaWiki: %s", err)
+	}
+	if report.Imported != 2 || report.Redirects != 1 || report.Skipped != 1 {
+		t.Errorf("ImportMediaWiki: expected 2 pages imported, 1 redirect and 1 skipped, found %+v", report)
+	}
+
+	main, err := store.FindByTitle("Main Page")
+	if err != nil || main == "" {
+		t.Fatalf("PageStore.FindByTitle: main page not found (%v)", err)
+	}
+	build, _ := store.FindByTitle("Build server")
+	page, err := store.Read(main)
+	if err != nil {
+		t.Fatalf("PageStore.Read: %s", err)
+	}
+	expected := "## Topics\nSee [the build server][" + string(build) + "] and the [deploys][" + string(build) + "].\n"
+	if page.Body != expected {
+		t.Errorf("ImportMediaWiki: expected body %q, found %q", expected, page.Body)
+	}
+	if page.Version != 2 || page.Author != "10.0.0.1" || page.Created.Year() != 2015 || page.Modified.Year() != 2016 {
+		t.Errorf("ImportMediaWiki: unexpected metadata %+v", page)
+	}
+	if !reflect.DeepEqual(page.Tags, []string{"home"}) {
+		t.Errorf("ImportMediaWiki: expected categories as tags, found %v", page.Tags)
+	}
+	revisions, err := store.ListRevisions(main)
+	if err != nil || len(revisions) != 2 || revisions[0].Author != "Admin" || revisions[0].Body != "Welcome.\n" {
+		t.Errorf("ImportMediaWiki: unexpected history %+v (%v)", revisions, err)
+	}
+
+	page, _ = store.Read(build)
+	if !strings.Contains(page.Body, "Ask in ["+string(existing)+"][] or [Nowhere][].") {
+		t.Errorf("ImportMediaWiki: links not resolved through the store in %q", page.Body)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Id != build ||
+		!reflect.DeepEqual(report.Issues[0].Problems, []string{"templates are not converted", "links to missing pages: Nowhere"}) {
+		t.Errorf("ImportMediaWiki: unexpected issues %+v", report.Issues)
+	}
+
+	report, err = ImportMediaWiki(store, strings.NewReader(testMediaWikiDump))
+	if err != nil || report.Imported != 0 || len(report.Issues) != 2 || report.Issues[0].Id != "" {
+		t.Errorf("ImportMediaWiki: expected existing titles not to be imported again, found %+v (%v)", report, err)
+	}
+}
+
+func TestImportMediaWikiTitleCollisions(t *testing.T) {
+	dump := `<mediawiki>
+  <page><title>Release notes</title><ns>0</ns>
+    <revision><timestamp>2015-03-01T10:00:00Z</timestamp><text>First.</text></revision></page>
+  <page><title>release_notes</title><ns>0</ns>
+    <revision><timestamp>2015-03-02T10:00:00Z</timestamp><text>Second.</text></revision></page>
+</mediawiki>`
+	store := NewMemoryStore()
+	report, err := ImportMediaWiki(store, strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("ImportMediaWiki: %s", err)
+	}
+	if report.Imported != 1 || len(report.Issues) != 1 || report.Issues[0].Title != "release_notes" || report.Issues[0].Id != "" {
+		t.Errorf("ImportMediaWiki: expected the second page to be reported as not imported, found %+v", report)
+	}
+
+	id, _ := store.FindByTitle("Release notes")
+	page, err := store.Read(id)
+	if err != nil || page.Body != "First.\n" {
+		t.Errorf("ImportMediaWiki: expected the first page to be kept, found %+v (%v)", page, err)
+	}
+}
+
+func TestConvertWikitext(t *testing.T) {
+	resolve := func(title string) (PageId, error) {
+		if title == "Known" {
+			return "known", nil
+		}
+		return "", nil
+	}
+	tests := []struct {
+		wikitext	string
+		markdown	string
+	}{
+		{"= Title =\n=== Section ===", "# Title\n### Section\n"},
+		{"'''bold''', ''italic'' and '''''both'''''", "**bold**, *italic* and ***both***\n"},
+		{"Intro\n* one\n** nested\n# first\n#* mixed", "Intro\n\n- one\n    - nested\n1. first\n    - mixed\n"},
+		{"[[Known]] [[Known|text]] [[:Known]]", "[known][] [text][known] [known][]\n"},
+		{"[http://example.com Example] and [http://example.com]", "[Example](http://example.com) and <http://example.com>\n"},
+		{"Code:\n a = 1\n b = 2", "Code:\n\n    a = 1\n    b = 2\n"},
+		{"<syntaxhighlight lang=\"go\">\nx := 1\n</syntaxhighlight>", "```go\nx := 1\n```\n"},
+		{"__TOC__\nText\n----", "Text\n\n---\n"},
+	}
+	for _, test := range tests {
+		markdown, _, problems, err := convertWikitext(test.wikitext, resolve)
+		if err != nil || markdown != test.markdown || len(problems) > 0 {
+			t.Errorf("convertWikitext(%q): expected %q, found %q %v (%v)", test.wikitext, test.markdown, markdown, problems, err)
+		}
+	}
+
+	_, _, problems, _ := convertWikitext("{|\n| cell\n|}\n<ref>note</ref> [[File:a.png]] [[wikipedia:Go]]", resolve)
+	expected := []string{"tables are not converted", "<ref> tags are not converted", "images and files are not imported",
+		"links to other namespaces or wikis are not converted"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("convertWikitext: expected problems %q, found %q", expected, problems)
+	}
+}
@@ -0,0 +1,101 @@
+package wiki
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An AttachmentStore that keeps files in memory, for tests and ephemeral wikis
+type memoryAttachmentStore struct {
+	mutex	sync.RWMutex
+	files	map[PageId]map[string]*memoryAttachment
+}
+
+type memoryAttachment struct {
+	Attachment
+	content []byte
+}
+
+func NewMemoryAttachmentStore() AttachmentStore {
+	return &memoryAttachmentStore{files: make(map[PageId]map[string]*memoryAttachment)}
+}
+
+func (store *memoryAttachmentStore) Put(page PageId, name string, content io.Reader) (*Attachment, error) {
+	if !ValidAttachmentName(name) {
+		return nil, InvalidAttachmentNameError{name}
+	}
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.files[page] == nil {
+		store.files[page] = make(map[string]*memoryAttachment)
+	}
+	file := &memoryAttachment{Attachment{Name: name, Size: int64(len(data)), Modified: time.Now()}, data}
+	store.files[page][name] = file
+
+	attachment := file.Attachment
+	return &attachment, nil
+}
+
+func (store *memoryAttachmentStore) Open(page PageId, name string) (io.ReadSeekCloser, *Attachment, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	file, found := store.files[page][name]
+	if !found {
+		return nil, nil, UnexistentAttachmentError{page, name}
+	}
+
+	attachment := file.Attachment
+	return nopCloser{bytes.NewReader(file.content)}, &attachment, nil  // content is never modified, only replaced
+}
+
+func (store *memoryAttachmentStore) List(page PageId) ([]*Attachment, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var result []*Attachment
+	for _, file := range store.files[page] {
+		attachment := file.Attachment
+		result = append(result, &attachment)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (store *memoryAttachmentStore) Delete(page PageId, name string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, found := store.files[page][name]; !found {
+		return UnexistentAttachmentError{page, name}
+	}
+	delete(store.files[page], name)
+	return nil
+}
+
+func (store *memoryAttachmentStore) DeleteAll(page PageId) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.files, page)
+	return nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
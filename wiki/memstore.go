@@ -0,0 +1,385 @@
+package wiki
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A PageStore that keeps pages in memory, for tests and ephemeral wikis
+type memoryStore struct {
+	mutex		sync.RWMutex
+	pages		map[PageId]*Page
+	revisions	map[PageId][]*Revision
+	trash		map[PageId]*trashedEntry
+	titles		*titleIndex
+	tags		*tagIndex
+	tree		*treeIndex
+}
+
+type trashedEntry struct {
+	page		*Page
+	revisions	[]*Revision
+	deleted		time.Time
+}
+
+// Creates an empty memory store, matching titles with the default normalization
+func NewMemoryStore() PageStore {
+	return newMemoryStore(DEFAULT_TITLE_NORMALIZATION)
+}
+
+// Like NewMemoryStore, matching titles with the given normalization
+func NewMemoryStoreWith(normalization TitleNormalization) PageStore {
+	return newMemoryStore(normalization)
+}
+
+func newMemoryStore(normalization TitleNormalization) *memoryStore {
+	return &memoryStore{
+		pages: make(map[PageId]*Page),
+		revisions: make(map[PageId][]*Revision),
+		trash: make(map[PageId]*trashedEntry),
+		titles: newTitleIndex(normalization),
+		tags: newTagIndex(),
+		tree: newTreeIndex()}
+}
+
+// Creates a memory store with the pages of a JSON snapshot, as written by WriteSnapshot, matching titles
+// with the given normalization
+func LoadMemoryStore(reader io.Reader, normalization TitleNormalization) (PageStore, error) {
+	var pages []*Page
+	err := json.NewDecoder(reader).Decode(&pages)
+	if err != nil {
+		return nil, err
+	}
+
+	store := newMemoryStore(normalization)
+	for _, page := range pages {
+		if page.Id == "" {
+			page.Id, err = NewPageId()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if page.Version == 0 {
+			page.Version = 1
+		}
+		page.Tags = NormalizeTags(page.Tags)
+		now := time.Now()
+		if page.Created.IsZero() {
+			page.Created = now
+		}
+		if page.Modified.IsZero() {
+			page.Modified = now
+		}
+		store.put(page, now)
+	}
+	for _, page := range pages {
+		err = store.tree.checkParent(page.Id, page.Parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Writes all the pages of a store as a JSON snapshot. Pages in the trash are not included.
+func WriteSnapshot(store PageStore, writer io.Writer) error {
+	ids, err := store.ListAll()
+	if err != nil {
+		return err
+	}
+
+	pages := make([]*Page, len(ids))
+	for k, id := range ids {
+		pages[k], err = store.Read(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(pages)
+}
+
+func (store *memoryStore) Create(page *Page) (PageId, error) {
+	id, err := NewPageId()
+	if err != nil {
+		return "", err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if page.Parent != "" && !store.tree.exists(page.Parent) {
+		return "", InvalidParentError{"", page.Parent, "unexistent page"}
+	}
+	if owner := store.titles.find(page.Title); owner != "" {
+		return "", DuplicateTitleError{page.Title, owner}
+	}
+
+	now := time.Now()
+	page.Id = id
+	page.Version = 1
+	page.Tags = NormalizeTags(page.Tags)
+	page.Created, page.Modified = now, now
+	store.put(page, now)
+	return id, nil
+}
+
+func (store *memoryStore) Read(id PageId) (*Page, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	page, found := store.pages[id]
+	if !found {
+		return nil, UnexistentPageError{id}
+	}
+	return copyPage(page), nil
+}
+
+func (store *memoryStore) Update(page *Page) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, found := store.pages[page.Id]
+	if !found {
+		return UnexistentPageError{page.Id}
+	}
+	if page.Version != current.Version {
+		return ConflictError{Current: copyPage(current), Rejected: page}
+	}
+
+	if !store.titles.sameTitle(page.Title, current.Title) {
+		if owner := store.titles.find(page.Title); owner != "" {
+			return DuplicateTitleError{page.Title, owner}
+		}
+	}
+	if page.Parent != current.Parent {
+		err := store.tree.checkParent(page.Id, page.Parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	page.Version = current.Version + 1
+	page.Tags = NormalizeTags(page.Tags)
+	page.Created, page.Modified = current.Created, now
+	store.put(page, now)
+	return nil
+}
+
+func (store *memoryStore) Import(page *Page, revisions []*Revision) error {
+	if !ValidPageId(page.Id) {
+		return InvalidPageIdError{page.Id}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if owner := store.titles.find(page.Title); owner != "" && owner != page.Id {
+		return DuplicateTitleError{page.Title, owner}
+	}
+	err := store.tree.checkParent(page.Id, page.Parent)
+	if err != nil {
+		return err
+	}
+
+	page.Tags = NormalizeTags(page.Tags)
+	store.pages[page.Id] = copyPage(page)
+	delete(store.trash, page.Id)  // a page in the trash with the same id is replaced as well
+	store.titles.put(page.Id, page.Title)
+	store.tags.put(page.Id, page.Tags)
+	store.tree.put(page.Id, page.Parent)
+
+	store.revisions[page.Id] = nil
+	for _, revision := range revisions {
+		revisionCopy := *revision
+		store.revisions[page.Id] = append(store.revisions[page.Id], &revisionCopy)
+	}
+	return nil
+}
+
+func (store *memoryStore) Delete(id PageId) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	page, found := store.pages[id]
+	if !found {
+		return UnexistentPageError{id}
+	}
+	if children := store.tree.find(id); len(children) > 0 {
+		return HasChildrenError{id, children}
+	}
+	store.trash[id] = &trashedEntry{page: page, revisions: store.revisions[id], deleted: time.Now()}
+	delete(store.pages, id)
+	delete(store.revisions, id)
+	store.titles.remove(id)
+	store.tags.remove(id)
+	store.tree.remove(id)
+	return nil
+}
+
+func (store *memoryStore) ListTrash() ([]*TrashedPage, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result := make([]*TrashedPage, 0, len(store.trash))
+	for id, entry := range store.trash {
+		result = append(result, &TrashedPage{Id: id, Title: entry.page.Title, Version: entry.page.Version, Deleted: entry.deleted})
+	}
+	sort.Sort(trashedPagesByDeletion(result))
+	return result, nil
+}
+
+func (store *memoryStore) Restore(id PageId) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entry, found := store.trash[id]
+	if !found {
+		return NotInTrashError{id}
+	}
+	if _, found := store.pages[id]; found {
+		return ExistingPageError{id}
+	}
+	if owner := store.titles.find(entry.page.Title); owner != "" {
+		return DuplicateTitleError{entry.page.Title, owner}
+	}
+
+	if entry.page.Parent != "" && !store.tree.exists(entry.page.Parent) {  // deleted meanwhile
+		entry.page.Parent = ""
+	}
+	store.pages[id] = entry.page
+	store.revisions[id] = entry.revisions
+	store.titles.put(id, entry.page.Title)
+	store.tags.put(id, entry.page.Tags)
+	store.tree.put(id, entry.page.Parent)
+	delete(store.trash, id)
+	return nil
+}
+
+func (store *memoryStore) Purge(id PageId) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, found := store.trash[id]; !found {
+		return NotInTrashError{id}
+	}
+	delete(store.trash, id)
+	return nil
+}
+
+func (store *memoryStore) PurgeTrash(before time.Time) (int, error) {
+	return purgeTrash(store, before)
+}
+
+func (store *memoryStore) ListAll() ([]PageId, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result := make([]PageId, 0, len(store.pages))
+	for id := range store.pages {
+		result = append(result, id)
+	}
+	sort.Sort(pageIdList(result))
+	return result, nil
+}
+
+func (store *memoryStore) ListRange(options ListOptions) ([]PageId, error) {
+	ids, err := store.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	return selectRange(ids, options), nil
+}
+
+func (store *memoryStore) ListSummaries(options ListOptions) ([]*PageSummary, error) {
+	ids, err := store.ListRange(options)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result := make([]*PageSummary, 0, len(ids))
+	for _, id := range ids {
+		if page, found := store.pages[id]; found {  // unless deleted meanwhile
+			result = append(result, summarizePage(page))
+		}
+	}
+	return result, nil
+}
+
+// Pages are all in memory anyway
+func (store *memoryStore) ListIndexedSummaries() ([]*PageSummary, error) {
+	return store.ListSummaries(ListOptions{})
+}
+
+func (store *memoryStore) FindByTitle(title string) (PageId, error) {
+	return store.titles.find(title), nil
+}
+
+func (store *memoryStore) FindByTag(tag string) ([]PageId, error) {
+	return store.tags.find(NormalizeTag(tag)), nil
+}
+
+func (store *memoryStore) FindChildren(id PageId) ([]PageId, error) {
+	return store.tree.find(id), nil
+}
+
+func (store *memoryStore) ListTags() (map[string]int, error) {
+	return store.tags.counts(), nil
+}
+
+func (store *memoryStore) TitleMatching() TitleNormalization {
+	return store.titles.normalization
+}
+
+func (store *memoryStore) ListRevisions(id PageId) ([]*Revision, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	revisions, found := store.revisions[id]
+	if !found {
+		return nil, UnexistentPageError{id}
+	}
+	return append([]*Revision(nil), revisions...), nil
+}
+
+func (store *memoryStore) ReadRevision(id PageId, number int) (*Revision, error) {
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		if revision.Number == number {
+			return revision, nil
+		}
+	}
+	return nil, UnexistentRevisionError{id, number}
+}
+
+// Stores a copy of the page and a new revision of it; the caller must hold the write lock
+func (store *memoryStore) put(page *Page, timestamp time.Time) {
+	store.pages[page.Id] = copyPage(page)
+	store.titles.put(page.Id, page.Title)
+	store.tags.put(page.Id, page.Tags)
+	store.tree.put(page.Id, page.Parent)
+
+	number := len(store.revisions[page.Id]) + 1
+	revision := &Revision{Number: number, Timestamp: timestamp, Title: page.Title, Body: page.Body, Author: page.Author}
+	store.revisions[page.Id] = append(store.revisions[page.Id], revision)
+}
+
+// Copies a page, so that callers cannot modify the stored one
+func copyPage(page *Page) *Page {
+	pageCopy := *page
+	pageCopy.Tags = append([]string(nil), page.Tags...)
+	return &pageCopy
+}
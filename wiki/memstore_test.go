@@ -0,0 +1,135 @@
+package wiki
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStoreCreateReadUpdate(t *testing.T) {
+	store := NewMemoryStore()
+
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	pageRead, err := store.Read(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(pageRead, page) {
+		t.Errorf("memoryStore.Read(%q): expected %v, found %v", id, page, pageRead)
+		return
+	}
+
+	pageRead.Body = "Modified without updating."  // pages returned by the store must be copies
+	page.Body = "This is a modified page body."
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	pageRead, err = store.Read(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(pageRead, page) {
+		t.Errorf("memoryStore.Read(%q): expected %v, found %v", id, page, pageRead)
+		return
+	}
+
+	pageId, err := store.FindByTitle(page.Title)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pageId != id {
+		t.Errorf("memoryStore.FindByTitle: expected %q, found %q", id, pageId)
+		return
+	}
+
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(revisions) != 2 {
+		t.Errorf("memoryStore.ListRevisions(%q): expected 2 revisions, found %d", id, len(revisions))
+		return
+	}
+}
+
+func TestMemoryStoreUnexistentPageError(t *testing.T) {
+	store := NewMemoryStore()
+
+	id := PageId("unexistent")
+	_, err := store.Read(id)
+	if err != (UnexistentPageError{id}) {
+		t.Errorf("memoryStore.Read: UnexistentPageError was expected, found %v", err)
+		return
+	}
+
+	err = store.Update(&Page{Id: id})
+	if err != (UnexistentPageError{id}) {
+		t.Errorf("memoryStore.Update: UnexistentPageError was expected, found %v", err)
+		return
+	}
+
+	err = store.Delete(id)
+	if err != (UnexistentPageError{id}) {
+		t.Errorf("memoryStore.Delete: UnexistentPageError was expected, found %v", err)
+		return
+	}
+}
+
+func TestMemoryStoreSnapshot(t *testing.T) {
+	seed := `[
+		{"Id": "0000000000a1", "Title": "Home", "Body": "See [Other Page][0000000000a2]."},
+		{"Id": "0000000000a2", "Title": "Other Page", "Body": "Some text."}
+	]`
+
+	store, err := LoadMemoryStore(strings.NewReader(seed), DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page, err := store.Read("0000000000a2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if page.Title != "Other Page" || page.Version != 1 {
+		t.Errorf("LoadMemoryStore: unexpected page %v", page)
+		return
+	}
+
+	var snapshot bytes.Buffer
+	err = WriteSnapshot(store, &snapshot)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	reloaded, err := LoadMemoryStore(&snapshot, DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ids, err := reloaded.ListAll()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ids) != 2 || ids[0] != "0000000000a1" || ids[1] != "0000000000a2" {
+		t.Errorf("WriteSnapshot: unexpected pages %v", ids)
+		return
+	}
+}
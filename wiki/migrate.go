@@ -0,0 +1,180 @@
+package wiki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Counts of a migration between page stores
+type MigrationReport struct {
+	Copied	int  // pages copied to the target store
+	Skipped	int  // pages already in the target store, copied by an interrupted migration
+}
+
+// Copies all the pages of a store into another one, with their ids, metadata and history, and then
+// verifies that both stores have the same number of pages and that their checksums match. Pages
+// already in the target store with the same checksum are skipped, so that an interrupted migration
+// can be resumed by running it again. Parents are copied before their children. Pages in the trash
+// are not copied.
+func MigratePages(from, to PageStore) (*MigrationReport, error) {
+	ids, err := listParentsFirst(from)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MigrationReport{}
+	for _, id := range ids {
+		page, revisions, checksum, err := readWithChecksum(from, id)
+		if err != nil {
+			return report, err
+		}
+
+		_, _, copied, err := readWithChecksum(to, id)
+		if _, unexistent := err.(UnexistentPageError); !unexistent && err != nil {
+			return report, err
+		}
+		if copied == checksum {
+			report.Skipped++
+			continue
+		}
+
+		err = to.Import(page, revisions)
+		if err != nil {
+			return report, err
+		}
+		report.Copied++
+	}
+
+	return report, VerifyMigration(from, to)
+}
+
+// Checks that two stores have the same number of pages, and that the pages of the first one are in
+// the second one with the same checksum
+func VerifyMigration(from, to PageStore) error {
+	fromIds, err := from.ListAll()
+	if err != nil {
+		return err
+	}
+	toIds, err := to.ListAll()
+	if err != nil {
+		return err
+	}
+	if len(fromIds) != len(toIds) {
+		return MigrationError{"", fmt.Sprintf("%d pages were migrated to a store that has %d", len(fromIds), len(toIds))}
+	}
+
+	for _, id := range fromIds {
+		_, _, expected, err := readWithChecksum(from, id)
+		if err != nil {
+			return err
+		}
+		_, _, found, err := readWithChecksum(to, id)
+		if _, unexistent := err.(UnexistentPageError); unexistent {
+			return MigrationError{id, "missing page"}
+		}
+		if err != nil {
+			return err
+		}
+		if found != expected {
+			return MigrationError{id, "different checksum"}
+		}
+	}
+	return nil
+}
+
+// Lists the pages of a store so that every page comes after its parent
+func listParentsFirst(store PageStore) ([]PageId, error) {
+	summaries, err := store.ListSummaries(ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make(map[PageId]bool, len(summaries))
+	for _, summary := range summaries {
+		exists[summary.Id] = true
+	}
+	children := make(map[PageId][]PageId)
+	for _, summary := range summaries {
+		parent := summary.Parent
+		if !exists[parent] {  // top-level page
+			parent = ""
+		}
+		children[parent] = append(children[parent], summary.Id)
+	}
+
+	ids := make([]PageId, 0, len(summaries))
+	ids = append(ids, children[""]...)
+	for k := 0; k < len(ids); k++ {
+		ids = append(ids, children[ids[k]]...)
+	}
+	return ids, nil
+}
+
+func readWithChecksum(store PageStore, id PageId) (*Page, []*Revision, string, error) {
+	page, err := store.Read(id)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return page, revisions, PageChecksum(page, revisions), nil
+}
+
+// Returns a checksum of the content, metadata and history of a page, which does not depend on the store
+// it was read from
+func PageChecksum(page *Page, revisions []*Revision) string {
+	type checksummedRevision struct {
+		Number		int
+		Timestamp	int64
+		Title		string
+		Body		string
+		Author		string
+	}
+	checksummed := struct {
+		Id			PageId
+		Title		string
+		Body		string
+		Version		int
+		Created		int64
+		Modified	int64
+		Author		string
+		Tags		[]string
+		Parent		PageId
+		Revisions	[]checksummedRevision
+	}{page.Id, page.Title, page.Body, page.Version, unixNanos(page.Created), unixNanos(page.Modified),
+		page.Author, NormalizeTags(page.Tags), page.Parent, nil}
+	for _, revision := range revisions {
+		checksummed.Revisions = append(checksummed.Revisions, checksummedRevision{
+			revision.Number, unixNanos(revision.Timestamp), revision.Title, revision.Body, revision.Author})
+	}
+
+	content, _ := json.Marshal(checksummed)  // cannot fail
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Timestamps are compared as nanoseconds since the epoch, or 0 if unknown
+func unixNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// Returned when the pages of a migrated store do not match those of the original one
+type MigrationError struct {
+    Id PageId  // "" if the stores have a different number of pages
+    Reason string
+}
+
+func (err MigrationError) Error() string {
+    if err.Id == "" {
+        return "migration failed: " + err.Reason
+    }
+    return fmt.Sprintf("migration failed: page %q: %s", err.Id, err.Reason)
+}
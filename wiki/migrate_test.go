@@ -0,0 +1,82 @@
+package wiki
+
+import (
+	"testing"
+)
+
+func TestMigratePages(t *testing.T) {
+	from := NewMemoryStore()
+	root := mustCreateChild(t, from, "Root", "")
+	child := mustCreateChild(t, from, "Child", root)
+	mustCreateChild(t, from, "Grandchild", child)
+	page, _ := from.Read(root)
+	page.Body, page.Author, page.Tags = "Edited", "editor", []string{"top"}
+	err := from.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+
+	to := NewMemoryStore()
+	report, err := MigratePages(from, to)
+	if err != nil {
+		t.Fatalf("MigratePages: %s", err)
+	}
+	if report.Copied != 3 || report.Skipped != 0 {
+		t.Errorf("MigratePages: expected 3 pages copied, found %+v", report)
+	}
+	migrated, _ := to.Read(root)
+	if migrated.Body != "Edited" || migrated.Version != 2 || !migrated.Created.Equal(page.Created) {
+		t.Errorf("MigratePages: expected %+v, found %+v", page, migrated)
+	}
+
+	mustCreateChild(t, from, "Added Later", child)
+	report, err = MigratePages(from, to)  // as when resuming an interrupted migration
+	if err != nil {
+		t.Fatalf("MigratePages: %s", err)
+	}
+	if report.Copied != 1 || report.Skipped != 3 {
+		t.Errorf("MigratePages: expected 1 page copied and 3 skipped, found %+v", report)
+	}
+}
+
+func TestVerifyMigration(t *testing.T) {
+	from := NewMemoryStore()
+	id := mustCreateChild(t, from, "Page", "")
+	to := NewMemoryStore()
+	_, err := MigratePages(from, to)
+	if err != nil {
+		t.Fatalf("MigratePages: %s", err)
+	}
+
+	page, _ := to.Read(id)
+	page.Body = "Changed"
+	to.Update(page)
+	err = VerifyMigration(from, to)
+	if migrationErr, ok := err.(MigrationError); !ok || migrationErr.Id != id {
+		t.Errorf("VerifyMigration: expected MigrationError for page %q, found %v", id, err)
+	}
+
+	mustCreateChild(t, to, "Extra", "")
+	err = VerifyMigration(from, to)
+	if migrationErr, ok := err.(MigrationError); !ok || migrationErr.Id != "" {
+		t.Errorf("VerifyMigration: expected MigrationError for the page count, found %v", err)
+	}
+}
+
+func TestPageChecksum(t *testing.T) {
+	page := &Page{Id: "id", Title: "Title", Body: "Body", Version: 1, Tags: []string{"a"}}
+	revisions := []*Revision{{Number: 1, Title: "Title", Body: "Body"}}
+	checksum := PageChecksum(page, revisions)
+
+	copied := *page
+	if PageChecksum(&copied, revisions) != checksum {
+		t.Errorf("PageChecksum: expected the same checksum for a copy of the page")
+	}
+	copied.Parent = "parent"
+	if PageChecksum(&copied, revisions) == checksum {
+		t.Errorf("PageChecksum: expected a different checksum for a page with another parent")
+	}
+	if PageChecksum(page, nil) == checksum {
+		t.Errorf("PageChecksum: expected a different checksum for a page without history")
+	}
+}
@@ -2,6 +2,10 @@ package wiki
 
 import (
 	"html/template"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 )
 
 type PageModel struct {
@@ -9,11 +13,220 @@ type PageModel struct {
 	Title		string
 	BodyToEdit	string
 	BodyAsHtml	template.HTML
+	Version		int
+	Error		string  // why the page could not be saved
+	Created		time.Time
+	Modified	time.Time
+	Author		string
+	Tags		[]string
+	TagsToEdit	string
+	CanAttach	bool  // if the server keeps attachments
+	Attachments	[]*AttachmentModel
+	ParentToEdit	string  // title of the parent page, in the create and edit forms
+	Ancestors	[]*PageModel  // from the top-level page down to the parent
+	Children	[]*PageModel  // sorted by title
+	Static		bool  // if rendered for a static export, without the links to edit the wiki
+	Unreadable	bool  // in page lists, if the page could not be read, so only its id is known
 }
 
-type PageListModel []*PageModel
+type AttachmentModel struct {
+	Name	string
+	Size	int64
+	Url		string
+	IsImage	bool
+}
+
+type ConflictModel struct {
+	Current		*PageModel
+	Rejected	*PageModel
+}
+
+type PageListModel struct {
+	Pages	[]*PageModel
+	Sort	string  // one of the keys of pageListOrders
+	View	string  // one of the keys of pageListViews
+	Tree	[]*PageTreeModel  // top-level pages, in the tree view
+	Previous	string  // cursor for the previous pages of the list view, "" if these are the first ones
+	Next		string  // cursor for the next pages of the list view, "" if these are the last ones
+	Unreadable	int  // pages of the list that could not be read
+	Static		bool  // if rendered for a static export, without the links to edit the wiki
+}
+
+// A page with its descendants, for the tree view of the page list
+type PageTreeModel struct {
+	Page		*PageModel
+	Children	[]*PageTreeModel
+}
 
-func (list PageListModel) Len() int           { return len(list) }
-func (list PageListModel) Less(i, j int) bool { return list[i].Title < list[j].Title }
-func (list PageListModel) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+// An order of the page list: pages are sorted by a key, then by id, with the unreadable pages last
+type pageListOrder struct {
+	key			func(*PageModel) string
+	descending	bool
+}
+
+// Orders of the page list, by value of the sort parameter; the most recent pages are listed first
+var pageListOrders = map[string]pageListOrder{
+	ID_PAGE_LIST_ORDER: {func(page *PageModel) string { return "" }, false},
+	"title": {func(page *PageModel) string { return page.Title }, false},
+	"created": {func(page *PageModel) string { return timeKey(page.Created) }, true},
+	"modified": {func(page *PageModel) string { return timeKey(page.Modified) }, true},
+}
+
+// Formats a time so that times compare as their keys do
+func timeKey(t time.Time) string {
+	return t.UTC().Format("20060102150405.000000000")
+}
+
+// The place of a page in the page list, in a given order, which is also the cursor of the pages after or
+// before it: the page id and its sort key, separated by a dot, or only the id for unreadable pages
+type pageListPosition struct {
+	id			PageId
+	key			string
+	unreadable	bool
+}
+
+func (order pageListOrder) position(page *PageModel) pageListPosition {
+	if page.Unreadable {
+		return pageListPosition{id: page.Id, unreadable: true}
+	}
+	return pageListPosition{id: page.Id, key: order.key(page)}
+}
+
+func (order pageListOrder) before(p1, p2 pageListPosition) bool {
+	switch {
+	case p1.unreadable != p2.unreadable:
+		return p2.unreadable
+	case p1.key != p2.key:
+		return (p1.key < p2.key) != order.descending
+	}
+	return p1.id < p2.id
+}
+
+func (order pageListOrder) less(p1, p2 *PageModel) bool {
+	return order.before(order.position(p1), order.position(p2))
+}
+
+func (position pageListPosition) String() string {
+	if position.unreadable {
+		return string(position.id)
+	}
+	return string(position.id) + "." + position.key
+}
+
+func parsePageListPosition(cursor string) (pageListPosition, error) {
+	id, key, found := strings.Cut(cursor, ".")
+	if !ValidPageId(PageId(id)) {
+		return pageListPosition{}, fmt.Errorf("invalid page list cursor %q", cursor)
+	}
+	return pageListPosition{id: PageId(id), key: key, unreadable: !found}, nil
+}
+
+// Selects up to size pages of a list sorted in the order, after the cursor after or, if given, before the
+// cursor before, returning them with the cursors of the previous and next pages, "" if there are none.
+// The first pages are selected if there are too few before the cursor, and the last ones if there are none
+// after it. Cursors keep their place when the pages around them are created, deleted or moved by an update.
+func (order pageListOrder) selectRange(pages []*PageModel, after, before string, size int) (
+		selected []*PageModel, previous, next string, err error) {
+	var start, end int
+	if before != "" {
+		cursor, err := parsePageListPosition(before)
+		if err != nil {
+			return nil, "", "", err
+		}
+		end = sort.Search(len(pages), func(k int) bool { return !order.before(order.position(pages[k]), cursor) })
+		start = max(end-size, 0)
+		if start == 0 {  // too few pages before the cursor: the first ones
+			end = min(size, len(pages))
+		}
+	} else {
+		if after != "" {
+			cursor, err := parsePageListPosition(after)
+			if err != nil {
+				return nil, "", "", err
+			}
+			start = sort.Search(len(pages), func(k int) bool { return order.before(cursor, order.position(pages[k])) })
+		}
+		if start == len(pages) {  // no pages after the cursor: the last ones
+			start = max(len(pages)-size, 0)
+		}
+		end = min(start+size, len(pages))
+	}
+
+	if start > 0 {
+		previous = order.position(pages[start]).String()
+	}
+	if end < len(pages) {
+		next = order.position(pages[end-1]).String()
+	}
+	return pages[start:end], previous, next, nil
+}
 
+// The order of the store, in which the list is paginated without sorting all the pages
+const ID_PAGE_LIST_ORDER = "id"
+
+const DEFAULT_PAGE_LIST_ORDER = ID_PAGE_LIST_ORDER
+
+// Views of the page list, by value of the view parameter
+var pageListViews = map[string]bool{
+	"list": true,
+	"tree": true,
+}
+
+const DEFAULT_PAGE_LIST_VIEW = "list"
+
+
+type TagModel struct {
+	Name		string
+	Pages		int
+	PageList	[]*PageModel  // only when listing the pages with the tag
+}
+
+type TagListModel []*TagModel
+
+func (list TagListModel) Len() int           { return len(list) }
+func (list TagListModel) Less(i, j int) bool { return list[i].Name < list[j].Name }
+func (list TagListModel) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+
+type HistoryModel struct {
+	Id			PageId
+	Title		string
+	Revisions	[]*RevisionModel  // most recent first
+}
+
+type RevisionModel struct {
+	Number		int
+	Previous	int  // 0 for the first revision
+	Timestamp	time.Time
+	Title		string
+	Author		string
+	Current		bool
+}
+
+type DiffModel struct {
+	Id		PageId
+	Title	string
+	From	*Revision
+	To		*Revision
+	Lines	[]DiffLine
+}
+
+type TrashModel struct {
+	Pages		[]*TrashedPageModel  // most recently deleted first
+	Retention	time.Duration  // 0 if pages are only purged by hand
+}
+
+type TrashedPageModel struct {
+	Id		PageId
+	Title	string
+	Deleted	time.Time
+	Purged	time.Time  // when the page will be purged, if there is a retention period
+}
+
+type UnreadableModel struct {
+	Pages	[]*UnreadablePageModel  // sorted by id
+}
+
+type UnreadablePageModel struct {
+	Id		PageId
+	Reason	string  // a generic one; the error is logged
+}
@@ -0,0 +1,75 @@
+package wiki
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPageListRangesInOrder(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var pages []*PageModel
+	for k, title := range []string{"e", "a", "d", "b", "c", "a"} {
+		pages = append(pages, &PageModel{Id: PageId("id" + string(rune('0'+k))), Title: title,
+			Modified: start.Add(time.Duration(k) * time.Hour)})
+	}
+	pages = append(pages, &PageModel{Id: "id9", Unreadable: true})
+
+	for name, expected := range map[string][]PageId{
+		"title": {"id1", "id5", "id3", "id4", "id2", "id0", "id9"},
+		"modified": {"id5", "id4", "id3", "id2", "id1", "id0", "id9"},
+	} {
+		order := pageListOrders[name]
+		sort.Slice(pages, func(i, j int) bool { return order.less(pages[i], pages[j]) })
+
+		var found []PageId
+		var previous []string
+		after := ""
+		for {
+			selected, prev, next, err := order.selectRange(pages, after, "", 3)
+			if err != nil {
+				t.Fatalf("selectRange(%q): %s", after, err)
+			}
+			for _, page := range selected {
+				found = append(found, page.Id)
+			}
+			previous = append(previous, prev)
+			if next == "" {
+				break
+			}
+			after = next
+		}
+		if len(found) != len(expected) {
+			t.Fatalf("selectRange by %s: expected %v, found %v", name, expected, found)
+		}
+		for k := range expected {
+			if found[k] != expected[k] {
+				t.Fatalf("selectRange by %s: expected %v, found %v", name, expected, found)
+			}
+		}
+
+		selected, prev, next, err := order.selectRange(pages, "", previous[len(previous)-1], 3)
+		if err != nil || len(selected) != 3 || selected[0].Id != expected[3] || prev == "" || next == "" {
+			t.Errorf("selectRange by %s before %q: expected pages from %s, found %+v (%v)", name,
+				previous[len(previous)-1], expected[3], selected, err)
+		}
+	}
+}
+
+func TestPageListCursorAfterUpdate(t *testing.T) {
+	pages := []*PageModel{{Id: "a1", Title: "A"}, {Id: "b1", Title: "B"}, {Id: "c1", Title: "C"}, {Id: "d1", Title: "D"}}
+	order := pageListOrders["title"]
+	_, _, next, _ := order.selectRange(pages, "", "", 2)
+
+	pages[1].Title = "E"  // the last page shown moves to the end
+	sort.Slice(pages, func(i, j int) bool { return order.less(pages[i], pages[j]) })
+	selected, _, _, err := order.selectRange(pages, next, "", 2)
+	if err != nil || len(selected) != 2 || selected[0].Id != "c1" || selected[1].Id != "d1" {
+		t.Errorf("selectRange after %q: expected c1 and d1, found %+v (%v)", next, selected, err)
+	}
+
+	_, _, _, err = order.selectRange(pages, "not a cursor", "", 2)
+	if err == nil {
+		t.Errorf("selectRange: expected an error for an invalid cursor")
+	}
+}
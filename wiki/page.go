@@ -1,9 +1,55 @@
 package wiki
 
+import (
+	"time"
+)
+
 type PageId string
 
+// Created and Modified are set by the page store on every write; Author is the last editor, as given by the caller.
+// Pages written before they had metadata may lack some of it. Tags are normalized by the store.
+// Pages form a tree through their parents, which the store keeps free of cycles.
 type Page struct {
+	Id			PageId
+	Title		string
+	Body		string
+	Version		int  // incremented on every update, to detect conflicting writes
+	Created		time.Time
+	Modified	time.Time
+	Author		string
+	Tags		[]string  // sorted
+	Parent		PageId  // "" for top-level pages
+}
+
+// A past (or current) state of a page, as kept by the page store on every write
+type Revision struct {
+	Number		int
+	Timestamp	time.Time
+	Title		string
+	Body		string
+	Author		string
+}
+
+// The metadata of a page, as listed without reading its body
+type PageSummary struct {
+	Id			PageId
+	Title		string
+	Version		int
+	Created		time.Time
+	Modified	time.Time
+	Author		string
+	Parent		PageId
+}
+
+func summarizePage(page *Page) *PageSummary {
+	return &PageSummary{Id: page.Id, Title: page.Title, Version: page.Version,
+		Created: page.Created, Modified: page.Modified, Author: page.Author, Parent: page.Parent}
+}
+
+// A deleted page, kept in the trash until it is restored or purged
+type TrashedPage struct {
 	Id		PageId
 	Title	string
-	Body	string
+	Version	int  // of the page when it was deleted
+	Deleted	time.Time
 }
@@ -7,6 +7,13 @@ import (
 	"errors"
 	"sort"
 	"log"
+	"strconv"
+	"time"
+	"fmt"
+	"net"
+	"mime"
+	"strings"
+	"sync"
 )
 
 const (
@@ -16,24 +23,81 @@ const (
 	EDIT_ENTRYPOINT_PATH = "/edit/"
 	SAVE_ENTRYPOINT_PATH = "/save/"
 	DELETE_ENTRYPOINT_PATH = "/delete/"
+	HISTORY_ENTRYPOINT_PATH = "/history/"
+	DIFF_ENTRYPOINT_PATH = "/diff/"
+	REVERT_ENTRYPOINT_PATH = "/revert/"
+	TRASH_ENTRYPOINT_PATH = "/trash"
+	RESTORE_ENTRYPOINT_PATH = "/restore/"
+	PURGE_ENTRYPOINT_PATH = "/purge/"
+	TAGS_ENTRYPOINT_PATH = "/tags"
+	TAG_ENTRYPOINT_PATH = "/tag/"
+	UNREADABLE_ENTRYPOINT_PATH = "/unreadable"
+	ATTACHMENT_ENTRYPOINT_PATH = "/attachment/"
+	UPLOAD_ENTRYPOINT_PATH = "/upload/"
+	DELETE_ATTACHMENT_ENTRYPOINT_PATH = "/delete-attachment/"
+	MAX_UPLOAD_SIZE = 32 << 20  // in bytes, for all the files of a request
+	MAX_UPLOAD_MEMORY = 1 << 20  // larger uploads are buffered in temporary files
+	PAGE_LIST_SIZE = 50  // pages in each page of the list view
 	HTML_TEMPLATE_FILES  = "/html/*.tmpl"
+	TRASH_PURGE_INTERVAL = time.Hour
 )
 
 var (
-	pageRequestPattern = regexp.MustCompile(`^/(view|edit|delete)/([a-zA-Z0-9]+)$`)
+	pageRequestPattern = regexp.MustCompile(`^/(view|edit|delete|history|diff|restore|purge|upload)/([a-zA-Z0-9]+)$`)
+	revisionRequestPattern = regexp.MustCompile(`^/(revert)/([a-zA-Z0-9]+)/([0-9]+)$`)
+	attachmentRequestPattern = regexp.MustCompile(`^/(attachment|delete-attachment)/([a-zA-Z0-9]+)/([^/]+)$`)
+	tagRequestPattern = regexp.MustCompile(`^/tag/([\pL\pN_.-]+)$`)
 )
 
+// The templates are shared with the wikix server, which lacks most features of this one and says so
+// through the basic function
+var templateFuncs = template.FuncMap{"basic": func() bool { return false }}
+
 type Server struct {
 	pageStore PageStore
 	syntaxHandler SyntaxHandler
 	htmlTemplates *template.Template
+	trashRetention time.Duration  // 0 to keep deleted pages until they are purged by hand
+	attachments AttachmentStore  // nil if pages cannot have attachments
+	userHeader string  // set by a trusted proxy to the authenticated user, "" if there is none
+	loggedUnreadable map[PageId]string  // errors of the unreadable pages already logged
+	loggedUnreadableMutex sync.Mutex
+}
+
+// Content types of the attachments shown in the browser; others are downloaded, since they could
+// run scripts in the wiki origin (HTML or SVG files, for instance)
+var inlineContentTypes = map[string]bool{
+	"image/png": true,
+	"image/jpeg": true,
+	"image/gif": true,
+	"image/webp": true,
+	"application/pdf": true,
+	"text/plain; charset=utf-8": true,
 }
 
 func NewServer(store PageStore, syntax SyntaxHandler, assetsDir string) *Server {
 	return &Server{
 		pageStore: store,
 		syntaxHandler: syntax,
-		htmlTemplates: template.Must(template.ParseGlob(assetsDir + HTML_TEMPLATE_FILES))}
+		htmlTemplates: template.Must(template.New("").Funcs(templateFuncs).ParseGlob(assetsDir + HTML_TEMPLATE_FILES)),
+		loggedUnreadable: make(map[PageId]string)}
+}
+
+// Sets how long deleted pages are kept in the trash before being purged, from the time the server is started
+func (server *Server) KeepTrashFor(retention time.Duration) {
+	server.trashRetention = retention
+}
+
+// Lets pages have attachments, kept in the given store
+func (server *Server) StoreAttachmentsIn(attachments AttachmentStore) {
+	server.attachments = attachments
+}
+
+// Takes the names of the editors from a request header, which must be set by an authenticating proxy
+// in front of the server, and must not be passed through from clients. Otherwise editors are
+// identified by their addresses.
+func (server *Server) TrustUserHeader(name string) {
+	server.userHeader = name
 }
 
 func (server *Server) Start(addr string) error {
@@ -43,57 +107,233 @@ func (server *Server) Start(addr string) error {
 	http.HandleFunc(EDIT_ENTRYPOINT_PATH, server.handleEdit)
 	http.HandleFunc(SAVE_ENTRYPOINT_PATH, server.handleSave)
 	http.HandleFunc(DELETE_ENTRYPOINT_PATH, server.handleDelete)
+	http.HandleFunc(HISTORY_ENTRYPOINT_PATH, server.handleHistory)
+	http.HandleFunc(DIFF_ENTRYPOINT_PATH, server.handleDiff)
+	http.HandleFunc(REVERT_ENTRYPOINT_PATH, server.handleRevert)
+	http.HandleFunc(TRASH_ENTRYPOINT_PATH, server.handleTrash)
+	http.HandleFunc(RESTORE_ENTRYPOINT_PATH, server.handleRestore)
+	http.HandleFunc(PURGE_ENTRYPOINT_PATH, server.handlePurge)
+	http.HandleFunc(TAGS_ENTRYPOINT_PATH, server.handleTags)
+	http.HandleFunc(TAG_ENTRYPOINT_PATH, server.handleTag)
+	http.HandleFunc(UNREADABLE_ENTRYPOINT_PATH, server.handleUnreadable)
+	if server.attachments != nil {
+		http.HandleFunc(ATTACHMENT_ENTRYPOINT_PATH, server.handleAttachment)
+		http.HandleFunc(UPLOAD_ENTRYPOINT_PATH, server.handleUpload)
+		http.HandleFunc(DELETE_ATTACHMENT_ENTRYPOINT_PATH, server.handleDeleteAttachment)
+	}
+	if server.trashRetention > 0 {
+		go server.purgeOldTrash()
+	}
 	return http.ListenAndServe(addr, nil)
 }
 
+// Periodically purges the pages that have been in the trash for longer than the retention period
+func (server *Server) purgeOldTrash() {
+	for {
+		var purged int
+		var err error
+		if server.attachments != nil {
+			purged, err = PurgeTrashWithAttachments(server.pageStore, server.attachments, time.Now().Add(-server.trashRetention))
+		} else {
+			purged, err = server.pageStore.PurgeTrash(time.Now().Add(-server.trashRetention))
+		}
+		if err != nil {
+			log.Println(err)
+		} else if purged > 0 {
+			log.Printf("Purged %d pages from the trash", purged)
+		}
+		time.Sleep(TRASH_PURGE_INTERVAL)
+	}
+}
+
 func (server *Server) handleList(res http.ResponseWriter, req *http.Request) {
-	ids, err := server.pageStore.ListAll()
+	order := req.URL.Query().Get("sort")
+	if order == "" {
+		order = DEFAULT_PAGE_LIST_ORDER
+	}
+	sortOrder, found := pageListOrders[order]
+	if !found {
+		server.handleError(res, InvalidRequestError{fmt.Errorf("unknown sort order %q", order)})
+		return
+	}
+	view := req.URL.Query().Get("view")
+	if view == "" {
+		view = DEFAULT_PAGE_LIST_VIEW
+	}
+	if !pageListViews[view] {
+		server.handleError(res, InvalidRequestError{fmt.Errorf("unknown view %q", view)})
+		return
+	}
+
+	// The tree view is the exception that reads all the pages, since trees may span them all. The list view
+	// reads only the pages it shows in id order, and sorts the summaries indexed by the store in other orders.
+	after, before := req.URL.Query().Get("after"), req.URL.Query().Get("before")
+	var summaries []*PageSummary
+	var unreadable map[PageId]error
+	var previous, next string
+	var err error
+	switch {
+	case view == "tree":
+		summaries, unreadable, err = server.addUnreadable(server.pageStore.ListSummaries(ListOptions{}))
+	case order == ID_PAGE_LIST_ORDER:
+		summaries, unreadable, previous, next, err = server.listPageRange(PageId(after), PageId(before))
+	default:
+		summaries, unreadable, err = server.addUnreadable(server.pageStore.ListIndexedSummaries())
+	}
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 
-	pageList := make(PageListModel, len(ids))
-	for k, id := range ids {
-		page, err := server.pageStore.Read(id)
+	pageList := &PageListModel{Sort: order, View: view, Unreadable: len(unreadable), Previous: previous, Next: next}
+	pageList.Pages = make([]*PageModel, len(summaries))
+	parents := make(map[PageId]PageId, len(summaries))
+	for k, summary := range summaries {
+		pageList.Pages[k] = &PageModel{Id: summary.Id, Title: summary.Title,
+			Created: summary.Created, Modified: summary.Modified, Author: summary.Author,
+			Unreadable: unreadable[summary.Id] != nil}
+		parents[summary.Id] = summary.Parent
+	}
+	if order != ID_PAGE_LIST_ORDER {
+		sort.Slice(pageList.Pages, func(i, j int) bool { return sortOrder.less(pageList.Pages[i], pageList.Pages[j]) })
+	}
+	if view == "tree" {
+		pageList.Tree = buildPageTree(pageList.Pages, parents)
+	} else if order != ID_PAGE_LIST_ORDER {
+		pageList.Pages, pageList.Previous, pageList.Next, err = sortOrder.selectRange(pageList.Pages, after, before, PAGE_LIST_SIZE)
 		if err != nil {
-			handleError(res, err)
+			server.handleError(res, InvalidRequestError{err})
 			return
 		}
-		pageList[k] = &PageModel{Id: id, Title: page.Title}
 	}
-	sort.Sort(pageList)
 
 	err = server.htmlTemplates.ExecuteTemplate(res, "list", pageList)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 }
 
+// Lists PAGE_LIST_SIZE pages in id order after or before a cursor, with the cursors of the previous and
+// next pages of the list, reading only the pages listed
+func (server *Server) listPageRange(after, before PageId) (summaries []*PageSummary, unreadable map[PageId]error,
+		previous, next string, err error) {
+	options := ListOptions{After: after, Before: before, Limit: PAGE_LIST_SIZE + 1}  // one more, to know if there are more
+	summaries, unreadable, err = server.addUnreadable(server.pageStore.ListSummaries(options))
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+
+	if before != "" {
+		if len(summaries) > PAGE_LIST_SIZE {
+			summaries = summaries[1:]
+			previous = string(summaries[0].Id)
+		}
+		next = string(before)
+		if len(summaries) > 0 {
+			next = string(summaries[len(summaries)-1].Id)
+		}
+	} else {
+		if len(summaries) > PAGE_LIST_SIZE {
+			summaries = summaries[:PAGE_LIST_SIZE]
+			next = string(summaries[len(summaries)-1].Id)
+		}
+		if after != "" && len(summaries) > 0 {
+			previous = string(summaries[0].Id)
+		}
+	}
+	return summaries, unreadable, previous, next, nil
+}
+
+// Adds to listed summaries one holding only the id of each page that could not be read, so that the list
+// is not broken by a corrupted page. Unreadable pages are logged once.
+func (server *Server) addUnreadable(summaries []*PageSummary, err error) ([]*PageSummary, map[PageId]error, error) {
+	unreadableErr, ok := err.(UnreadablePagesError)
+	if !ok {
+		return summaries, nil, err
+	}
+
+	server.logUnreadable(unreadableErr.Errors)
+	for id := range unreadableErr.Errors {
+		summaries = append(summaries, &PageSummary{Id: id})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Id < summaries[j].Id })
+	return summaries, unreadableErr.Errors, nil
+}
+
+func (server *Server) logUnreadable(errs map[PageId]error) {
+	server.loggedUnreadableMutex.Lock()
+	defer server.loggedUnreadableMutex.Unlock()
+
+	for id, err := range errs {
+		if server.loggedUnreadable[id] != err.Error() {
+			log.Println(err)
+			server.loggedUnreadable[id] = err.Error()
+		}
+	}
+}
+
+// Arranges the pages in trees, keeping their order among siblings
+func buildPageTree(pages []*PageModel, parents map[PageId]PageId) []*PageTreeModel {
+	nodes := make(map[PageId]*PageTreeModel, len(pages))
+	for _, page := range pages {
+		nodes[page.Id] = &PageTreeModel{Page: page}
+	}
+
+	var roots []*PageTreeModel
+	for _, page := range pages {
+		node := nodes[page.Id]
+		if parent, found := nodes[parents[page.Id]]; found {
+			parent.Children = append(parent.Children, node)
+		} else {  // top-level page, or its parent was deleted while listing
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
 func (server *Server) handleView(res http.ResponseWriter, req *http.Request) {
 	id, err := getRequestedPageId(req)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 
 	page, err := server.pageStore.Read(id)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 
-	bodyAsHtml := server.syntaxHandler.BodyToHtml(page.Body)
-	pageModel := &PageModel{Id: id, Title: page.Title, BodyAsHtml: bodyAsHtml}
+	pageModel, err := server.viewModel(page)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
 
 	err = server.htmlTemplates.ExecuteTemplate(res, "view", pageModel)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 }
 
+// Returns the model of the view template for a page, with its attachments and family
+func (server *Server) viewModel(page *Page) (*PageModel, error) {
+	bodyAsHtml := server.syntaxHandler.PageToHtml(page)
+	pageModel := &PageModel{Id: page.Id, Title: page.Title, BodyAsHtml: bodyAsHtml,
+		Created: page.Created, Modified: page.Modified, Author: page.Author, Tags: page.Tags}
+	err := server.addAttachments(pageModel)
+	if err != nil {
+		return nil, err
+	}
+	err = server.addFamily(pageModel)
+	if err != nil {
+		return nil, err
+	}
+	return pageModel, nil
+}
+
 func (server *Server) handleCreate(res http.ResponseWriter, req *http.Request) {
 	title := req.URL.Query().Get("title")
 	if title == "" {
@@ -101,10 +341,18 @@ func (server *Server) handleCreate(res http.ResponseWriter, req *http.Request) {
 	}
 
 	pageModel := &PageModel{Title: title}
+	if parent := req.URL.Query().Get("parent"); parent != "" {  // when adding a child page
+		page, err := server.pageStore.Read(PageId(parent))
+		if err != nil {
+			server.handleError(res, err)
+			return
+		}
+		pageModel.ParentToEdit = page.Title
+	}
 
 	err := server.htmlTemplates.ExecuteTemplate(res, "create", pageModel)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 }
@@ -112,22 +360,32 @@ func (server *Server) handleCreate(res http.ResponseWriter, req *http.Request) {
 func (server *Server) handleEdit(res http.ResponseWriter, req *http.Request) {
 	id, err := getRequestedPageId(req)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 
 	page, err := server.pageStore.Read(id)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 
 	bodyToEdit := server.syntaxHandler.BodyToEdit(page.Body)
-	pageModel := &PageModel{Id: id, Title: page.Title, BodyToEdit: bodyToEdit}
+	pageModel := &PageModel{Id: id, Title: page.Title, BodyToEdit: bodyToEdit, TagsToEdit: FormatTags(page.Tags), Version: page.Version}
+	pageModel.ParentToEdit, err = server.findParentTitle(page)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+	err = server.addAttachments(pageModel)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
 
 	err = server.htmlTemplates.ExecuteTemplate(res, "edit", pageModel)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 }
@@ -135,7 +393,7 @@ func (server *Server) handleEdit(res http.ResponseWriter, req *http.Request) {
 func (server *Server) handleSave(res http.ResponseWriter, req *http.Request) {
 	err := req.ParseForm()
 	if err != nil {
-		handleError(res, InvalidRequestError{err})
+		server.handleError(res, InvalidRequestError{err})
 		return
 	}
 
@@ -143,7 +401,22 @@ func (server *Server) handleSave(res http.ResponseWriter, req *http.Request) {
 	title := req.Form.Get("title")
 	bodyFromEdit := req.Form.Get("body")
 	body := server.syntaxHandler.EditToBody(bodyFromEdit)
-	page := &Page{Id: id, Title: title, Body: body}
+	page := &Page{Id: id, Title: title, Body: body, Author: server.getEditor(req), Tags: ParseTags(req.Form.Get("tags"))}
+
+	parentToEdit := req.Form.Get("parent")
+	page.Parent, err = server.findParent(parentToEdit)
+	if err != nil {
+		server.renderForm(res, page, bodyFromEdit, parentToEdit, err)
+		return
+	}
+
+	if id != "" {
+		page.Version, err = strconv.Atoi(req.Form.Get("version"))  // version of the page when edition started
+		if err != nil {
+			server.handleError(res, InvalidRequestError{err})
+			return
+		}
+	}
 
 	if id == "" {
 		id, err = server.pageStore.Create(page)
@@ -151,30 +424,575 @@ func (server *Server) handleSave(res http.ResponseWriter, req *http.Request) {
 		err = server.pageStore.Update(page)
 	}
 
+	switch err.(type) {
+	case DuplicateTitleError, InvalidParentError:  // let the user choose another title or parent
+		server.renderForm(res, page, bodyFromEdit, parentToEdit, err)
+		return
+	}
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 
 	http.Redirect(res, req, VIEW_ENTRYPOINT_PATH+string(id), http.StatusFound)
 }
 
+// Renders again the create or edit form of a page that could not be saved, with the reason
+func (server *Server) renderForm(res http.ResponseWriter, page *Page, bodyToEdit, parentToEdit string, cause error) {
+	pageModel := &PageModel{Id: page.Id, Title: page.Title, BodyToEdit: bodyToEdit, TagsToEdit: FormatTags(page.Tags),
+		ParentToEdit: parentToEdit, Version: page.Version, Error: cause.Error()}
+
+	templateName := "edit"
+	if page.Id == "" {
+		templateName = "create"
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusConflict)
+	err := server.htmlTemplates.ExecuteTemplate(res, templateName, pageModel)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Returns the id of the page with the given title, to be the parent of a saved page
+func (server *Server) findParent(title string) (PageId, error) {
+	if strings.TrimSpace(title) == "" {  // top-level page
+		return "", nil
+	}
+	id, err := server.pageStore.FindByTitle(title)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", fmt.Errorf("there is no page titled %q to be its parent", title)
+	}
+	return id, nil
+}
+
+// Returns the title of the parent of a page, or "" for top-level pages
+func (server *Server) findParentTitle(page *Page) (string, error) {
+	if page.Parent == "" {
+		return "", nil
+	}
+	parent, err := server.pageStore.Read(page.Parent)
+	if _, ok := err.(UnexistentPageError); ok {  // deleted meanwhile
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return parent.Title, nil
+}
+
+// Adds the ancestors and the children of the page, for navigation
+func (server *Server) addFamily(pageModel *PageModel) error {
+	ancestors, err := FindAncestors(server.pageStore, pageModel.Id)
+	if err != nil {
+		return err
+	}
+	for _, ancestor := range ancestors {
+		pageModel.Ancestors = append(pageModel.Ancestors, &PageModel{Id: ancestor.Id, Title: ancestor.Title})
+	}
+
+	pageModel.Children, err = server.readChildren(pageModel.Id)
+	return err
+}
+
+// Returns the children of a page, sorted by title
+func (server *Server) readChildren(id PageId) ([]*PageModel, error) {
+	ids, err := server.pageStore.FindChildren(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var children []*PageModel
+	for _, childId := range ids {
+		child, err := server.pageStore.Read(childId)
+		if _, ok := err.(UnexistentPageError); ok {  // deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, &PageModel{Id: childId, Title: child.Title})
+	}
+	sort.SliceStable(children, func(i, j int) bool { return children[i].Title < children[j].Title })
+	return children, nil
+}
+
+// Pages with children are only deleted when the request says what to do with them: move them
+// to the parent of the page (children=move) or delete them too (children=delete). Otherwise,
+// the user is asked to choose.
 func (server *Server) handleDelete(res http.ResponseWriter, req *http.Request) {
 	id, err := getRequestedPageId(req)
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
+		return
+	}
+
+	children := req.FormValue("children")
+	if children != "" && req.Method != http.MethodPost {  // unlike moving a single page to the trash, hard to undo
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "deleting a page with child pages requires a POST request", http.StatusMethodNotAllowed)
 		return
 	}
 
-	err = server.pageStore.Delete(id)
+	switch children {
+	case "":
+		err = server.pageStore.Delete(id)
+	case "move":
+		err = MoveChildren(server.pageStore, id, server.getEditor(req))
+		if err == nil {
+			err = server.pageStore.Delete(id)
+		}
+	case "delete":
+		_, err = DeleteSubtree(server.pageStore, id)
+	default:
+		err = InvalidRequestError{fmt.Errorf("unknown action for child pages %q", children)}
+	}
+
+	if _, ok := err.(HasChildrenError); ok {
+		server.confirmDelete(res, id)
+		return
+	}
 	if err != nil {
-		handleError(res, err)
+		server.handleError(res, err)
 		return
 	}
 
 	http.Redirect(res, req, LIST_ENTRYPOINT_PATH, http.StatusFound)
 }
 
+func (server *Server) confirmDelete(res http.ResponseWriter, id PageId) {
+	page, err := server.pageStore.Read(id)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	pageModel := &PageModel{Id: id, Title: page.Title}
+	pageModel.Children, err = server.readChildren(id)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	err = server.htmlTemplates.ExecuteTemplate(res, "delete", pageModel)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+}
+
+func (server *Server) handleTrash(res http.ResponseWriter, req *http.Request) {
+	trash, err := server.pageStore.ListTrash()
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	trashModel := &TrashModel{Retention: server.trashRetention}
+	for _, trashed := range trash {
+		trashedModel := &TrashedPageModel{Id: trashed.Id, Title: trashed.Title, Deleted: trashed.Deleted}
+		if server.trashRetention > 0 {
+			trashedModel.Purged = trashed.Deleted.Add(server.trashRetention)
+		}
+		trashModel.Pages = append(trashModel.Pages, trashedModel)
+	}
+
+	err = server.htmlTemplates.ExecuteTemplate(res, "trash", trashModel)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+}
+
+func (server *Server) handleRestore(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "restoring a page requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := getRequestedPageId(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	err = server.pageStore.Restore(id)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	http.Redirect(res, req, VIEW_ENTRYPOINT_PATH+string(id), http.StatusFound)
+}
+
+// Purging cannot be undone, so it is only done on POST requests, as sent by the form in the trash page
+func (server *Server) handlePurge(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "purging a page requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := getRequestedPageId(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	err = server.pageStore.Purge(id)
+	if err == nil && server.attachments != nil {
+		err = server.attachments.DeleteAll(id)
+	}
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	http.Redirect(res, req, TRASH_ENTRYPOINT_PATH, http.StatusFound)
+}
+
+func (server *Server) addAttachments(pageModel *PageModel) error {
+	if server.attachments == nil {
+		return nil
+	}
+
+	attachments, err := server.attachments.List(pageModel.Id)
+	if err != nil {
+		return err
+	}
+	pageModel.CanAttach = true
+	for _, attachment := range attachments {
+		pageModel.Attachments = append(pageModel.Attachments, &AttachmentModel{Name: attachment.Name, Size: attachment.Size,
+			Url: AttachmentUrl(pageModel.Id, attachment.Name), IsImage: attachment.IsImage()})
+	}
+	return nil
+}
+
+// Lists the pages whose files could not be read, with the kind of problem, so that they can be repaired.
+// The errors themselves are only logged, since they tell about the storage.
+func (server *Server) handleUnreadable(res http.ResponseWriter, req *http.Request) {
+	_, err := server.pageStore.ListSummaries(ListOptions{})
+	unreadableErr, _ := err.(UnreadablePagesError)
+	if err != nil && unreadableErr.Errors == nil {
+		server.handleError(res, err)
+		return
+	}
+	server.logUnreadable(unreadableErr.Errors)
+
+	unreadableModel := &UnreadableModel{}
+	for id, err := range unreadableErr.Errors {
+		unreadableModel.Pages = append(unreadableModel.Pages, &UnreadablePageModel{Id: id, Reason: unreadableReason(err)})
+	}
+	sort.Slice(unreadableModel.Pages, func(i, j int) bool { return unreadableModel.Pages[i].Id < unreadableModel.Pages[j].Id })
+
+	err = server.htmlTemplates.ExecuteTemplate(res, "unreadable", unreadableModel)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+}
+
+func unreadableReason(err error) string {
+	switch err.(type) {
+	case CorruptedFileError:
+		return "corrupted file"
+	case DecryptionError:
+		return "cannot decrypt"
+	default:
+		return "cannot read"
+	}
+}
+
+func (server *Server) handleAttachment(res http.ResponseWriter, req *http.Request) {
+	id, name, err := getRequestedAttachment(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	content, attachment, err := server.attachments.Open(id, name)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+	defer content.Close()
+
+	contentType := attachment.ContentType()
+	if contentType == "" {
+		contentType = "application/octet-stream"  // rather than letting ServeContent sniff it
+	}
+	disposition := "attachment"
+	if inlineContentTypes[contentType] {
+		disposition = "inline"
+	}
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(res, req, name, attachment.Modified, content)
+}
+
+func (server *Server) handleUpload(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "uploading attachments requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := getRequestedPageId(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+	_, err = server.pageStore.Read(id)  // check that the page exists
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(res, req.Body, MAX_UPLOAD_SIZE)
+	err = req.ParseMultipartForm(MAX_UPLOAD_MEMORY)
+	if err != nil {
+		server.handleError(res, InvalidRequestError{err})
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	for _, header := range req.MultipartForm.File["file"] {
+		file, err := header.Open()
+		if err != nil {
+			server.handleError(res, err)
+			return
+		}
+		_, err = server.attachments.Put(id, SanitizeAttachmentName(header.Filename), file)
+		file.Close()
+		if err != nil {
+			server.handleError(res, err)
+			return
+		}
+	}
+
+	http.Redirect(res, req, EDIT_ENTRYPOINT_PATH+string(id), http.StatusFound)
+}
+
+func (server *Server) handleDeleteAttachment(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "deleting an attachment requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, name, err := getRequestedAttachment(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	err = server.attachments.Delete(id, name)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	http.Redirect(res, req, EDIT_ENTRYPOINT_PATH+string(id), http.StatusFound)
+}
+
+func (server *Server) handleTags(res http.ResponseWriter, req *http.Request) {
+	counts, err := server.pageStore.ListTags()
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	var tagList TagListModel
+	for tag, pages := range counts {
+		tagList = append(tagList, &TagModel{Name: tag, Pages: pages})
+	}
+	sort.Sort(tagList)
+
+	err = server.htmlTemplates.ExecuteTemplate(res, "tags", tagList)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+}
+
+func (server *Server) handleTag(res http.ResponseWriter, req *http.Request) {
+	tag, err := getRequestedTag(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	ids, err := server.pageStore.FindByTag(tag)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	tagModel := &TagModel{Name: tag, Pages: len(ids)}
+	for _, id := range ids {
+		page, err := server.pageStore.Read(id)
+		if _, deleted := err.(UnexistentPageError); deleted {  // meanwhile
+			continue
+		}
+		if err != nil {
+			server.handleError(res, err)
+			return
+		}
+		tagModel.PageList = append(tagModel.PageList, &PageModel{Id: id, Title: page.Title, Modified: page.Modified, Author: page.Author})
+	}
+	sort.SliceStable(tagModel.PageList, func(i, j int) bool { return tagModel.PageList[i].Title < tagModel.PageList[j].Title })
+
+	err = server.htmlTemplates.ExecuteTemplate(res, "tag", tagModel)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+}
+
+func (server *Server) handleHistory(res http.ResponseWriter, req *http.Request) {
+	id, err := getRequestedPageId(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	page, err := server.pageStore.Read(id)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	revisions, err := server.pageStore.ListRevisions(id)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	historyModel := &HistoryModel{Id: id, Title: page.Title}
+	for k := len(revisions) - 1; k >= 0; k-- {
+		revision := revisions[k]
+		revisionModel := &RevisionModel{Number: revision.Number, Timestamp: revision.Timestamp,
+			Title: revision.Title, Author: revision.Author, Current: k == len(revisions)-1}
+		if k > 0 {
+			revisionModel.Previous = revisions[k-1].Number
+		}
+		historyModel.Revisions = append(historyModel.Revisions, revisionModel)
+	}
+
+	err = server.htmlTemplates.ExecuteTemplate(res, "history", historyModel)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+}
+
+func (server *Server) handleDiff(res http.ResponseWriter, req *http.Request) {
+	id, err := getRequestedPageId(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	revisions, err := server.pageStore.ListRevisions(id)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	// by default, compare the current revision with the previous one
+	to := revisions[len(revisions)-1].Number
+	from := to - 1
+	if param := req.URL.Query().Get("to"); param != "" {
+		to, err = strconv.Atoi(param)
+		if err != nil {
+			server.handleError(res, InvalidRequestError{err})
+			return
+		}
+	}
+	if param := req.URL.Query().Get("from"); param != "" {
+		from, err = strconv.Atoi(param)
+		if err != nil {
+			server.handleError(res, InvalidRequestError{err})
+			return
+		}
+	}
+
+	fromRevision := &Revision{}  // comparing with revision 0 shows the whole content as inserted
+	if from != 0 {
+		fromRevision, err = findRevision(id, revisions, from)
+		if err != nil {
+			server.handleError(res, err)
+			return
+		}
+	}
+	toRevision, err := findRevision(id, revisions, to)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	diffModel := &DiffModel{Id: id, Title: revisions[len(revisions)-1].Title, From: fromRevision, To: toRevision,
+		Lines: diffLines(fromRevision.Body, toRevision.Body)}
+
+	err = server.htmlTemplates.ExecuteTemplate(res, "diff", diffModel)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+}
+
+func (server *Server) handleRevert(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "reverting a page requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, number, err := getRequestedRevision(req)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	revision, err := server.pageStore.ReadRevision(id, number)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	page, err := server.pageStore.Read(id)
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	page.Title = revision.Title
+	page.Body = revision.Body
+	page.Author = server.getEditor(req)
+	err = server.pageStore.Update(page)  // reverting creates a new revision, so that it can be undone
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	http.Redirect(res, req, VIEW_ENTRYPOINT_PATH+string(id), http.StatusFound)
+}
+
+func findRevision(id PageId, revisions []*Revision, number int) (*Revision, error) {
+	for _, revision := range revisions {
+		if revision.Number == number {
+			return revision, nil
+		}
+	}
+	return nil, UnexistentRevisionError{id, number}
+}
+
 func getRequestedPageId(req *http.Request) (PageId, error) {
 	submatches := pageRequestPattern.FindStringSubmatch(req.URL.Path)
 	if submatches == nil {
@@ -183,6 +1001,47 @@ func getRequestedPageId(req *http.Request) (PageId, error) {
 	return PageId(submatches[2]), nil
 }
 
+func getRequestedRevision(req *http.Request) (PageId, int, error) {
+	submatches := revisionRequestPattern.FindStringSubmatch(req.URL.Path)
+	if submatches == nil {
+		return "", 0, InvalidRequestError{errors.New("invalid page revision")}
+	}
+	number, err := strconv.Atoi(submatches[3])
+	if err != nil {
+		return "", 0, InvalidRequestError{err}
+	}
+	return PageId(submatches[2]), number, nil
+}
+
+// Identifies who is editing a page: the user name given by the trusted proxy, if any, or else the client
+// address. Credentials sent by the client are not checked by the server, so they are not trusted.
+func (server *Server) getEditor(req *http.Request) string {
+	if user := req.Header.Get(server.userHeader); server.userHeader != "" && user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func getRequestedAttachment(req *http.Request) (PageId, string, error) {
+	submatches := attachmentRequestPattern.FindStringSubmatch(req.URL.Path)
+	if submatches == nil {
+		return "", "", InvalidRequestError{errors.New("invalid attachment")}
+	}
+	return PageId(submatches[2]), submatches[3], nil
+}
+
+func getRequestedTag(req *http.Request) (string, error) {
+	submatches := tagRequestPattern.FindStringSubmatch(req.URL.Path)
+	if submatches == nil || NormalizeTag(submatches[1]) == "" {
+		return "", InvalidRequestError{errors.New("invalid tag")}
+	}
+	return NormalizeTag(submatches[1]), nil
+}
+
 type InvalidRequestError struct {
 	cause error
 }
@@ -195,12 +1054,45 @@ func (err InvalidRequestError) Error() string {
 	}
 }
 
-func handleError(res http.ResponseWriter, err error) {
-	switch err.(type) {
+// Shows both the current version of the page and the rejected changes, so that the user can merge them
+func (server *Server) handleConflict(res http.ResponseWriter, conflict ConflictError) {
+	current, rejected := conflict.Current, conflict.Rejected
+	conflictModel := &ConflictModel{
+		Current: &PageModel{Id: current.Id, Title: current.Title, Version: current.Version,
+			BodyToEdit: server.syntaxHandler.BodyToEdit(current.Body), TagsToEdit: FormatTags(current.Tags)},
+		Rejected: &PageModel{Id: rejected.Id, Title: rejected.Title, Version: rejected.Version,
+			BodyToEdit: server.syntaxHandler.BodyToEdit(rejected.Body), TagsToEdit: FormatTags(rejected.Tags)}}
+
+	var err error
+	conflictModel.Current.ParentToEdit, err = server.findParentTitle(current)
+	if err == nil {
+		conflictModel.Rejected.ParentToEdit, err = server.findParentTitle(rejected)
+	}
+	if err != nil {
+		server.handleError(res, err)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusConflict)
+	err = server.htmlTemplates.ExecuteTemplate(res, "conflict", conflictModel)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (server *Server) handleError(res http.ResponseWriter, err error) {
+	switch err := err.(type) {
 	case InvalidRequestError:
 		http.Error(res, err.Error(), http.StatusBadRequest)
-	case UnexistentPageError:
+	case InvalidAttachmentNameError, InvalidParentError:
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	case UnexistentPageError, UnexistentRevisionError, NotInTrashError, UnexistentAttachmentError:
 		http.Error(res, err.Error(), http.StatusNotFound)
+	case ConflictError:
+		server.handleConflict(res, err)
+	case DuplicateTitleError, HasChildrenError, ExistingPageError:
+		http.Error(res, err.Error(), http.StatusConflict)
 	default:
 		http.Error(res, "internal error", http.StatusInternalServerError) // do not return internal error details to client
 		log.Println(err)
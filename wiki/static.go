@@ -0,0 +1,128 @@
+package wiki
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// Static exports are plain HTML files, with relative links, that any file server can publish:
+//
+//	index.html
+//	{id}.html
+//	attachments/{id}/{name}
+const (
+	STATIC_INDEX_FILE = "index.html"
+	STATIC_PAGE_SUFFIX = ".html"
+	STATIC_ATTACHMENTS_DIR = "attachments"
+)
+
+// Links of the rendered templates and page bodies, rewritten to the files of a static export
+var staticLinkRewrites = []struct {
+	pattern		*regexp.Regexp
+	replacement	string
+}{
+	{regexp.MustCompile(`(href|src)="/view/([a-zA-Z0-9]+)"`), `$1="$2` + STATIC_PAGE_SUFFIX + `"`},
+	{regexp.MustCompile(`(href|src)="/attachment/([a-zA-Z0-9]+)/`), `$1="` + STATIC_ATTACHMENTS_DIR + `/$2/`},
+	{regexp.MustCompile(`href="/"`), `href="` + STATIC_INDEX_FILE + `"`},
+}
+
+// Renders all the pages into a directory as a read-only copy of the wiki, without the links to
+// edit it, and copies their attachments. The index is the tree view of the page list. Existing files
+// are overwritten. Pages in the trash are not exported.
+func (server *Server) ExportStatic(dir string) (*ArchiveReport, error) {
+	summaries, err := server.pageStore.ListSummaries(ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	pageList := &PageListModel{Sort: "title", View: "tree", Static: true}
+	parents := make(map[PageId]PageId, len(summaries))
+	for _, summary := range summaries {
+		pageList.Pages = append(pageList.Pages, &PageModel{Id: summary.Id, Title: summary.Title,
+			Created: summary.Created, Modified: summary.Modified, Author: summary.Author})
+		parents[summary.Id] = summary.Parent
+	}
+	order := pageListOrders[pageList.Sort]
+	sort.Slice(pageList.Pages, func(i, j int) bool { return order.less(pageList.Pages[i], pageList.Pages[j]) })
+	pageList.Tree = buildPageTree(pageList.Pages, parents)
+	err = server.writeStaticFile(filepath.Join(dir, STATIC_INDEX_FILE), "list", pageList)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ArchiveReport{}
+	for _, summary := range summaries {
+		page, err := server.pageStore.Read(summary.Id)
+		if _, ok := err.(UnexistentPageError); ok {  // deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		pageModel, err := server.viewModel(page)
+		if err != nil {
+			return report, err
+		}
+		pageModel.Static = true
+		err = server.writeStaticFile(filepath.Join(dir, string(page.Id)+STATIC_PAGE_SUFFIX), "view", pageModel)
+		if err != nil {
+			return report, err
+		}
+		report.Pages++
+
+		for _, attachment := range pageModel.Attachments {
+			err = server.copyStaticAttachment(dir, page.Id, attachment.Name)
+			if err != nil {
+				return report, err
+			}
+			report.Attachments++
+		}
+	}
+	return report, nil
+}
+
+func (server *Server) writeStaticFile(filename, templateName string, model interface{}) error {
+	var html bytes.Buffer
+	err := server.htmlTemplates.ExecuteTemplate(&html, templateName, model)
+	if err != nil {
+		return err
+	}
+
+	content := html.Bytes()
+	for _, rewrite := range staticLinkRewrites {
+		content = rewrite.pattern.ReplaceAll(content, []byte(rewrite.replacement))
+	}
+	return ioutil.WriteFile(filename, content, 0644)
+}
+
+func (server *Server) copyStaticAttachment(dir string, id PageId, name string) error {
+	content, _, err := server.attachments.Open(id, name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	attachmentDir := filepath.Join(dir, STATIC_ATTACHMENTS_DIR, string(id))
+	err = os.MkdirAll(attachmentDir, 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filepath.Join(attachmentDir, name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package wiki
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportStatic(t *testing.T) {
+	store, attachments := NewMemoryStore(), NewMemoryAttachmentStore()
+	root := mustCreateChild(t, store, "Root", "")
+	child := mustCreateChild(t, store, "Child", root)
+	page, _ := store.Read(root)
+	page.Body = "See [" + string(child) + "][] and ![logo](" + AttachmentUrl(root, "logo.png") + ")."
+	page.Tags = []string{"docs"}
+	err := store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	_, err = attachments.Put(root, "logo.png", strings.NewReader("PNG"))
+	if err != nil {
+		t.Fatalf("AttachmentStore.Put: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := NewServer(store, NewMarkdownSyntax(store), "../assets/wiki")
+	server.StoreAttachmentsIn(attachments)
+	report, err := server.ExportStatic(dir)
+	if err != nil || report.Pages != 2 || report.Attachments != 1 {
+		t.Fatalf("Server.ExportStatic: expected 2 pages and 1 attachment, found %+v (%v)", report, err)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, STATIC_INDEX_FILE))
+	if err != nil || !strings.Contains(string(index), `href="`+string(child)+`.html"`) || strings.Contains(string(index), "/create/") {
+		t.Errorf("Server.ExportStatic: unexpected index %s (%v)", index, err)
+	}
+	view, err := ioutil.ReadFile(filepath.Join(dir, string(root)+STATIC_PAGE_SUFFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`href="` + string(child) + `.html"`, `src="attachments/` + string(root) + `/logo.png"`, `href="index.html"`} {
+		if !strings.Contains(string(view), expected) {
+			t.Errorf("Server.ExportStatic: expected %s in %s", expected, view)
+		}
+	}
+	for _, unexpected := range []string{"/view/", "/edit/", "/delete/", "/create/", "/tag/"} {
+		if strings.Contains(string(view), unexpected) {
+			t.Errorf("Server.ExportStatic: unexpected %s in %s", unexpected, view)
+		}
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, STATIC_ATTACHMENTS_DIR, string(root), "logo.png"))
+	if err != nil || string(content) != "PNG" {
+		t.Errorf("Server.ExportStatic: expected the attachment to be copied, found %q (%v)", content, err)
+	}
+}
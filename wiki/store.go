@@ -9,38 +9,261 @@ import (
 	"strings"
 	"errors"
 	"fmt"
+	"time"
+	"sort"
+	"strconv"
+	"log"
+	"sync"
+	"regexp"
 )
 
+// Storage strategy for wiki pages. Implementations can be checked with the storetest package.
 type PageStore interface {
 	Create(*Page) (PageId, error)
 	Read(PageId) (*Page, error)
 	Update(*Page) error
-	Delete(PageId) error
-	ListAll() ([]PageId, error)
-	FindByTitle(string) (PageId, error)
+	Import(*Page, []*Revision) error  // creates or replaces a page with the given id, metadata and history, and any trashed page with that id
+	Delete(PageId) error  // moves the page to the trash; pages with children cannot be deleted
+	ListAll() ([]PageId, error)  // sorted by id
+	ListRange(ListOptions) ([]PageId, error)  // sorted by id
+	ListSummaries(ListOptions) ([]*PageSummary, error)  // sorted by id; see UnreadablePagesError
+	ListIndexedSummaries() ([]*PageSummary, error)  // of all the pages, sorted by id, from indexes rather than the pages themselves
+	FindByTitle(string) (PageId, error)  // "" if there is no such page
+	FindByTag(string) ([]PageId, error)  // sorted by id
+	FindChildren(PageId) ([]PageId, error)  // sorted by id
+	ListTags() (map[string]int, error)  // number of pages with each tag
+	ListRevisions(PageId) ([]*Revision, error)
+	ReadRevision(PageId, int) (*Revision, error)
+	ListTrash() ([]*TrashedPage, error)  // most recently deleted first
+	Restore(PageId) error  // moves the page back from the trash, with its history, to the top level if its parent is gone
+	Purge(PageId) error  // removes the page from the trash for good
+	PurgeTrash(before time.Time) (int, error)  // purges the pages deleted before the given time, returning how many
+	TitleMatching() TitleNormalization  // applied to titles when finding pages and keeping titles unique
 }
 
 const (
 	PAGE_ID_LEN = 6  // in bytes
 	FILE_SUFFIX = ".wiki"
+	HISTORY_SUFFIX = ".history"
+	TRASH_DIR = "trash"
+	DELETED_SUFFIX = ".deleted"  // of the files recording when trashed pages were deleted
+	LOCK_DIR = ".locks"
+	LOCK_FILE_SUFFIX = ".lock"
+	INDEX_LOCK_FILE = "index" + LOCK_FILE_SUFFIX
+	GENERATION_FILE = "generation"
+	SUMMARY_READERS = 8  // pages read in parallel when listing summaries
+	TEMP_FILE_MIN_AGE = time.Minute  // younger temporary files may belong to writes in progress
 )
 
+// Pages are stored in files named after their ids, placed as the layout says. The store directory can be
+// shared by several processes: writers lock pages with advisory file locks, and the title index of each
+// process is rebuilt whenever another process has changed titles, as recorded by a generation counter.
 type diskStore struct {
 	path string
+	layout DiskLayout
+	titles *titleIndex
+	tags *tagIndex
+	tree *treeIndex
+	ids *idIndex
+	summaries *summaryIndex
+	locks *pageLocks
+	indexMutex sync.Mutex  // guards generation and serializes index updates within the process
+	generation int64  // of the titles and tags in the indexes, -1 until they are built
 }
 
-func NewDiskStore(path string) PageStore {
-	return &diskStore{path: path}
+// Opens a store in an existing directory, first cleaning up after any write interrupted by a crash. The
+// layout of the directory is detected; directories without pages get the flat layout. Titles are matched
+// with the default normalization.
+func NewDiskStore(path string) (PageStore, error) {
+	return newDiskStore(path)
+}
+
+// Like NewDiskStore, giving the layout to the directory if it has no pages yet, and matching titles with
+// the given normalization
+func NewDiskStoreWith(path string, layout DiskLayout, normalization TitleNormalization) (PageStore, error) {
+	return openDiskStore(path, layout, normalization)
+}
+
+func newDiskStore(path string) (*diskStore, error) {
+	return openDiskStore(path, FLAT_LAYOUT, DEFAULT_TITLE_NORMALIZATION)
+}
+
+func openDiskStore(path string, layout DiskLayout, normalization TitleNormalization) (*diskStore, error) {
+	err := removeTempFiles(path, TEMP_FILE_MIN_AGE)
+	if err != nil {
+		return nil, err
+	}
+	layout, err = detectLayout(path, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	lockDir := path + "/" + LOCK_DIR
+	err = os.MkdirAll(lockDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &diskStore{path: path, layout: layout, titles: newTitleIndex(normalization), tags: newTagIndex(), tree: newTreeIndex(), ids: newIdIndex(), summaries: newSummaryIndex(), locks: newPageLocks(lockDir), generation: -1}
+	err = store.refreshIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Indexes built from the page files, to replace those of the store
+type pageIndexes struct {
+	titles *titleIndex
+	tags *tagIndex
+	tree *treeIndex
+	ids *idIndex
+	summaries *summaryIndex
+}
+
+func (store *diskStore) buildIndex() (*pageIndexes, error) {
+	ids, err := store.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := &pageIndexes{titles: newTitleIndex(store.titles.normalization), tags: newTagIndex(), tree: newTreeIndex(), ids: &idIndex{ids: ids}, summaries: newSummaryIndex()}
+	for _, id := range ids {
+		page, err := store.readPageFromFile(id)  // needs no lock, since files are replaced atomically
+		if err != nil {
+			if _, corrupted := err.(CorruptedFileError); corrupted {
+				log.Println(err)  // do not prevent the rest of the wiki from being used
+				indexes.summaries.putUnreadable(id, err)
+				continue
+			}
+			if _, unexistent := err.(UnexistentPageError); unexistent {  // deleted by another process meanwhile
+				continue
+			}
+			return nil, err
+		}
+		indexes.titles.put(id, page.Title)
+		indexes.tags.put(id, page.Tags)
+		indexes.tree.put(id, page.Parent)
+		indexes.summaries.put(page)
+	}
+	return indexes, nil
+}
+
+// Rebuilds the indexes if another process changed titles or tags since they were built
+func (store *diskStore) refreshIndex() error {
+	store.indexMutex.Lock()
+	defer store.indexMutex.Unlock()
+
+	generation, err := store.readGeneration()
+	if err != nil || generation == store.generation {
+		return err
+	}
+	return store.lockIndex(nil)
+}
+
+// Runs an update of the indexes (and the writes that go with it) holding the index lock of the
+// store directory, after bringing the indexes up to date. If the update succeeds, the generation is
+// increased so that other processes rebuild their indexes.
+func (store *diskStore) updateIndex(update func() error) error {
+	store.indexMutex.Lock()
+	defer store.indexMutex.Unlock()
+	return store.lockIndex(update)
+}
+
+// Brings the index up to date holding the index lock, and then runs the update, if any
+func (store *diskStore) lockIndex(update func() error) error {
+	file, err := lockFile(store.getLockDir()+"/"+INDEX_LOCK_FILE, true)
+	if err != nil {
+		return err
+	}
+	defer unlockFile(file)
+
+	generation, err := store.readGeneration()
+	if err != nil {
+		return err
+	}
+	if generation != store.generation {
+		indexes, err := store.buildIndex()
+		if err != nil {
+			return err
+		}
+		store.titles.replace(indexes.titles)
+		store.tags.replace(indexes.tags)
+		store.tree.replace(indexes.tree)
+		store.ids.replace(indexes.ids)
+		store.summaries.replace(indexes.summaries)
+		store.generation = generation
+	}
+	if update == nil {
+		return nil
+	}
+
+	err = update()
+	if err != nil {
+		return err
+	}
+	return store.writeGeneration(generation + 1)
+}
+
+func (store *diskStore) readGeneration() (int64, error) {
+	content, err := ioutil.ReadFile(store.getLockDir() + "/" + GENERATION_FILE)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	generation, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, CorruptedFileError{store.getLockDir() + "/" + GENERATION_FILE, err}
+	}
+	return generation, nil
+}
+
+func (store *diskStore) writeGeneration(generation int64) error {
+	err := writeFileAtomically(store.getLockDir()+"/"+GENERATION_FILE, []byte(strconv.FormatInt(generation, 10)), 0600)
+	if err != nil {
+		return err
+	}
+	store.generation = generation
+	return nil
 }
 
 func (store *diskStore) Create(page *Page) (PageId, error) {
-	id, err := newPageId()
+	id, err := NewPageId()
 	if err != nil {
 		return "", err
 	}
-	
-	page.Id = id
-	err = store.writePageToFile(page)
+
+	err = store.updateIndex(func() error {
+		if page.Parent != "" && !store.tree.exists(page.Parent) {
+			return InvalidParentError{"", page.Parent, "unexistent page"}
+		}
+		if owner, ok := store.titles.putUnique(id, page.Title); !ok {
+			return DuplicateTitleError{page.Title, owner}
+		}
+
+		now := time.Now()
+		page.Id = id
+		page.Version = 1
+		page.Created, page.Modified = now, now
+		page.Tags = NormalizeTags(page.Tags)
+		err := store.writePageToFile(page)
+		if err == nil {
+			err = store.writeRevision(page, 1, now)
+		}
+		if err != nil {
+			store.titles.remove(id)
+			return err
+		}
+		store.tags.put(id, page.Tags)
+		store.tree.put(id, page.Parent)
+		store.ids.put(id)
+		store.summaries.put(page)
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
@@ -48,69 +271,639 @@ func (store *diskStore) Create(page *Page) (PageId, error) {
 	return id, nil
 }
 
+// Needs no lock, since page files are replaced atomically
 func (store *diskStore) Read(id PageId) (*Page, error) {
 	return store.readPageFromFile(id)
 }
 
+// Locks a page whose file exists, failing otherwise without locking it, so that requests for unexistent
+// pages leave no lock files behind (they are never removed; see Delete). The page may be gone by the
+// time it is locked, so callers check again.
+func (store *diskStore) lockExisting(id PageId, filename string, exclusive bool) (func(), error) {
+	_, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return store.locks.acquire(id, exclusive)
+}
+
 func (store *diskStore) Update(page *Page) error {
-	_, err := store.readPageFromFile(page.Id)  // check that page exists
+	unlock, err := store.lockExisting(page.Id, store.getPageFilename(page.Id), true)
+	if os.IsNotExist(err) {
+		return UnexistentPageError{page.Id}
+	}
 	if err != nil {
 		return err
 	}
-	
-	return store.writePageToFile(page)
+	defer unlock()
+
+	current, err := store.readPageFromFile(page.Id)  // also checks that page exists
+	if err != nil {
+		return err
+	}
+	if page.Version != current.Version {
+		return ConflictError{Current: current, Rejected: page}
+	}
+
+	revisions, err := store.readRevisions(page.Id)
+	if err != nil {
+		return err
+	}
+
+	last := revisions[len(revisions)-1]
+	if _, err := os.Stat(store.getHistoryDir(page.Id)); os.IsNotExist(err) {  // page written before history was kept
+		err = store.writeRevision(&Page{Id: page.Id, Title: last.Title, Body: last.Body, Author: last.Author}, last.Number, last.Timestamp)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	page.Tags = NormalizeTags(page.Tags)
+	write := func() error {
+		page.Version = current.Version + 1
+		page.Created, page.Modified = current.Created, now
+		err := store.writePageToFile(page)
+		if err != nil {
+			page.Version = current.Version
+			return err
+		}
+		store.summaries.put(page)
+		return nil
+	}
+
+	sameTitle := store.titles.sameTitle(page.Title, current.Title)
+	if sameTitle && sameTags(page.Tags, current.Tags) && page.Parent == current.Parent {
+		err = write()
+	} else {
+		err = store.updateIndex(func() error {
+			if page.Parent != current.Parent {
+				err := store.tree.checkParent(page.Id, page.Parent)
+				if err != nil {
+					return err
+				}
+			}
+			if !sameTitle {  // pages sharing their title since before titles were unique can still be edited
+				if owner, ok := store.titles.putUnique(page.Id, page.Title); !ok {
+					return DuplicateTitleError{page.Title, owner}
+				}
+			}
+			err := write()
+			if err != nil {
+				store.titles.put(page.Id, current.Title)
+				return err
+			}
+			store.tags.put(page.Id, page.Tags)
+			store.tree.put(page.Id, page.Parent)
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return store.writeRevision(page, last.Number+1, now)
 }
 
+// The history is written before the page, so that an interrupted import leaves the previous page, if any,
+// or the imported one, perhaps with a partial history that importing it again replaces
+func (store *diskStore) Import(page *Page, revisions []*Revision) error {
+	if !ValidPageId(page.Id) {
+		return InvalidPageIdError{page.Id}
+	}
+
+	unlock, err := store.locks.lock(page.Id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := store.readPageFromFile(page.Id)
+	if _, unexistent := err.(UnexistentPageError); unexistent {
+		current, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	page.Tags = NormalizeTags(page.Tags)
+	return store.updateIndex(func() error {
+		err := store.tree.checkParent(page.Id, page.Parent)
+		if err != nil {
+			return err
+		}
+		if owner, ok := store.titles.putUnique(page.Id, page.Title); !ok {
+			return DuplicateTitleError{page.Title, owner}
+		}
+
+		err = store.writeHistory(page.Id, revisions)
+		if err == nil {
+			err = store.writePageToFile(page)
+		}
+		if err != nil {
+			if current != nil {
+				store.titles.put(page.Id, current.Title)
+			} else {
+				store.titles.remove(page.Id)
+			}
+			return err
+		}
+		store.tags.put(page.Id, page.Tags)
+		store.tree.put(page.Id, page.Parent)
+		store.ids.put(page.Id)
+		store.summaries.put(page)
+		return store.removeTrashed(page.Id)  // a page in the trash with the same id is replaced as well
+	})
+}
+
+// Deleted pages are moved, with their history, to the trash directory, where a file next to each
+// page records when it was deleted. Lock files of deleted pages are kept: removing a lock file that
+// another process may have just opened would let two processes lock the same page through different files.
 func (store *diskStore) Delete(id PageId) error {
-	filename := store.getPageFilename(id)
-	return os.Remove(filename)
+	unlock, err := store.lockExisting(id, store.getPageFilename(id), true)
+	if os.IsNotExist(err) {
+		return UnexistentPageError{id}
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return store.updateIndex(func() error {
+		_, err := os.Stat(store.getPageFilename(id))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return UnexistentPageError{id}
+			}
+			return err
+		}
+		if children := store.tree.find(id); len(children) > 0 {
+			return HasChildrenError{id, children}
+		}
+
+		err = os.MkdirAll(store.getTrashDir(), 0700)
+		if err != nil {
+			return err
+		}
+		deletedFilename := store.getTrashedFilename(id, DELETED_SUFFIX)
+		err = writeFileAtomically(deletedFilename, []byte(time.Now().Format(time.RFC3339Nano)), 0600)
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(store.getPageFilename(id), store.getTrashedFilename(id, FILE_SUFFIX))  // the page is deleted once its file is moved
+		if err != nil {
+			os.Remove(deletedFilename)
+			return err
+		}
+		store.titles.remove(id)
+		store.tags.remove(id)
+		store.tree.remove(id)
+		store.ids.remove(id)
+		store.summaries.remove(id)
+
+		err = os.Rename(store.getHistoryDir(id), store.getTrashedFilename(id, HISTORY_SUFFIX))
+		if err != nil && !os.IsNotExist(err) {  // pages written before history was kept have none
+			return err
+		}
+		return nil
+	})
+}
+
+// Removes the files of a page from the trash, if it is there
+func (store *diskStore) removeTrashed(id PageId) error {
+	for _, suffix := range []string{FILE_SUFFIX, HISTORY_SUFFIX, DELETED_SUFFIX} {  // the page file first, which puts the page in the trash
+		err := os.RemoveAll(store.getTrashedFilename(id, suffix))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (store *diskStore) ListAll() (result []PageId, err error) {
-	files, err := ioutil.ReadDir(store.path)
+func (store *diskStore) ListTrash() ([]*TrashedPage, error) {
+	files, err := ioutil.ReadDir(store.getTrashDir())
 	if err != nil {
-		return
+		if os.IsNotExist(err) {  // nothing was ever deleted
+			return nil, nil
+		}
+		return nil, err
 	}
 
+	var result []*TrashedPage
 	for _, file := range files {
-		if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), FILE_SUFFIX) {
-			id := strings.TrimSuffix(file.Name(), FILE_SUFFIX)
-			result = append(result, PageId(id))
+		if !file.Mode().IsRegular() || !strings.HasSuffix(file.Name(), FILE_SUFFIX) {
+			continue
+		}
+		id := PageId(strings.TrimSuffix(file.Name(), FILE_SUFFIX))
+		page, err := readPageFile(store.getTrashedFilename(id, FILE_SUFFIX), id)
+		if err != nil {
+			if os.IsNotExist(err) {  // purged meanwhile
+				continue
+			}
+			if _, corrupted := err.(CorruptedFileError); corrupted {
+				log.Println(err)
+				continue
+			}
+			return nil, err
+		}
+
+		deleted := file.ModTime()  // if the deletion time was lost in a crash
+		content, err := ioutil.ReadFile(store.getTrashedFilename(id, DELETED_SUFFIX))
+		if err == nil {
+			if parsed, err := time.Parse(time.RFC3339Nano, string(content)); err == nil {
+				deleted = parsed
+			}
+		}
+		result = append(result, &TrashedPage{Id: id, Title: page.Title, Version: page.Version, Deleted: deleted})
+	}
+
+	sort.Sort(trashedPagesByDeletion(result))
+	return result, nil
+}
+
+func (store *diskStore) Restore(id PageId) error {
+	unlock, err := store.lockExisting(id, store.getTrashedFilename(id, FILE_SUFFIX), true)
+	if os.IsNotExist(err) {
+		return NotInTrashError{id}
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return store.updateIndex(func() error {
+		page, err := readPageFile(store.getTrashedFilename(id, FILE_SUFFIX), id)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return NotInTrashError{id}
+			}
+			return err
+		}
+		if store.tree.exists(id) {  // every page is in the tree index
+			return ExistingPageError{id}
+		}
+
+		if owner, ok := store.titles.putUnique(id, page.Title); !ok {  // the title was taken while the page was in the trash
+			return DuplicateTitleError{page.Title, owner}
+		}
+
+		err = store.makePageDir(id)
+		if err == nil {
+			err = os.Rename(store.getTrashedFilename(id, HISTORY_SUFFIX), store.getHistoryDir(id))
+		}
+		if err == nil || os.IsNotExist(err) {
+			err = os.Rename(store.getTrashedFilename(id, FILE_SUFFIX), store.getPageFilename(id))
+		}
+		if err != nil {
+			store.titles.remove(id)
+			return err
+		}
+
+		if page.Parent != "" && !store.tree.exists(page.Parent) {  // deleted meanwhile
+			page.Parent = ""
+			err = store.writePageToFile(page)
+			if err != nil {
+				return err
+			}
+		}
+		store.tags.put(id, page.Tags)
+		store.tree.put(id, page.Parent)
+		store.ids.put(id)
+		store.summaries.put(page)
+		os.Remove(store.getTrashedFilename(id, DELETED_SUFFIX))
+		return nil
+	})
+}
+
+func (store *diskStore) Purge(id PageId) error {
+	unlock, err := store.lockExisting(id, store.getTrashedFilename(id, FILE_SUFFIX), true)
+	if os.IsNotExist(err) {
+		return NotInTrashError{id}
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = os.Remove(store.getTrashedFilename(id, FILE_SUFFIX))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NotInTrashError{id}
+		}
+		return err
+	}
+
+	err = os.RemoveAll(store.getTrashedFilename(id, HISTORY_SUFFIX))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(store.getPageFilename(id)); os.IsNotExist(err) {  // history left behind by a deletion interrupted by a crash
+		err = os.RemoveAll(store.getHistoryDir(id))
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Remove(store.getTrashedFilename(id, DELETED_SUFFIX))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *diskStore) PurgeTrash(before time.Time) (int, error) {
+	return purgeTrash(store, before)
+}
+
+func (store *diskStore) ListAll() ([]PageId, error) {
+	files, err := listPageFiles(store.path, store.layout)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []PageId
+	for _, file := range files {
+		if file.suffix == FILE_SUFFIX {
+			result = append(result, file.id)
 		}
 	}
-	return
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })  // shards are not in id order
+	return result, nil
+}
+
+// Page ids are listed from the index, which holds them all, since listing the directory on every call
+// would make iterating over the pages quadratic
+func (store *diskStore) ListRange(options ListOptions) ([]PageId, error) {
+	err := store.refreshIndex()
+	if err != nil {
+		return nil, err
+	}
+	return store.ids.selectRange(options), nil
+}
+
+// Summaries are kept in memory for all the pages. Those of pages that another process updated without
+// changing their title, tags or parent are refreshed the next time the indexes are rebuilt.
+func (store *diskStore) ListIndexedSummaries() ([]*PageSummary, error) {
+	err := store.refreshIndex()
+	if err != nil {
+		return nil, err
+	}
+	return store.summaries.list()
+}
+
+// Page files hold the body before the metadata, so the pages are read in full, in parallel
+func (store *diskStore) ListSummaries(options ListOptions) ([]*PageSummary, error) {
+	ids, err := store.ListRange(options)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]*PageSummary, len(ids))
+	errs := make([]error, len(ids))
+	indexes := make(chan int)
+	var readers sync.WaitGroup
+	for n := 0; n < SUMMARY_READERS; n++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for k := range indexes {
+				page, err := store.readPageFromFile(ids[k])  // needs no lock, since files are replaced atomically
+				if err != nil {
+					errs[k] = err
+					continue
+				}
+				summaries[k] = summarizePage(page)
+			}
+		}()
+	}
+	for k := range ids {
+		indexes <- k
+	}
+	close(indexes)
+	readers.Wait()
+
+	result := make([]*PageSummary, 0, len(ids))
+	unreadable := make(map[PageId]error)
+	for k, summary := range summaries {
+		if _, unexistent := errs[k].(UnexistentPageError); unexistent {  // deleted meanwhile
+			continue
+		}
+		if _, corrupted := errs[k].(CorruptedFileError); corrupted {  // do not prevent the rest of the pages from being listed
+			unreadable[ids[k]] = errs[k]
+			continue
+		}
+		if errs[k] != nil {
+			return nil, errs[k]
+		}
+		result = append(result, summary)
+	}
+	if len(unreadable) > 0 {
+		return result, UnreadablePagesError{unreadable}
+	}
+	return result, nil
 }
 
-// TODO: implement more efficiently
 func (store *diskStore) FindByTitle(title string) (PageId, error) {
-	ids, err := store.ListAll()
+	err := store.refreshIndex()
 	if err != nil {
 		return "", err
 	}
+	return store.titles.find(title), nil
+}
 
-	for _, id := range ids {
-		page, err := store.Read(id)
+func (store *diskStore) FindByTag(tag string) ([]PageId, error) {
+	err := store.refreshIndex()
+	if err != nil {
+		return nil, err
+	}
+	return store.tags.find(NormalizeTag(tag)), nil
+}
+
+func (store *diskStore) FindChildren(id PageId) ([]PageId, error) {
+	err := store.refreshIndex()
+	if err != nil {
+		return nil, err
+	}
+	return store.tree.find(id), nil
+}
+
+func (store *diskStore) ListTags() (map[string]int, error) {
+	err := store.refreshIndex()
+	if err != nil {
+		return nil, err
+	}
+	return store.tags.counts(), nil
+}
+
+func (store *diskStore) TitleMatching() TitleNormalization {
+	return store.titles.normalization
+}
+
+func (store *diskStore) ListRevisions(id PageId) ([]*Revision, error) {
+	unlock, err := store.lockExisting(id, store.getPageFilename(id), false)
+	if os.IsNotExist(err) {
+		return nil, UnexistentPageError{id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return store.readRevisions(id)
+}
+
+func (store *diskStore) ReadRevision(id PageId, number int) (*Revision, error) {
+	unlock, err := store.lockExisting(id, store.getPageFilename(id), false)
+	if os.IsNotExist(err) {
+		return nil, UnexistentPageError{id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	revisions, err := store.readRevisions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		if revision.Number == number {
+			return revision, nil
+		}
+	}
+
+	return nil, UnexistentRevisionError{id, number}
+}
+
+// Returns the revisions of a page sorted by number. Pages written before history was kept
+// have no revision files: their current state is returned as revision 1.
+func (store *diskStore) readRevisions(id PageId) ([]*Revision, error) {
+	page, err := store.readPageFromFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	historyDir := store.getHistoryDir(id)
+	files, err := ioutil.ReadDir(historyDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var revisions []*Revision
+	for _, file := range files {
+		if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), FILE_SUFFIX) {
+			revision, err := readRevisionFromFile(historyDir + "/" + file.Name())
+			if err != nil {
+				return nil, err
+			}
+			revisions = append(revisions, revision)
+		}
+	}
+
+	if len(revisions) == 0 {
+		info, err := os.Stat(store.getPageFilename(id))
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		if title == page.Title {
-			return page.Id, nil
+		revisions = append(revisions, &Revision{Number: 1, Timestamp: info.ModTime(), Title: page.Title, Body: page.Body, Author: page.Author})
+	}
+
+	sort.Sort(revisionsByNumber(revisions))
+	return revisions, nil
+}
+
+func readRevisionFromFile(filename string) (*Revision, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var revision Revision
+	err = json.Unmarshal(content, &revision)
+	if err != nil {
+		return nil, CorruptedFileError{filename, err}
+	}
+
+	return &revision, nil
+}
+
+func (store *diskStore) writeRevision(page *Page, number int, timestamp time.Time) error {
+	revision := &Revision{Number: number, Timestamp: timestamp, Title: page.Title, Body: page.Body, Author: page.Author}
+	return store.writeRevisionFile(page.Id, revision)
+}
+
+// Replaces the history of a page
+func (store *diskStore) writeHistory(id PageId, revisions []*Revision) error {
+	err := os.RemoveAll(store.getHistoryDir(id))
+	if err != nil {
+		return err
+	}
+	for _, revision := range revisions {
+		err = store.writeRevisionFile(id, revision)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func (store *diskStore) writeRevisionFile(id PageId, revision *Revision) error {
+	content, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
 
-	return "", nil
+	historyDir := store.getHistoryDir(id)
+	err = os.MkdirAll(historyDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	filename := historyDir + "/" + strconv.Itoa(revision.Number) + FILE_SUFFIX
+	return writeFileAtomically(filename, content, 0600)
 }
 
 func (store *diskStore) readPageFromFile(id PageId) (*Page, error) {
-	filename := store.getPageFilename(id)
-	content, err := ioutil.ReadFile(filename)
+	page, err := readPageFile(store.getPageFilename(id), id)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, UnexistentPageError{id}
-		} else {
+		}
+		return nil, err
+	}
+
+	if page.Modified.IsZero() {  // written before pages had timestamps
+		err = store.fillTimestamps(page)
+		if err != nil {
 			return nil, err
 		}
 	}
+	return page, nil
+}
+
+// Takes the timestamps of pages written before they were kept from the files: the page was last
+// modified when its file was written, and created with its first revision, if it has history
+func (store *diskStore) fillTimestamps(page *Page) error {
+	info, err := os.Stat(store.getPageFilename(page.Id))
+	if err != nil {
+		return err
+	}
+	page.Modified = info.ModTime()
+
+	page.Created = page.Modified
+	first, err := readRevisionFromFile(store.getHistoryDir(page.Id) + "/1" + FILE_SUFFIX)
+	if err == nil {
+		page.Created = first.Timestamp
+	}
+	return nil
+}
+
+func readPageFile(filename string, id PageId) (*Page, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
 
 	var page Page
 	err = json.Unmarshal(content, &page)
@@ -131,15 +924,85 @@ func (store *diskStore) writePageToFile(page *Page) error {
 		return err
 	}
 
+	err = store.makePageDir(page.Id)
+	if err != nil {
+		return err
+	}
 	filename := store.getPageFilename(page.Id)
-	return ioutil.WriteFile(filename, content, 0600)
+	return writeFileAtomically(filename, content, 0600)
+}
+
+// Creates the shard directories of a page, if the layout has them
+func (store *diskStore) makePageDir(id PageId) error {
+	if store.layout == FLAT_LAYOUT {
+		return nil
+	}
+	return os.MkdirAll(store.path+"/"+store.layout.pageDir(id), 0700)
 }
 
 func (store *diskStore) getPageFilename(id PageId) string {
-	return store.path + "/" + string(id) + FILE_SUFFIX
+	return store.path + "/" + store.getRelativeFilename(id, FILE_SUFFIX)
+}
+
+func (store *diskStore) getHistoryDir(id PageId) string {
+	return store.path + "/" + store.getRelativeFilename(id, HISTORY_SUFFIX)
+}
+
+// Name of the page file or the history directory of a page relative to the store directory
+func (store *diskStore) getRelativeFilename(id PageId, suffix string) string {
+	return store.layout.pageDir(id) + string(id) + suffix
+}
+
+func (store *diskStore) getTrashDir() string {
+	return store.path + "/" + TRASH_DIR
+}
+
+func (store *diskStore) getTrashedFilename(id PageId, suffix string) string {
+	return store.getTrashDir() + "/" + string(id) + suffix
+}
+
+func (store *diskStore) getLockDir() string {
+	return store.path + "/" + LOCK_DIR
+}
+
+// Purges the pages deleted before the given time, skipping those restored or purged meanwhile
+func purgeTrash(store PageStore, before time.Time) (int, error) {
+	trash, err := store.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, trashed := range trash {
+		if !trashed.Deleted.Before(before) {
+			continue
+		}
+		err = store.Purge(trashed.Id)
+		if _, ok := err.(NotInTrashError); ok {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
 }
 
-func newPageId() (PageId, error) {
+type trashedPagesByDeletion []*TrashedPage
+
+func (list trashedPagesByDeletion) Len() int           { return len(list) }
+func (list trashedPagesByDeletion) Less(i, j int) bool { return list[i].Deleted.After(list[j].Deleted) }
+func (list trashedPagesByDeletion) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+
+type revisionsByNumber []*Revision
+
+func (list revisionsByNumber) Len() int           { return len(list) }
+func (list revisionsByNumber) Less(i, j int) bool { return list[i].Number < list[j].Number }
+func (list revisionsByNumber) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+
+// Generates a random page id, for use by PageStore implementations
+func NewPageId() (PageId, error) {
 	bytes := make([]byte, PAGE_ID_LEN)
 	_, err := rand.Read(bytes)
 	if err != nil {
@@ -148,6 +1011,13 @@ func newPageId() (PageId, error) {
 	return PageId(hex.EncodeToString(bytes)), nil
 }
 
+// Page ids are made of ASCII letters and digits, so that they can be used in file names and URLs
+func ValidPageId(id PageId) bool {
+	return pageIdPattern.MatchString(string(id))
+}
+
+var pageIdPattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
 type UnexistentPageError struct {
     Id PageId
 }
@@ -165,3 +1035,91 @@ func (err CorruptedFileError) Error() string {
     return fmt.Sprintf("corrupted file %q: %s", err.Filename, err.Cause.Error())
 }
 
+// Returned by ListSummaries and ListIndexedSummaries, along with the summaries of the pages that could be read, when the files of
+// other pages are corrupted
+type UnreadablePagesError struct {
+    Errors map[PageId]error
+}
+
+func (err UnreadablePagesError) Error() string {
+    return fmt.Sprintf("%d unreadable pages", len(err.Errors))
+}
+
+type UnexistentRevisionError struct {
+    Id PageId
+    Number int
+}
+
+func (err UnexistentRevisionError) Error() string {
+    return fmt.Sprintf("unexistent revision %d of page %q", err.Number, err.Id)
+}
+
+// Returned when updating a page that was modified since it was read
+type ConflictError struct {
+    Current *Page
+    Rejected *Page
+}
+
+func (err ConflictError) Error() string {
+    return fmt.Sprintf("conflicting update of page %q: version %d was modified (current version is %d)",
+        err.Current.Id, err.Rejected.Version, err.Current.Version)
+}
+
+// Returned when creating or renaming a page with the title of another page
+type DuplicateTitleError struct {
+    Title string
+    Id PageId  // of the page that already has the title
+}
+
+func (err DuplicateTitleError) Error() string {
+    return fmt.Sprintf("duplicate title %q: already used by page %q", err.Title, err.Id)
+}
+
+// Returned when a page is given a parent that does not exist, or that is the page itself or one of its descendants
+type InvalidParentError struct {
+    Id PageId  // "" when creating the page
+    Parent PageId
+    Reason string
+}
+
+func (err InvalidParentError) Error() string {
+    return fmt.Sprintf("invalid parent %q of page %q: %s", err.Parent, err.Id, err.Reason)
+}
+
+// Returned when deleting a page with children, which must be deleted or moved first
+type HasChildrenError struct {
+    Id PageId
+    Children []PageId
+}
+
+func (err HasChildrenError) Error() string {
+    return fmt.Sprintf("page %q cannot be deleted: it has %d child pages", err.Id, len(err.Children))
+}
+
+// Returned when importing a page whose id could not be used as a file name or in URLs
+type InvalidPageIdError struct {
+    Id PageId
+}
+
+func (err InvalidPageIdError) Error() string {
+    return fmt.Sprintf("invalid page id %q", err.Id)
+}
+
+// Returned when restoring or purging a page that is not in the trash
+type NotInTrashError struct {
+    Id PageId
+}
+
+func (err NotInTrashError) Error() string {
+    return fmt.Sprintf("page %q is not in the trash", err.Id)
+}
+
+// Returned when restoring a page whose id was taken by another page while it was in the trash, or when
+// importing an archived page whose id is already in the store with the IMPORT_FAIL policy
+type ExistingPageError struct {
+    Id PageId
+}
+
+func (err ExistingPageError) Error() string {
+    return fmt.Sprintf("page %q already exists", err.Id)
+}
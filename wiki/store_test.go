@@ -5,6 +5,10 @@ import (
 	"sort"
 	"io/ioutil"
 	"os"
+	"encoding/json"
+	"fmt"
+	"time"
+	"sync"
 )
 
 func setupPageStore() *diskStore {
@@ -12,7 +16,11 @@ func setupPageStore() *diskStore {
 	if err != nil {
 		panic(err)
 	}
-	return &diskStore{path: storePath}
+	store, err := newDiskStore(storePath)
+	if err != nil {
+		panic(err)
+	}
+	return store
 }
 
 func cleanPageStore(store *diskStore) {
@@ -20,7 +28,7 @@ func cleanPageStore(store *diskStore) {
 	for _, id := range ids {
 		store.Delete(id)
 	}
-	os.Remove(store.path)
+	os.RemoveAll(store.path)  // including lock files
 }
 
 func TestStoreCreateRead(t *testing.T) {
@@ -123,6 +131,49 @@ func TestStoreDelete(t *testing.T) {
 	}
 }
 
+// A page left in the trash by an import interrupted by a crash cannot be restored over the imported one
+func TestStoreRestoreOverExistingPage(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	id, err := store.Create(&Page{Title: "Sample Page"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = store.Delete(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	trashed, err := ioutil.ReadFile(store.getTrashedFilename(id, FILE_SUFFIX))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	page := &Page{Id: id, Title: "Imported Page", Version: 1, Created: time.Now(), Modified: time.Now()}
+	err = store.Import(page, []*Revision{{Number: 1, Timestamp: page.Modified, Title: page.Title}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ioutil.WriteFile(store.getTrashedFilename(id, FILE_SUFFIX), trashed, 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = store.Restore(id)
+	if _, ok := err.(ExistingPageError); !ok {
+		t.Errorf("diskStore.Restore(%q): expected ExistingPageError, got %v", id, err)
+		return
+	}
+	current, err := store.Read(id)
+	if err != nil || current.Title != page.Title {
+		t.Errorf("diskStore.Restore(%q): the imported page was replaced by %+v (%v)", id, current, err)
+	}
+}
+
 func TestStoreListAll(t *testing.T) {
 	store := setupPageStore()
 	defer cleanPageStore(store)
@@ -220,8 +271,594 @@ func TestStoreUnexistentPageError(t *testing.T) {
 	}
 	
 	if unexistentPageErr.Id != id {
-		t.Error("UnexistentPageError.Id: expected %q, found %q", id, unexistentPageErr.Id)
+		t.Errorf("UnexistentPageError.Id: expected %q, found %q", id, unexistentPageErr.Id)
+		return
+	}
+}
+
+func TestStoreRevisions(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page.Title = "Modified Page Title"
+	page.Body = "This is a modified page body."
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(revisions) != 2 {
+		t.Errorf("diskStore.ListRevisions(%q): expected 2 revisions, found %d", id, len(revisions))
+		return
+	}
+	if revisions[0].Number != 1 || revisions[0].Body != "This is a sample page for testing purposes." {
+		t.Errorf("diskStore.ListRevisions(%q): unexpected first revision %v", id, revisions[0])
+		return
+	}
+	if revisions[1].Number != 2 || revisions[1].Title != page.Title || revisions[1].Body != page.Body {
+		t.Errorf("diskStore.ListRevisions(%q): unexpected second revision %v", id, revisions[1])
+		return
+	}
+
+	revision, err := store.ReadRevision(id, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if revision.Title != "Sample Page" {
+		t.Errorf("diskStore.ReadRevision(%q, 1): expected %q, found %q", id, "Sample Page", revision.Title)
+		return
+	}
+
+	_, err = store.ReadRevision(id, 3)
+	if _, ok := err.(UnexistentRevisionError); !ok {
+		t.Errorf("diskStore.ReadRevision(%q, 3): UnexistentRevisionError was expected, found %v", id, err)
+		return
+	}
+}
+
+func TestStoreRevisionsOfLegacyPage(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &Page{Id: "legacy", Title: "Legacy Page", Body: "Written before history was kept."}
+	err := store.writePageToFile(page)  // no revision files
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page.Body = "Modified body."
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	revisions, err := store.ListRevisions(page.Id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(revisions) != 2 {
+		t.Errorf("diskStore.ListRevisions(%q): expected 2 revisions, found %d", page.Id, len(revisions))
+		return
+	}
+	if revisions[0].Body != "Written before history was kept." || revisions[1].Body != page.Body {
+		t.Errorf("diskStore.ListRevisions(%q): previous body was not preserved", page.Id)
+		return
+	}
+}
+
+func TestStoreMetadataOfLegacyPage(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	legacy := `{"Id":"legacy","Title":"Legacy Page","Body":"Written before pages had metadata."}`
+	err := ioutil.WriteFile(store.getPageFilename("legacy"), []byte(legacy), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	written := time.Now().Add(-time.Hour).Round(time.Second)
+	err = os.Chtimes(store.getPageFilename("legacy"), written, written)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page, err := store.Read("legacy")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !page.Modified.Equal(written) || !page.Created.Equal(written) || page.Author != "" {
+		t.Errorf("diskStore.Read(%q): expected timestamps of the file, found %+v", page.Id, page)
+		return
+	}
+
+	page.Body = "Modified body."
+	page.Author = "alice"
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page, err = store.Read("legacy")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !page.Created.Equal(written) || !page.Modified.After(written) || page.Author != "alice" {
+		t.Errorf("diskStore.Read(%q): unexpected metadata after update %+v", page.Id, page)
 		return
 	}
 }
 
+func TestStoreConflictError(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	first, err := store.Read(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := store.Read(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	first.Body = "First modification."
+	err = store.Update(first)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if first.Version != 2 {
+		t.Errorf("diskStore.Update: expected version 2, found %d", first.Version)
+		return
+	}
+
+	second.Body = "Second modification."
+	err = store.Update(second)
+	conflictErr, ok := err.(ConflictError)
+	if !ok {
+		t.Errorf("diskStore.Update: ConflictError was expected, found %v", err)
+		return
+	}
+	if conflictErr.Current.Body != first.Body || conflictErr.Rejected.Body != second.Body {
+		t.Errorf("ConflictError: unexpected versions %v and %v", conflictErr.Current, conflictErr.Rejected)
+		return
+	}
+
+	pageRead, err := store.Read(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pageRead.Body != first.Body {
+		t.Errorf("diskStore.Read(%q): expected %q, found %q", id, first.Body, pageRead.Body)
+		return
+	}
+}
+
+func TestStoreRecoveryRemovesTempFiles(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	leftover := store.getPageFilename(id) + TEMP_FILE_INFIX + "123456"  // as left by an interrupted write
+	err = ioutil.WriteFile(leftover, []byte("{\"Id\":"), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	crash := time.Now().Add(-time.Hour)
+	err = os.Chtimes(leftover, crash, crash)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = NewDiskStore(store.path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = os.Stat(leftover)
+	if !os.IsNotExist(err) {
+		t.Errorf("NewDiskStore(%q): temporary file %q was not removed", store.path, leftover)
+		return
+	}
+
+	files, err := ioutil.ReadDir(store.path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, file := range files {
+		if isTempFile(file.Name()) {
+			t.Errorf("diskStore.Create: temporary file %q was left behind", file.Name())
+			return
+		}
+	}
+}
+
+func TestStoreUnexistentPagesLeaveNoLockFiles(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	id := PageId("aaaaaaaaaaaa")
+	if _, err := store.Read(id); err == nil {
+		t.Errorf("diskStore.Read(%q): expected an error", id)
+	}
+	if _, err := store.ListRevisions(id); err == nil {
+		t.Errorf("diskStore.ListRevisions(%q): expected an error", id)
+	}
+	if _, err := store.ReadRevision(id, 1); err == nil {
+		t.Errorf("diskStore.ReadRevision(%q): expected an error", id)
+	}
+	if err := store.Update(&Page{Id: id, Title: "Unexistent"}); err == nil {
+		t.Errorf("diskStore.Update(%q): expected an error", id)
+	}
+	if err := store.Delete(id); err == nil {
+		t.Errorf("diskStore.Delete(%q): expected an error", id)
+	}
+	if err := store.Restore(id); err == nil {
+		t.Errorf("diskStore.Restore(%q): expected an error", id)
+	}
+	if err := store.Purge(id); err == nil {
+		t.Errorf("diskStore.Purge(%q): expected an error", id)
+	}
+
+	_, err := os.Stat(store.getLockDir() + "/" + string(id) + LOCK_FILE_SUFFIX)
+	if !os.IsNotExist(err) {
+		t.Errorf("lock file of unexistent page %q was created", id)
+	}
+}
+
+func TestStoreTitleIndex(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page.Title = "Modified Page Title"
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for title, expected := range map[string]PageId{"Sample Page": "", "Modified Page Title": id} {
+		found, err := store.FindByTitle(title)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if found != expected {
+			t.Errorf("diskStore.FindByTitle(%q): expected %q, found %q", title, expected, found)
+			return
+		}
+	}
+
+	reopened, err := newDiskStore(store.path)  // index is rebuilt from the files
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	found, err := reopened.FindByTitle(page.Title)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found != id {
+		t.Errorf("diskStore.FindByTitle(%q): expected %q, found %q", page.Title, id, found)
+		return
+	}
+
+	err = store.Delete(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	found, err = store.FindByTitle(page.Title)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found != "" {
+		t.Errorf("diskStore.FindByTitle(%q): expected nil, found %q", page.Title, found)
+		return
+	}
+}
+
+func TestStoreListSummariesWithCorruptedFile(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	readable, err := store.Create(&Page{Title: "Readable Page"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	corrupted, err := store.Create(&Page{Title: "Corrupted Page"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ioutil.WriteFile(store.getPageFilename(corrupted), []byte("{not json"), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	summaries, err := store.ListSummaries(ListOptions{})
+	unreadableErr, ok := err.(UnreadablePagesError)
+	if !ok || len(unreadableErr.Errors) != 1 || unreadableErr.Errors[corrupted] == nil {
+		t.Errorf("diskStore.ListSummaries: expected UnreadablePagesError for %q, got %v", corrupted, err)
+		return
+	}
+	if len(summaries) != 1 || summaries[0].Id != readable {
+		t.Errorf("diskStore.ListSummaries: expected the summary of %q, found %v", readable, summaries)
+		return
+	}
+
+	reopened, err := newDiskStore(store.path)  // the file is corrupted when the index is built
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	summaries, err = reopened.ListIndexedSummaries()
+	unreadableErr, ok = err.(UnreadablePagesError)
+	if !ok || len(unreadableErr.Errors) != 1 || unreadableErr.Errors[corrupted] == nil {
+		t.Errorf("diskStore.ListIndexedSummaries: expected UnreadablePagesError for %q, got %v", corrupted, err)
+		return
+	}
+	if len(summaries) != 1 || summaries[0].Id != readable {
+		t.Errorf("diskStore.ListIndexedSummaries: expected the summary of %q, found %v", readable, summaries)
+	}
+}
+
+// Two stores opened on the same directory stand for two processes sharing it
+func TestStoreSharedDirectory(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+	other, err := newDiskStore(store.path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ids, err := other.ListRange(ListOptions{})
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Errorf("diskStore.ListRange: expected [%s] created by another store, found %v (%v)", id, ids, err)
+		return
+	}
+
+	_, err = other.Create(&Page{Title: page.Title, Body: "Another page."})
+	if _, ok := err.(DuplicateTitleError); !ok {
+		t.Errorf("diskStore.Create(%q): expected DuplicateTitleError for a title created by another store, got %v", page.Title, err)
+		return
+	}
+
+	page.Title = "Modified Page Title"
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	found, err := other.FindByTitle(page.Title)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found != id {
+		t.Errorf("diskStore.FindByTitle(%q): expected %q, found %q", page.Title, id, found)
+		return
+	}
+
+	var wait sync.WaitGroup
+	errs := make(chan error, 2*CONCURRENT_UPDATES)
+	for n := 0; n < CONCURRENT_UPDATES; n++ {
+		for _, writer := range []*diskStore{store, other} {
+			wait.Add(1)
+			go func(writer *diskStore, n int) {
+				defer wait.Done()
+				errs <- writer.Update(&Page{Id: id, Title: page.Title, Body: fmt.Sprintf("Modification #%d", n), Version: page.Version})
+			}(writer, n)
+		}
+	}
+	wait.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if _, ok := err.(ConflictError); !ok {
+			t.Error(err)
+			return
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("diskStore.Update(%q): %d concurrent updates of version %d succeeded, expected 1", id, succeeded, page.Version)
+		return
+	}
+
+	revisions, err := other.ListRevisions(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(revisions) != page.Version+1 {
+		t.Errorf("diskStore.ListRevisions(%q): expected %d revisions, found %d", id, page.Version+1, len(revisions))
+		return
+	}
+
+	err = other.Delete(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	found, err = store.FindByTitle(page.Title)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if found != "" {
+		t.Errorf("diskStore.FindByTitle(%q): expected nil after deletion by another store, found %q", page.Title, found)
+		return
+	}
+	ids, err = store.ListRange(ListOptions{})
+	if err != nil || len(ids) != 0 {
+		t.Errorf("diskStore.ListRange: expected no pages after deletion by another store, found %v (%v)", ids, err)
+	}
+}
+
+const CONCURRENT_UPDATES = 8
+
+const BENCHMARK_PAGES = 10000
+
+// Fills a store with many pages, writing the files directly to keep the setup fast
+func setupBenchmarkStore(b *testing.B) *diskStore {
+	store := setupPageStore()
+	for k := 0; k < BENCHMARK_PAGES; k++ {
+		page := &Page{Id: PageId(fmt.Sprintf("%012x", k)), Title: fmt.Sprintf("Page #%d", k), Body: "Some text."}
+		content, err := json.Marshal(page)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = ioutil.WriteFile(store.getPageFilename(page.Id), content, 0600)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	store, err := newDiskStore(store.path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return store
+}
+
+func cleanBenchmarkStore(b *testing.B, store *diskStore) {
+	b.StopTimer()
+	os.RemoveAll(store.path)
+}
+
+// Previous implementation of diskStore.FindByTitle, kept as a baseline
+func scanFindByTitle(store *diskStore, title string) (PageId, error) {
+	ids, err := store.ListAll()
+	if err != nil {
+		return "", err
+	}
+
+	for _, id := range ids {
+		page, err := store.Read(id)
+		if err != nil {
+			return "", err
+		}
+		if title == page.Title {
+			return page.Id, nil
+		}
+	}
+
+	return "", nil
+}
+
+func BenchmarkFindByTitleIndexed(b *testing.B) {
+	store := setupBenchmarkStore(b)
+	defer cleanBenchmarkStore(b, store)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := store.FindByTitle(fmt.Sprintf("Page #%d", i%BENCHMARK_PAGES))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindByTitleScan(b *testing.B) {
+	store := setupBenchmarkStore(b)
+	defer cleanBenchmarkStore(b, store)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := scanFindByTitle(store, fmt.Sprintf("Page #%d", i%BENCHMARK_PAGES))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIteratePages(b *testing.B) {
+	store := setupBenchmarkStore(b)
+	defer cleanBenchmarkStore(b, store)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iterator := IteratePages(store)
+		for iterator.Next() {
+		}
+		if iterator.Err() != nil {
+			b.Fatal(iterator.Err())
+		}
+	}
+}
+
+func BenchmarkEditToBody(b *testing.B) {
+	store := setupBenchmarkStore(b)
+	defer cleanBenchmarkStore(b, store)
+	syntax := &markdownSyntax{store}
+
+	edit := "See [Page #1][], [the last page][Page #9999] and [Page #5000] [] for more info."
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		syntax.EditToBody(edit)
+	}
+}
@@ -0,0 +1,145 @@
+package storetest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"github.com/joansais/go-practices/wiki"
+)
+
+// Returns a new, empty attachment store. Resources can be released with t.Cleanup.
+type AttachmentStoreFactory func(t *testing.T) wiki.AttachmentStore
+
+// Runs the conformance tests of wiki.AttachmentStore against the stores created by newStore
+func RunAttachmentStoreTests(t *testing.T, newStore AttachmentStoreFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, wiki.AttachmentStore)
+	}{
+		{"PutOpen", testPutOpen},
+		{"ReplaceAttachment", testReplaceAttachment},
+		{"ListAttachments", testListAttachments},
+		{"DeleteAttachment", testDeleteAttachment},
+		{"InvalidAttachmentName", testInvalidAttachmentName},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(t))
+		})
+	}
+}
+
+func mustPut(t *testing.T, store wiki.AttachmentStore, page wiki.PageId, name string, content []byte) *wiki.Attachment {
+	attachment, err := store.Put(page, name, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("AttachmentStore.Put(%q, %q): %s", page, name, err)
+	}
+	if attachment.Name != name || attachment.Size != int64(len(content)) {
+		t.Fatalf("AttachmentStore.Put(%q, %q): unexpected attachment %+v", page, name, attachment)
+	}
+	return attachment
+}
+
+func assertContent(t *testing.T, store wiki.AttachmentStore, page wiki.PageId, name string, expected []byte) {
+	content, attachment, err := store.Open(page, name)
+	if err != nil {
+		t.Fatalf("AttachmentStore.Open(%q, %q): %s", page, name, err)
+	}
+	defer content.Close()
+
+	found, err := ioutil.ReadAll(content)
+	if err != nil {
+		t.Fatalf("AttachmentStore.Open(%q, %q): %s", page, name, err)
+	}
+	if !bytes.Equal(found, expected) || attachment.Name != name || attachment.Size != int64(len(expected)) {
+		t.Fatalf("AttachmentStore.Open(%q, %q): unexpected content %q of attachment %+v", page, name, found, attachment)
+	}
+}
+
+func assertUnexistentAttachment(t *testing.T, operation string, page wiki.PageId, name string, err error) {
+	if _, ok := err.(wiki.UnexistentAttachmentError); !ok {
+		t.Fatalf("AttachmentStore.%s(%q, %q): UnexistentAttachmentError was expected, found %v", operation, page, name, err)
+	}
+}
+
+func testPutOpen(t *testing.T, store wiki.AttachmentStore) {
+	image := []byte("\x89PNG\r\n\x1a\nnot really an image")
+	mustPut(t, store, "page1", "screen.png", image)
+	assertContent(t, store, "page1", "screen.png", image)
+
+	_, _, err := store.Open("page2", "screen.png")  // attachments belong to a page
+	assertUnexistentAttachment(t, "Open", "page2", "screen.png", err)
+	_, _, err = store.Open("page1", "other.png")
+	assertUnexistentAttachment(t, "Open", "page1", "other.png", err)
+}
+
+func testReplaceAttachment(t *testing.T, store wiki.AttachmentStore) {
+	mustPut(t, store, "page1", "build.log", []byte("first build"))
+	mustPut(t, store, "page1", "build.log", []byte("second build"))
+	assertContent(t, store, "page1", "build.log", []byte("second build"))
+}
+
+func testListAttachments(t *testing.T, store wiki.AttachmentStore) {
+	attachments, err := store.List("page1")
+	if err != nil || len(attachments) != 0 {
+		t.Fatalf("AttachmentStore.List: expected no attachments, found %v (%v)", attachments, err)
+	}
+
+	for _, name := range []string{"report.pdf", "build.log", "screen.png"} {
+		mustPut(t, store, "page1", name, []byte(name))
+	}
+	mustPut(t, store, "page2", "other.txt", []byte("other"))
+
+	attachments, err = store.List("page1")
+	if err != nil {
+		t.Fatalf("AttachmentStore.List: %s", err)
+	}
+	var names []string
+	for _, attachment := range attachments {
+		names = append(names, attachment.Name)
+	}
+	if strings.Join(names, " ") != "build.log report.pdf screen.png" {
+		t.Fatalf("AttachmentStore.List: expected the attachments of the page sorted by name, found %v", names)
+	}
+}
+
+func testDeleteAttachment(t *testing.T, store wiki.AttachmentStore) {
+	mustPut(t, store, "page1", "build.log", []byte("build"))
+	mustPut(t, store, "page1", "screen.png", []byte("image"))
+
+	err := store.Delete("page1", "build.log")
+	if err != nil {
+		t.Fatalf("AttachmentStore.Delete: %s", err)
+	}
+	_, _, err = store.Open("page1", "build.log")
+	assertUnexistentAttachment(t, "Open", "page1", "build.log", err)
+	err = store.Delete("page1", "build.log")
+	assertUnexistentAttachment(t, "Delete", "page1", "build.log", err)
+
+	err = store.DeleteAll("page1")
+	if err != nil {
+		t.Fatalf("AttachmentStore.DeleteAll: %s", err)
+	}
+	attachments, err := store.List("page1")
+	if err != nil || len(attachments) != 0 {
+		t.Fatalf("AttachmentStore.List: expected no attachments after DeleteAll, found %v (%v)", attachments, err)
+	}
+	err = store.DeleteAll("page1")
+	if err != nil {
+		t.Fatalf("AttachmentStore.DeleteAll: deleting no attachments should not fail, found %s", err)
+	}
+}
+
+func testInvalidAttachmentName(t *testing.T, store wiki.AttachmentStore) {
+	for _, name := range []string{"", ".hidden", "../page2/file.txt", "dir/file.txt", "with space.txt"} {
+		_, err := store.Put("page1", name, strings.NewReader("content"))
+		if _, ok := err.(wiki.InvalidAttachmentNameError); !ok {
+			t.Fatalf("AttachmentStore.Put(%q): InvalidAttachmentNameError was expected, found %v", name, err)
+		}
+		_, _, err = store.Open("page1", name)
+		assertUnexistentAttachment(t, "Open", "page1", name, err)
+	}
+}
@@ -0,0 +1,1015 @@
+// Package storetest provides conformance test suites for wiki.PageStore and wiki.AttachmentStore
+// implementations, so that new storage backends can prove they behave like the disk stores.
+package storetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"github.com/joansais/go-practices/wiki"
+)
+
+// Returns a new, empty store. Resources can be released with t.Cleanup.
+// Stores are expected to match titles with wiki.DEFAULT_TITLE_NORMALIZATION.
+type StoreFactory func(t *testing.T) wiki.PageStore
+
+func RunPageStoreTests(t *testing.T, newStore StoreFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, wiki.PageStore)
+	}{
+		{"CreateRead", testCreateRead},
+		{"Update", testUpdate},
+		{"UpdateConflict", testUpdateConflict},
+		{"Import", testImport},
+		{"Delete", testDelete},
+		{"UnexistentPage", testUnexistentPage},
+		{"ListAll", testListAll},
+		{"ListRange", testListRange},
+		{"ListSummaries", testListSummaries},
+		{"ListIndexedSummaries", testListIndexedSummaries},
+		{"FindByTitle", testFindByTitle},
+		{"DuplicateTitle", testDuplicateTitle},
+		{"NormalizedTitle", testNormalizedTitle},
+		{"Revisions", testRevisions},
+		{"Metadata", testMetadata},
+		{"Tags", testTags},
+		{"Trash", testTrash},
+		{"RevisionsOfDeletedPage", testRevisionsOfDeletedPage},
+		{"RestoreDuplicateTitle", testRestoreDuplicateTitle},
+		{"ImportTrashedPage", testImportTrashedPage},
+		{"PurgeTrash", testPurgeTrash},
+		{"Hierarchy", testHierarchy},
+		{"InvalidParent", testInvalidParent},
+		{"RestoreOrphan", testRestoreOrphan},
+		{"ConcurrentAccess", testConcurrentAccess},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(t))
+		})
+	}
+}
+
+func newSamplePage(n int) *wiki.Page {
+	return &wiki.Page{Title: fmt.Sprintf("Sample Page %d", n), Body: "This is a sample page for testing purposes."}
+}
+
+func mustCreate(t *testing.T, store wiki.PageStore, page *wiki.Page) wiki.PageId {
+	id, err := store.Create(page)
+	if err != nil {
+		t.Fatalf("PageStore.Create: %s", err)
+	}
+	if id == "" || page.Id != id {
+		t.Fatalf("PageStore.Create: expected page id %q, found %q", id, page.Id)
+	}
+	return id
+}
+
+func mustRead(t *testing.T, store wiki.PageStore, id wiki.PageId) *wiki.Page {
+	page, err := store.Read(id)
+	if err != nil {
+		t.Fatalf("PageStore.Read(%q): %s", id, err)
+	}
+	return page
+}
+
+func assertSamePage(t *testing.T, expected, found *wiki.Page) {
+	if found.Id != expected.Id || found.Title != expected.Title || found.Body != expected.Body || found.Version != expected.Version ||
+		!found.Created.Equal(expected.Created) || !found.Modified.Equal(expected.Modified) || found.Author != expected.Author ||
+		found.Parent != expected.Parent {
+		t.Fatalf("PageStore.Read(%q): expected %+v, found %+v", expected.Id, expected, found)
+	}
+}
+
+func assertUnexistentPage(t *testing.T, operation string, id wiki.PageId, err error) {
+	unexistentErr, ok := err.(wiki.UnexistentPageError)
+	if !ok {
+		t.Fatalf("PageStore.%s(%q): UnexistentPageError was expected, found %v", operation, id, err)
+	}
+	if unexistentErr.Id != id {
+		t.Fatalf("UnexistentPageError.Id: expected %q, found %q", id, unexistentErr.Id)
+	}
+}
+
+func testCreateRead(t *testing.T, store wiki.PageStore) {
+	page := newSamplePage(1)
+	id := mustCreate(t, store, page)
+	if page.Version != 1 {
+		t.Fatalf("PageStore.Create: expected version 1, found %d", page.Version)
+	}
+	assertSamePage(t, page, mustRead(t, store, id))
+}
+
+func testUpdate(t *testing.T, store wiki.PageStore) {
+	page := newSamplePage(1)
+	id := mustCreate(t, store, page)
+
+	page.Title = "Modified Page Title"
+	page.Body = "This is a modified page body."
+	err := store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	if page.Version != 2 {
+		t.Fatalf("PageStore.Update: expected version 2, found %d", page.Version)
+	}
+	assertSamePage(t, page, mustRead(t, store, id))
+}
+
+func testUpdateConflict(t *testing.T, store wiki.PageStore) {
+	id := mustCreate(t, store, newSamplePage(1))
+	first := mustRead(t, store, id)
+	second := mustRead(t, store, id)
+
+	first.Body = "First modification."
+	err := store.Update(first)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+
+	second.Body = "Second modification."
+	err = store.Update(second)
+	conflictErr, ok := err.(wiki.ConflictError)
+	if !ok {
+		t.Fatalf("PageStore.Update: ConflictError was expected, found %v", err)
+	}
+	if conflictErr.Current.Body != first.Body || conflictErr.Rejected.Body != second.Body {
+		t.Fatalf("ConflictError: unexpected versions %+v and %+v", conflictErr.Current, conflictErr.Rejected)
+	}
+	assertSamePage(t, first, mustRead(t, store, id))
+}
+
+func testImport(t *testing.T, store wiki.PageStore) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	modified := created.Add(time.Hour)
+	page := &wiki.Page{Id: "imported1", Title: "Imported Page", Body: "Second version", Version: 2,
+		Created: created, Modified: modified, Author: "editor", Tags: []string{"Old"}}
+	revisions := []*wiki.Revision{
+		{Number: 1, Timestamp: created, Title: "Imported Page", Body: "First version", Author: "author"},
+		{Number: 2, Timestamp: modified, Title: "Imported Page", Body: "Second version", Author: "editor"},
+	}
+	err := store.Import(page, revisions)
+	if err != nil {
+		t.Fatalf("PageStore.Import: %s", err)
+	}
+	assertSamePage(t, page, mustRead(t, store, page.Id))
+	if fmt.Sprint(mustRead(t, store, page.Id).Tags) != "[old]" {
+		t.Fatalf("PageStore.Import: expected normalized tags [old], found %q", mustRead(t, store, page.Id).Tags)
+	}
+	assertSameRevisions(t, store, page.Id, revisions)
+
+	page.Title, page.Version = "Renamed Page", 3
+	revisions = append(revisions, &wiki.Revision{Number: 3, Timestamp: modified.Add(time.Hour), Title: page.Title, Body: page.Body})
+	err = store.Import(page, revisions)  // replaces the page and its history
+	if err != nil {
+		t.Fatalf("PageStore.Import: %s", err)
+	}
+	assertSamePage(t, page, mustRead(t, store, page.Id))
+	assertSameRevisions(t, store, page.Id, revisions)
+	if id, _ := store.FindByTitle("Imported Page"); id != "" {
+		t.Fatalf("PageStore.FindByTitle: the previous title of an imported page should not be found, found %q", id)
+	}
+
+	child := &wiki.Page{Id: "imported2", Title: "Child Page", Version: 1, Parent: page.Id}
+	err = store.Import(child, nil)
+	if err != nil {
+		t.Fatalf("PageStore.Import: %s", err)
+	}
+	assertChildren(t, store, page.Id, child.Id)
+
+	err = store.Import(&wiki.Page{Id: "imported3", Title: "renamed page", Version: 1}, nil)
+	assertDuplicateTitle(t, "Import", "renamed page", page.Id, err)
+
+	err = store.Import(&wiki.Page{Id: "imported3", Title: "Orphan", Version: 1, Parent: "unexistent"}, nil)
+	assertInvalidParent(t, "Import", "unexistent", err)
+
+	err = store.Import(&wiki.Page{Id: "../imported", Title: "Invalid", Version: 1}, nil)
+	if _, ok := err.(wiki.InvalidPageIdError); !ok {
+		t.Fatalf("PageStore.Import: expected InvalidPageIdError, found %v", err)
+	}
+}
+
+func assertSameRevisions(t *testing.T, store wiki.PageStore, id wiki.PageId, expected []*wiki.Revision) {
+	found, err := store.ListRevisions(id)
+	if err != nil {
+		t.Fatalf("PageStore.ListRevisions(%q): %s", id, err)
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("PageStore.ListRevisions(%q): expected %d revisions, found %d", id, len(expected), len(found))
+	}
+	for k, revision := range expected {
+		if found[k].Number != revision.Number || !found[k].Timestamp.Equal(revision.Timestamp) || found[k].Title != revision.Title ||
+			found[k].Body != revision.Body || found[k].Author != revision.Author {
+			t.Fatalf("PageStore.ListRevisions(%q): expected %+v, found %+v", id, revision, found[k])
+		}
+	}
+}
+
+func testDelete(t *testing.T, store wiki.PageStore) {
+	id := mustCreate(t, store, newSamplePage(1))
+
+	err := store.Delete(id)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id, err)
+	}
+
+	_, err = store.Read(id)
+	assertUnexistentPage(t, "Read", id, err)
+
+	ids, err := store.ListAll()
+	if err != nil {
+		t.Fatalf("PageStore.ListAll: %s", err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("PageStore.ListAll: expected no pages, found %v", ids)
+	}
+}
+
+func testUnexistentPage(t *testing.T, store wiki.PageStore) {
+	id := wiki.PageId("unexistent")
+
+	_, err := store.Read(id)
+	assertUnexistentPage(t, "Read", id, err)
+
+	err = store.Update(&wiki.Page{Id: id, Title: "Unexistent Page"})
+	assertUnexistentPage(t, "Update", id, err)
+
+	err = store.Delete(id)
+	assertUnexistentPage(t, "Delete", id, err)
+
+	_, err = store.ListRevisions(id)
+	assertUnexistentPage(t, "ListRevisions", id, err)
+}
+
+func testListAll(t *testing.T, store wiki.PageStore) {
+	var expected []string
+	for n := 1; n <= 3; n++ {
+		expected = append(expected, string(mustCreate(t, store, newSamplePage(n))))
+	}
+	sort.Strings(expected)
+
+	found, err := store.ListAll()
+	if err != nil {
+		t.Fatalf("PageStore.ListAll: %s", err)
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("PageStore.ListAll: expected %d pages, found %d", len(expected), len(found))
+	}
+	for k := range expected {  // sorted by id
+		if string(found[k]) != expected[k] {
+			t.Fatalf("PageStore.ListAll: expected %q, found %q", expected[k], found[k])
+		}
+	}
+}
+
+func testListRange(t *testing.T, store wiki.PageStore) {
+	var ids []wiki.PageId
+	for n := 1; n <= 5; n++ {
+		ids = append(ids, mustCreate(t, store, newSamplePage(n)))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, test := range []struct {
+		options		wiki.ListOptions
+		expected	[]wiki.PageId
+	}{
+		{wiki.ListOptions{}, ids},
+		{wiki.ListOptions{Limit: 2}, ids[:2]},
+		{wiki.ListOptions{After: ids[1], Limit: 2}, ids[2:4]},
+		{wiki.ListOptions{After: ids[3], Limit: 2}, ids[4:]},
+		{wiki.ListOptions{After: ids[4]}, nil},
+		{wiki.ListOptions{Before: ids[4], Limit: 2}, ids[2:4]},
+		{wiki.ListOptions{Before: ids[1], Limit: 2}, ids[:1]},
+		{wiki.ListOptions{Before: ids[3]}, ids[:3]},
+	} {
+		found, err := store.ListRange(test.options)
+		if err != nil {
+			t.Fatalf("PageStore.ListRange(%+v): %s", test.options, err)
+		}
+		if fmt.Sprint(found) != fmt.Sprint(test.expected) {
+			t.Fatalf("PageStore.ListRange(%+v): expected %v, found %v", test.options, test.expected, found)
+		}
+	}
+}
+
+func testListSummaries(t *testing.T, store wiki.PageStore) {
+	root := mustCreate(t, store, newSamplePage(1))
+	var pages []*wiki.Page
+	for n := 2; n <= 4; n++ {
+		page := newSamplePage(n)
+		page.Author = fmt.Sprintf("author%d", n)
+		page.Parent = root
+		mustCreate(t, store, page)
+		pages = append(pages, page)
+	}
+	pages = append(pages, mustRead(t, store, root))
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Id < pages[j].Id })
+
+	summaries, err := store.ListSummaries(wiki.ListOptions{})
+	if err != nil {
+		t.Fatalf("PageStore.ListSummaries: %s", err)
+	}
+	if len(summaries) != len(pages) {
+		t.Fatalf("PageStore.ListSummaries: expected %d summaries, found %d", len(pages), len(summaries))
+	}
+	for k, page := range pages {
+		summary := summaries[k]
+		if summary.Id != page.Id || summary.Title != page.Title || summary.Version != page.Version || summary.Author != page.Author ||
+			summary.Parent != page.Parent || !summary.Created.Equal(page.Created) || !summary.Modified.Equal(page.Modified) {
+			t.Fatalf("PageStore.ListSummaries: expected summary of %+v, found %+v", page, summary)
+		}
+	}
+
+	summaries, err = store.ListSummaries(wiki.ListOptions{After: pages[0].Id, Limit: 2})
+	if err != nil {
+		t.Fatalf("PageStore.ListSummaries: %s", err)
+	}
+	if len(summaries) != 2 || summaries[0].Id != pages[1].Id || summaries[1].Id != pages[2].Id {
+		t.Fatalf("PageStore.ListSummaries: expected pages %q and %q after %q, found %+v", pages[1].Id, pages[2].Id, pages[0].Id, summaries)
+	}
+}
+
+func testListIndexedSummaries(t *testing.T, store wiki.PageStore) {
+	var ids []wiki.PageId
+	for n := 1; n <= 3; n++ {
+		ids = append(ids, mustCreate(t, store, newSamplePage(n)))
+	}
+	page := mustRead(t, store, ids[0])
+	page.Body, page.Author = "Edited", "editor"  // same title, tags and parent
+	err := store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	page = mustRead(t, store, ids[1])
+	page.Title = "Renamed Page"
+	err = store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	err = store.Delete(ids[2])
+	if err != nil {
+		t.Fatalf("PageStore.Delete: %s", err)
+	}
+	imported := &wiki.Page{Id: "imported1", Title: "Imported Page", Version: 1, Created: time.Now(), Modified: time.Now()}
+	err = store.Import(imported, []*wiki.Revision{{Number: 1, Timestamp: imported.Modified, Title: imported.Title}})
+	if err != nil {
+		t.Fatalf("PageStore.Import: %s", err)
+	}
+
+	expected, err := store.ListSummaries(wiki.ListOptions{})
+	if err != nil {
+		t.Fatalf("PageStore.ListSummaries: %s", err)
+	}
+	summaries, err := store.ListIndexedSummaries()
+	if err != nil {
+		t.Fatalf("PageStore.ListIndexedSummaries: %s", err)
+	}
+	if len(summaries) != len(expected) {
+		t.Fatalf("PageStore.ListIndexedSummaries: expected %d summaries, found %d", len(expected), len(summaries))
+	}
+	for k, summary := range summaries {
+		if summary.Id != expected[k].Id || summary.Title != expected[k].Title || summary.Version != expected[k].Version ||
+			summary.Author != expected[k].Author || summary.Parent != expected[k].Parent ||
+			!summary.Created.Equal(expected[k].Created) || !summary.Modified.Equal(expected[k].Modified) {
+			t.Fatalf("PageStore.ListIndexedSummaries: expected %+v, found %+v", expected[k], summary)
+		}
+	}
+}
+
+func testFindByTitle(t *testing.T, store wiki.PageStore) {
+	var pages []*wiki.Page
+	for n := 1; n <= 3; n++ {
+		page := newSamplePage(n)
+		mustCreate(t, store, page)
+		pages = append(pages, page)
+	}
+
+	for _, page := range pages {
+		id, err := store.FindByTitle(page.Title)
+		if err != nil {
+			t.Fatalf("PageStore.FindByTitle: %s", err)
+		}
+		if id != page.Id {
+			t.Fatalf("PageStore.FindByTitle(%q): expected %q, found %q", page.Title, page.Id, id)
+		}
+	}
+
+	id, err := store.FindByTitle("unexistent page")
+	if err != nil {
+		t.Fatalf("PageStore.FindByTitle: %s", err)
+	}
+	if id != "" {
+		t.Fatalf("PageStore.FindByTitle: expected no page, found %q", id)
+	}
+
+	pages[0].Title = "Renamed Page"
+	err = store.Update(pages[0])
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	id, err = store.FindByTitle("Renamed Page")
+	if err != nil {
+		t.Fatalf("PageStore.FindByTitle: %s", err)
+	}
+	if id != pages[0].Id {
+		t.Fatalf("PageStore.FindByTitle(%q): expected %q, found %q", "Renamed Page", pages[0].Id, id)
+	}
+}
+
+func assertDuplicateTitle(t *testing.T, operation string, title string, owner wiki.PageId, err error) {
+	duplicateErr, ok := err.(wiki.DuplicateTitleError)
+	if !ok {
+		t.Fatalf("PageStore.%s: DuplicateTitleError was expected, found %v", operation, err)
+	}
+	if duplicateErr.Title != title || duplicateErr.Id != owner {
+		t.Fatalf("DuplicateTitleError: expected title %q of page %q, found %+v", title, owner, duplicateErr)
+	}
+}
+
+func testDuplicateTitle(t *testing.T, store wiki.PageStore) {
+	first := newSamplePage(1)
+	mustCreate(t, store, first)
+	second := newSamplePage(2)
+	mustCreate(t, store, second)
+
+	_, err := store.Create(newSamplePage(1))
+	assertDuplicateTitle(t, "Create", first.Title, first.Id, err)
+
+	renamed := *second
+	renamed.Title = first.Title
+	err = store.Update(&renamed)
+	assertDuplicateTitle(t, "Update", first.Title, first.Id, err)
+	assertSamePage(t, second, mustRead(t, store, second.Id))
+
+	ids, err := store.ListAll()
+	if err != nil {
+		t.Fatalf("PageStore.ListAll: %s", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("PageStore.ListAll: expected 2 pages, found %d", len(ids))
+	}
+
+	first.Body = "Modified body, same title."
+	err = store.Update(first)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+}
+
+func testNormalizedTitle(t *testing.T, store wiki.PageStore) {
+	page := &wiki.Page{Title: "Go Tips", Body: "Some tips."}
+	id := mustCreate(t, store, page)
+
+	found, err := store.FindByTitle("  go   TIPS ")
+	if err != nil {
+		t.Fatalf("PageStore.FindByTitle: %s", err)
+	}
+	if found != id {
+		t.Fatalf("PageStore.FindByTitle(%q): expected %q, found %q", "  go   TIPS ", id, found)
+	}
+
+	_, err = store.Create(&wiki.Page{Title: "go tips", Body: "Other tips."})
+	assertDuplicateTitle(t, "Create", "go tips", id, err)
+
+	page.Title = "Go tips"  // renaming a page to a variant of its own title is allowed
+	err = store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+}
+
+func testRevisions(t *testing.T, store wiki.PageStore) {
+	page := newSamplePage(1)
+	id := mustCreate(t, store, page)
+	originalBody := page.Body
+
+	page.Body = "This is a modified page body."
+	err := store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		t.Fatalf("PageStore.ListRevisions(%q): %s", id, err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("PageStore.ListRevisions(%q): expected 2 revisions, found %d", id, len(revisions))
+	}
+	if revisions[0].Number != 1 || revisions[0].Body != originalBody || revisions[1].Number != 2 || revisions[1].Body != page.Body {
+		t.Fatalf("PageStore.ListRevisions(%q): unexpected revisions %+v, %+v", id, revisions[0], revisions[1])
+	}
+
+	revision, err := store.ReadRevision(id, 1)
+	if err != nil {
+		t.Fatalf("PageStore.ReadRevision(%q, 1): %s", id, err)
+	}
+	if revision.Body != originalBody {
+		t.Fatalf("PageStore.ReadRevision(%q, 1): expected %q, found %q", id, originalBody, revision.Body)
+	}
+
+	_, err = store.ReadRevision(id, 3)
+	if _, ok := err.(wiki.UnexistentRevisionError); !ok {
+		t.Fatalf("PageStore.ReadRevision(%q, 3): UnexistentRevisionError was expected, found %v", id, err)
+	}
+}
+
+func testMetadata(t *testing.T, store wiki.PageStore) {
+	before := time.Now()
+	page := newSamplePage(1)
+	page.Author = "alice"
+	id := mustCreate(t, store, page)
+	if page.Created.Before(before) || !page.Modified.Equal(page.Created) {
+		t.Fatalf("PageStore.Create: unexpected timestamps, created %s and modified %s", page.Created, page.Modified)
+	}
+	assertSamePage(t, page, mustRead(t, store, id))
+
+	created := page.Created
+	page.Body = "This is a modified page body."
+	page.Author = "bob"
+	page.Created = time.Time{}  // must be ignored
+	err := store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	if !page.Created.Equal(created) || page.Modified.Before(created) {
+		t.Fatalf("PageStore.Update: unexpected timestamps, created %s and modified %s", page.Created, page.Modified)
+	}
+	assertSamePage(t, page, mustRead(t, store, id))
+
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		t.Fatalf("PageStore.ListRevisions(%q): %s", id, err)
+	}
+	if len(revisions) != 2 || revisions[0].Author != "alice" || revisions[1].Author != "bob" {
+		t.Fatalf("PageStore.ListRevisions(%q): revisions do not keep their authors", id)
+	}
+}
+
+func assertTaggedPages(t *testing.T, store wiki.PageStore, tag string, expected ...wiki.PageId) {
+	found, err := store.FindByTag(tag)
+	if err != nil {
+		t.Fatalf("PageStore.FindByTag(%q): %s", tag, err)
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+	if fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Fatalf("PageStore.FindByTag(%q): expected %v, found %v", tag, expected, found)
+	}
+}
+
+func testTags(t *testing.T, store wiki.PageStore) {
+	page1 := newSamplePage(1)
+	page1.Tags = []string{"Web ", "go", "GO"}
+	id1 := mustCreate(t, store, page1)
+	if fmt.Sprint(page1.Tags) != "[go web]" {
+		t.Fatalf("PageStore.Create: expected normalized tags [go web], found %q", page1.Tags)
+	}
+	assertSamePage(t, page1, mustRead(t, store, id1))
+
+	page2 := newSamplePage(2)
+	page2.Tags = []string{"go"}
+	id2 := mustCreate(t, store, page2)
+	id3 := mustCreate(t, store, newSamplePage(3))
+	if tags := mustRead(t, store, id3).Tags; len(tags) != 0 {
+		t.Fatalf("PageStore.Read(%q): expected no tags, found %q", id3, tags)
+	}
+
+	assertTaggedPages(t, store, "go", id1, id2)
+	assertTaggedPages(t, store, " Go", id1, id2)
+	assertTaggedPages(t, store, "web", id1)
+	assertTaggedPages(t, store, "unknown")
+
+	tags, err := store.ListTags()
+	if err != nil {
+		t.Fatalf("PageStore.ListTags: %s", err)
+	}
+	if len(tags) != 2 || tags["go"] != 2 || tags["web"] != 1 {
+		t.Fatalf("PageStore.ListTags: unexpected counts %v", tags)
+	}
+
+	page1.Tags = []string{"web", "databases"}
+	err = store.Update(page1)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	assertSamePage(t, page1, mustRead(t, store, id1))
+	assertTaggedPages(t, store, "go", id2)
+	assertTaggedPages(t, store, "databases", id1)
+
+	err = store.Delete(id2)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id2, err)
+	}
+	assertTaggedPages(t, store, "go")
+	tags, err = store.ListTags()
+	if err != nil {
+		t.Fatalf("PageStore.ListTags: %s", err)
+	}
+	if _, found := tags["go"]; found {
+		t.Fatalf("PageStore.ListTags: tags of deleted pages should not be listed, found %v", tags)
+	}
+
+	err = store.Restore(id2)
+	if err != nil {
+		t.Fatalf("PageStore.Restore(%q): %s", id2, err)
+	}
+	assertTaggedPages(t, store, "go", id2)
+}
+
+func assertNotInTrash(t *testing.T, operation string, id wiki.PageId, err error) {
+	if _, ok := err.(wiki.NotInTrashError); !ok {
+		t.Fatalf("PageStore.%s(%q): NotInTrashError was expected, found %v", operation, id, err)
+	}
+}
+
+func mustListTrash(t *testing.T, store wiki.PageStore) []*wiki.TrashedPage {
+	trash, err := store.ListTrash()
+	if err != nil {
+		t.Fatalf("PageStore.ListTrash: %s", err)
+	}
+	return trash
+}
+
+func testTrash(t *testing.T, store wiki.PageStore) {
+	page := newSamplePage(1)
+	id := mustCreate(t, store, page)
+	page.Body = "This is a modified page body."
+	err := store.Update(page)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+
+	assertNotInTrash(t, "Restore", id, store.Restore(id))
+
+	err = store.Delete(id)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id, err)
+	}
+	found, err := store.FindByTitle(page.Title)
+	if err != nil || found != "" {
+		t.Fatalf("PageStore.FindByTitle(%q): expected no page after deletion, found %q (%v)", page.Title, found, err)
+	}
+
+	trash := mustListTrash(t, store)
+	if len(trash) != 1 || trash[0].Id != id || trash[0].Title != page.Title || trash[0].Deleted.IsZero() {
+		t.Fatalf("PageStore.ListTrash: unexpected trash %+v", trash)
+	}
+
+	err = store.Restore(id)
+	if err != nil {
+		t.Fatalf("PageStore.Restore(%q): %s", id, err)
+	}
+	assertSamePage(t, page, mustRead(t, store, id))
+	if len(mustListTrash(t, store)) != 0 {
+		t.Fatalf("PageStore.ListTrash: restored page is still in the trash")
+	}
+	found, err = store.FindByTitle(page.Title)
+	if err != nil || found != id {
+		t.Fatalf("PageStore.FindByTitle(%q): expected %q after restoring, found %q (%v)", page.Title, id, found, err)
+	}
+	revisions, err := store.ListRevisions(id)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("PageStore.ListRevisions(%q): expected the 2 revisions to be restored, found %d (%v)", id, len(revisions), err)
+	}
+
+	err = store.Delete(id)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id, err)
+	}
+	err = store.Purge(id)
+	if err != nil {
+		t.Fatalf("PageStore.Purge(%q): %s", id, err)
+	}
+	if len(mustListTrash(t, store)) != 0 {
+		t.Fatalf("PageStore.ListTrash: purged page is still in the trash")
+	}
+	assertNotInTrash(t, "Restore", id, store.Restore(id))
+	assertNotInTrash(t, "Purge", id, store.Purge(id))
+	_, err = store.Read(id)
+	assertUnexistentPage(t, "Read", id, err)
+}
+
+func testRevisionsOfDeletedPage(t *testing.T, store wiki.PageStore) {
+	id := mustCreate(t, store, newSamplePage(1))
+	err := store.Delete(id)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id, err)
+	}
+	_, err = store.ListRevisions(id)
+	assertUnexistentPage(t, "ListRevisions", id, err)
+	_, err = store.ReadRevision(id, 1)
+	assertUnexistentPage(t, "ReadRevision", id, err)
+
+	err = store.Purge(id)
+	if err != nil {
+		t.Fatalf("PageStore.Purge(%q): %s", id, err)
+	}
+	_, err = store.ReadRevision(id, 1)
+	assertUnexistentPage(t, "ReadRevision", id, err)
+}
+
+func testRestoreDuplicateTitle(t *testing.T, store wiki.PageStore) {
+	id := mustCreate(t, store, newSamplePage(1))
+	err := store.Delete(id)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id, err)
+	}
+
+	other := mustCreate(t, store, newSamplePage(1))  // the title is free while the page is in the trash
+	err = store.Restore(id)
+	assertDuplicateTitle(t, "Restore", "Sample Page 1", other, err)
+
+	trash := mustListTrash(t, store)
+	if len(trash) != 1 || trash[0].Id != id {
+		t.Fatalf("PageStore.ListTrash: page %q should have been kept in the trash, found %+v", id, trash)
+	}
+}
+
+func testImportTrashedPage(t *testing.T, store wiki.PageStore) {
+	id := mustCreate(t, store, newSamplePage(1))
+	err := store.Delete(id)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id, err)
+	}
+	page := &wiki.Page{Id: id, Title: "Imported Page", Body: "Imported", Version: 1, Created: time.Now(), Modified: time.Now()}
+	err = store.Import(page, []*wiki.Revision{{Number: 1, Timestamp: page.Modified, Title: page.Title, Body: page.Body}})
+	if err != nil {
+		t.Fatalf("PageStore.Import(%q): %s", id, err)
+	}
+
+	if trash := mustListTrash(t, store); len(trash) != 0 {
+		t.Fatalf("PageStore.Import(%q): expected the trashed page to be replaced, found %+v in the trash", id, trash)
+	}
+	err = store.Restore(id)
+	if _, ok := err.(wiki.NotInTrashError); !ok {
+		t.Fatalf("PageStore.Restore(%q): expected NotInTrashError, got %v", id, err)
+	}
+	if current := mustRead(t, store, id); current.Title != page.Title || current.Body != page.Body {
+		t.Fatalf("PageStore.Restore(%q): the imported page was replaced by %+v", id, current)
+	}
+
+	err = store.Delete(id)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", id, err)
+	}
+	trash := mustListTrash(t, store)
+	if len(trash) != 1 || trash[0].Id != id || trash[0].Title != page.Title {
+		t.Fatalf("PageStore.ListTrash: expected the imported page %q, found %+v", id, trash)
+	}
+	err = store.Restore(id)
+	if err != nil {
+		t.Fatalf("PageStore.Restore(%q): %s", id, err)
+	}
+	assertSameRevisions(t, store, id, []*wiki.Revision{{Number: 1, Timestamp: page.Modified, Title: page.Title, Body: page.Body}})
+}
+
+func testPurgeTrash(t *testing.T, store wiki.PageStore) {
+	before := time.Now().Add(-time.Second)
+	var ids []wiki.PageId
+	for n := 0; n < 3; n++ {
+		id := mustCreate(t, store, newSamplePage(n))
+		err := store.Delete(id)
+		if err != nil {
+			t.Fatalf("PageStore.Delete(%q): %s", id, err)
+		}
+		ids = append(ids, id)
+	}
+
+	purged, err := store.PurgeTrash(before)
+	if err != nil || purged != 0 {
+		t.Fatalf("PageStore.PurgeTrash: expected no pages deleted before %s, purged %d (%v)", before, purged, err)
+	}
+
+	trash := mustListTrash(t, store)
+	if len(trash) != len(ids) {
+		t.Fatalf("PageStore.ListTrash: expected %d pages, found %d", len(ids), len(trash))
+	}
+	for k := 1; k < len(trash); k++ {
+		if trash[k].Deleted.After(trash[k-1].Deleted) {
+			t.Fatalf("PageStore.ListTrash: pages are not sorted by deletion time, most recent first")
+		}
+	}
+
+	purged, err = store.PurgeTrash(time.Now().Add(time.Second))
+	if err != nil || purged != len(ids) {
+		t.Fatalf("PageStore.PurgeTrash: expected %d pages purged, purged %d (%v)", len(ids), purged, err)
+	}
+	if len(mustListTrash(t, store)) != 0 {
+		t.Fatalf("PageStore.ListTrash: expected an empty trash after purging it")
+	}
+}
+
+func assertChildren(t *testing.T, store wiki.PageStore, id wiki.PageId, expected ...wiki.PageId) {
+	found, err := store.FindChildren(id)
+	if err != nil {
+		t.Fatalf("PageStore.FindChildren(%q): %s", id, err)
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+	if fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Fatalf("PageStore.FindChildren(%q): expected %v, found %v", id, expected, found)
+	}
+}
+
+func assertInvalidParent(t *testing.T, operation string, parent wiki.PageId, err error) {
+	if parentErr, ok := err.(wiki.InvalidParentError); !ok || parentErr.Parent != parent {
+		t.Fatalf("PageStore.%s: expected InvalidParentError for parent %q, found %v", operation, parent, err)
+	}
+}
+
+func testHierarchy(t *testing.T, store wiki.PageStore) {
+	root := mustCreate(t, store, newSamplePage(1))
+	page2 := newSamplePage(2)
+	page2.Parent = root
+	child1 := mustCreate(t, store, page2)
+	assertSamePage(t, page2, mustRead(t, store, child1))
+	page3 := newSamplePage(3)
+	page3.Parent = root
+	child2 := mustCreate(t, store, page3)
+
+	assertChildren(t, store, root, child1, child2)
+	assertChildren(t, store, child1)
+
+	err := store.Delete(root)
+	if childrenErr, ok := err.(wiki.HasChildrenError); !ok || len(childrenErr.Children) != 2 {
+		t.Fatalf("PageStore.Delete(%q): expected HasChildrenError with 2 children, found %v", root, err)
+	}
+	mustRead(t, store, root)
+
+	page3.Parent = child1
+	err = store.Update(page3)
+	if err != nil {
+		t.Fatalf("PageStore.Update: %s", err)
+	}
+	assertSamePage(t, page3, mustRead(t, store, child2))
+	assertChildren(t, store, root, child1)
+	assertChildren(t, store, child1, child2)
+
+	err = store.Delete(child2)
+	if err != nil {
+		t.Fatalf("PageStore.Delete(%q): %s", child2, err)
+	}
+	assertChildren(t, store, child1)
+
+	err = store.Restore(child2)
+	if err != nil {
+		t.Fatalf("PageStore.Restore(%q): %s", child2, err)
+	}
+	assertSamePage(t, page3, mustRead(t, store, child2))
+	assertChildren(t, store, child1, child2)
+}
+
+func testInvalidParent(t *testing.T, store wiki.PageStore) {
+	page1 := newSamplePage(1)
+	page1.Parent = "unexistent"
+	_, err := store.Create(page1)
+	assertInvalidParent(t, "Create", "unexistent", err)
+
+	page1.Parent = ""
+	root := mustCreate(t, store, page1)
+	page2 := newSamplePage(2)
+	page2.Parent = root
+	child := mustCreate(t, store, page2)
+
+	page1.Parent = root
+	err = store.Update(page1)
+	assertInvalidParent(t, "Update", root, err)
+
+	page1.Parent = child
+	err = store.Update(page1)
+	assertInvalidParent(t, "Update", child, err)
+
+	page1.Parent = ""
+	assertSamePage(t, page1, mustRead(t, store, root))
+	assertChildren(t, store, child)
+}
+
+func testRestoreOrphan(t *testing.T, store wiki.PageStore) {
+	root := mustCreate(t, store, newSamplePage(1))
+	page2 := newSamplePage(2)
+	page2.Parent = root
+	child := mustCreate(t, store, page2)
+
+	for _, id := range []wiki.PageId{child, root} {
+		err := store.Delete(id)
+		if err != nil {
+			t.Fatalf("PageStore.Delete(%q): %s", id, err)
+		}
+	}
+	err := store.Purge(root)
+	if err != nil {
+		t.Fatalf("PageStore.Purge(%q): %s", root, err)
+	}
+
+	err = store.Restore(child)
+	if err != nil {
+		t.Fatalf("PageStore.Restore(%q): %s", child, err)
+	}
+	if parent := mustRead(t, store, child).Parent; parent != "" {
+		t.Fatalf("PageStore.Restore(%q): expected a top-level page, found parent %q", child, parent)
+	}
+}
+
+const CONCURRENT_WRITERS = 8
+
+// Each writer creates and repeatedly updates its own page while readers list and read all pages
+func testConcurrentAccess(t *testing.T, store wiki.PageStore) {
+	var wait sync.WaitGroup
+	errs := make(chan error, 2*CONCURRENT_WRITERS)
+
+	for n := 0; n < CONCURRENT_WRITERS; n++ {
+		wait.Add(2)
+		go func(n int) {
+			defer wait.Done()
+			page := newSamplePage(n)
+			_, err := store.Create(page)
+			for k := 0; err == nil && k < 5; k++ {
+				page.Body = fmt.Sprintf("Modification #%d", k)
+				err = store.Update(page)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}(n)
+		go func() {
+			defer wait.Done()
+			ids, err := store.ListAll()
+			for k := 0; err == nil && k < len(ids); k++ {
+				_, err = store.Read(ids[k])
+				if _, ok := err.(wiki.UnexistentPageError); ok {
+					err = nil  // deleted meanwhile: not an error
+				}
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wait.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("concurrent access: %s", err)
+	}
+
+	ids, err := store.ListAll()
+	if err != nil {
+		t.Fatalf("PageStore.ListAll: %s", err)
+	}
+	if len(ids) != CONCURRENT_WRITERS {
+		t.Fatalf("PageStore.ListAll: expected %d pages, found %d", CONCURRENT_WRITERS, len(ids))
+	}
+	for _, id := range ids {
+		page := mustRead(t, store, id)
+		if page.Version != 6 || page.Body != "Modification #4" {
+			t.Fatalf("PageStore.Read(%q): unexpected page %+v", id, page)
+		}
+	}
+}
+
+// Writers updating the same version of a page must be serialized: only one of them wins
+func testConcurrentUpdates(t *testing.T, store wiki.PageStore) {
+	id := mustCreate(t, store, newSamplePage(1))
+
+	var wait sync.WaitGroup
+	errs := make(chan error, CONCURRENT_WRITERS)
+	for n := 0; n < CONCURRENT_WRITERS; n++ {
+		wait.Add(1)
+		go func(n int) {
+			defer wait.Done()
+			page := &wiki.Page{Id: id, Title: "Sample Page 1", Body: fmt.Sprintf("Modification by writer #%d", n), Version: 1}
+			errs <- store.Update(page)
+		}(n)
+	}
+	wait.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if _, ok := err.(wiki.ConflictError); !ok {
+			t.Fatalf("PageStore.Update(%q): expected nil or ConflictError, got %v", id, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("PageStore.Update(%q): %d concurrent updates of the same version succeeded, expected 1", id, succeeded)
+	}
+
+	page := mustRead(t, store, id)
+	if page.Version != 2 {
+		t.Fatalf("PageStore.Read(%q): expected version 2, found %d", id, page.Version)
+	}
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		t.Fatalf("PageStore.ListRevisions(%q): %s", id, err)
+	}
+	if len(revisions) != 2 || revisions[1].Body != page.Body {
+		t.Fatalf("PageStore.ListRevisions(%q): unexpected revisions after concurrent updates", id)
+	}
+}
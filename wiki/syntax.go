@@ -7,16 +7,24 @@ import (
 	"github.com/russross/blackfriday"
 	"github.com/microcosm-cc/bluemonday"
 	"strings"
+	"html"
+	"net/url"
 )
 
 var (
 	pageLinkPattern = regexp.MustCompile(`\[([^\[]+)\]( ?)\[([^\[]*)\]`)
+	attachmentUrlPattern = regexp.MustCompile(`(href|src)="` + ATTACHMENT_URL_SCHEME + `([^"]*)"`)
 )
 
+// Links and images can reference the attachments of the page being rendered with URLs like
+// attachment:screenshot.png, as in ![Screenshot](attachment:screenshot.png)
+const ATTACHMENT_URL_SCHEME = "attachment:"
+
 type SyntaxHandler interface {
 	BodyToEdit(body string) string
 	EditToBody(edit string) string
 	BodyToHtml(body string) template.HTML
+	PageToHtml(page *Page) template.HTML  // like BodyToHtml, resolving references to the page attachments
 }
 
 type markdownSyntax struct {
@@ -58,11 +66,30 @@ func (syntax *markdownSyntax) titleToPageId(linkStr string) string {
 }
 
 func (syntax *markdownSyntax) BodyToHtml(body string) template.HTML {
-	renderer, options := syntax.markdownParams()
-	unsafeHtml := string(blackfriday.MarkdownOptions([]byte(body), renderer, options))
+	unsafeHtml := syntax.renderMarkdown(body)
+	return template.HTML(sanitizePolicy().Sanitize(unsafeHtml))
+}
+
+func (syntax *markdownSyntax) PageToHtml(page *Page) template.HTML {
+	unsafeHtml := syntax.renderMarkdown(page.Body)
+	unsafeHtml = attachmentUrlPattern.ReplaceAllStringFunc(unsafeHtml, func(attr string) string {
+		submatches := attachmentUrlPattern.FindStringSubmatch(attr)
+		name := html.UnescapeString(submatches[2])
+		return fmt.Sprintf(`%s="%s"`, submatches[1], html.EscapeString(AttachmentUrl(page.Id, name)))
+	})
 	return template.HTML(sanitizePolicy().Sanitize(unsafeHtml))
 }
 
+func (syntax *markdownSyntax) renderMarkdown(body string) string {
+	renderer, options := syntax.markdownParams()
+	return string(blackfriday.MarkdownOptions([]byte(body), renderer, options))
+}
+
+// URL where an attachment of a page can be downloaded
+func AttachmentUrl(page PageId, name string) string {
+	return "/attachment/" + string(page) + "/" + url.PathEscape(name)
+}
+
 // FIXME: commonHtmlFlags and commonExtensions should be exported by blackfriday
 func (syntax *markdownSyntax) markdownParams() (blackfriday.Renderer, blackfriday.Options) {
 	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML |
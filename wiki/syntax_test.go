@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -102,6 +103,26 @@ func TestSyntaxPageLinkEditing(t *testing.T) {
 	}
 }
 
+func TestSyntaxPageLinkNormalizedTitle(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+	syntax := &markdownSyntax{store}
+
+	page := &Page{Title: "Go Tips", Body: "Some tips."}
+	_, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	obtained := syntax.EditToBody("See [go  tips][] and [these tips][GO TIPS].")
+	expected := fmt.Sprintf("See [%s][] and [these tips][%s].", page.Id, page.Id)
+	if obtained != expected {
+		t.Errorf("markdownSyntax.EditToBody: expected %q, obtained %q", expected, obtained)
+		return
+	}
+}
+
 func TestSyntaxPageLinkRendering(t *testing.T) {
 	store := setupPageStore()
 	defer cleanPageStore(store)
@@ -208,3 +229,29 @@ func escapeXml(in string) string {
 	}
 	return out.String()
 }
+
+func TestSyntaxAttachmentRendering(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+	syntax := &markdownSyntax{store}
+
+	page := &Page{Id: "abcdef", Body: "A screenshot: ![The screen](attachment:screen.png \"Screen\")\n\n" +
+		"And the [build log](attachment:build.log), [another][log] and [a web page](http://example.net/attachment:x).\n\n" +
+		"[log]: attachment:my%20file.log\n"}
+
+	obtained := string(syntax.PageToHtml(page))
+	expected := "<p>A screenshot: <img src=\"/attachment/abcdef/screen.png\" alt=\"The screen\" title=\"Screen\"/></p>\n\n" +
+		"<p>And the <a href=\"/attachment/abcdef/build.log\" rel=\"nofollow\">build log</a>, " +
+		"<a href=\"/attachment/abcdef/my%2520file.log\" rel=\"nofollow\">another</a> " +
+		"and <a href=\"http://example.net/attachment:x\" rel=\"nofollow\">a web page</a>.</p>\n"
+	if obtained != expected {
+		t.Errorf("markdownSyntax.PageToHtml: expected %q, obtained %q", expected, obtained)
+		return
+	}
+
+	obtained = string(syntax.BodyToHtml(page.Body))  // without a page, attachment references cannot be resolved
+	if strings.Contains(obtained, "attachment:screen.png") || strings.Contains(obtained, "/attachment/") {
+		t.Errorf("markdownSyntax.BodyToHtml: unexpected attachment reference in %q", obtained)
+		return
+	}
+}
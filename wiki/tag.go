@@ -0,0 +1,57 @@
+package wiki
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const TAG_SEPARATOR = ","
+
+// Tags are lowercase words joined by hyphens, made of letters, digits, '-', '_' and '.',
+// so that they can be used as they are in URLs
+func NormalizeTag(tag string) string {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return -1
+	}, tag)
+}
+
+// Returns the normalized tags, sorted and without duplicates or empty tags
+func NormalizeTags(tags []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Parses a comma-separated list of tags, as entered in the edit form
+func ParseTags(text string) []string {
+	return NormalizeTags(strings.Split(text, TAG_SEPARATOR))
+}
+
+func FormatTags(tags []string) string {
+	return strings.Join(tags, TAG_SEPARATOR+" ")
+}
+
+func sameTags(tags1, tags2 []string) bool {
+	if len(tags1) != len(tags2) {
+		return false
+	}
+	for k := range tags1 {
+		if tags1[k] != tags2[k] {
+			return false
+		}
+	}
+	return true
+}
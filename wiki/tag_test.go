@@ -0,0 +1,38 @@
+package wiki
+
+import (
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	for tag, expected := range map[string]string{
+		"go": "go",
+		" Go ": "go",
+		"Web  Development": "web-development",
+		"c++/c#": "cc",
+		"v1.2_beta": "v1.2_beta",
+		"Ñandú": "ñandú",
+		"?!": "",
+	} {
+		found := NormalizeTag(tag)
+		if found != expected {
+			t.Errorf("NormalizeTag(%q): expected %q, found %q", tag, expected, found)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags := ParseTags("Web, go,  ,GO, web development")
+	expected := []string{"go", "web", "web-development"}
+	if !sameTags(tags, expected) {
+		t.Errorf("ParseTags: expected %q, found %q", expected, tags)
+	}
+
+	if tags := ParseTags(""); len(tags) != 0 {
+		t.Errorf("ParseTags(\"\"): expected no tags, found %q", tags)
+	}
+
+	if text := FormatTags(expected); text != "go, web, web-development" {
+		t.Errorf("FormatTags(%q): unexpected %q", expected, text)
+	}
+}
@@ -0,0 +1,78 @@
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Set of transformations applied to titles before comparing them, when finding pages
+// by title, resolving page links and checking that titles are unique
+type TitleNormalization int
+
+const (
+	FOLD_CASE TitleNormalization = 1 << iota
+	UNICODE_NFC
+	COLLAPSE_SPACES
+	EXACT_TITLES TitleNormalization = 0
+	DEFAULT_TITLE_NORMALIZATION = FOLD_CASE | UNICODE_NFC | COLLAPSE_SPACES
+)
+
+var titleNormalizationNames = []struct {
+	name string
+	flag TitleNormalization
+}{
+	{"fold", FOLD_CASE},
+	{"nfc", UNICODE_NFC},
+	{"spaces", COLLAPSE_SPACES},
+}
+
+func (normalization TitleNormalization) Normalize(title string) string {
+	if normalization&UNICODE_NFC != 0 {
+		title = norm.NFC.String(title)
+	}
+	if normalization&FOLD_CASE != 0 {
+		title = cases.Fold().String(title)
+	}
+	if normalization&COLLAPSE_SPACES != 0 {
+		title = strings.Join(strings.Fields(title), " ")
+	}
+	return title
+}
+
+// Returns a comma-separated list of normalization names, as accepted by ParseTitleNormalization
+func (normalization TitleNormalization) String() string {
+	var names []string
+	for _, entry := range titleNormalizationNames {
+		if normalization&entry.flag != 0 {
+			names = append(names, entry.name)
+		}
+	}
+	if len(names) == 0 {
+		return "exact"
+	}
+	return strings.Join(names, ",")
+}
+
+// Parses a comma-separated list of normalizations ("fold", "nfc" and "spaces"), or "exact" for none
+func ParseTitleNormalization(names string) (TitleNormalization, error) {
+	normalization := EXACT_TITLES
+	if names == "exact" {
+		return normalization, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		found := false
+		for _, entry := range titleNormalizationNames {
+			if strings.TrimSpace(name) == entry.name {
+				normalization |= entry.flag
+				found = true
+			}
+		}
+		if !found {
+			return normalization, fmt.Errorf("unknown title normalization %q", name)
+		}
+	}
+	return normalization, nil
+}
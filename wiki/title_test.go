@@ -0,0 +1,80 @@
+package wiki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTitleNormalization(t *testing.T) {
+	cases := []struct {
+		normalization TitleNormalization
+		title string
+		expected string
+	}{
+		{DEFAULT_TITLE_NORMALIZATION, "  Go   Tips\t", "go tips"},
+		{DEFAULT_TITLE_NORMALIZATION, "Café Straße", "café strasse"},
+		{FOLD_CASE, "  Go   Tips", "  go   tips"},
+		{COLLAPSE_SPACES, "  Go   Tips", "Go Tips"},
+		{UNICODE_NFC, "Café", "Café"},
+		{EXACT_TITLES, " Go Tips ", " Go Tips "},
+	}
+
+	for _, c := range cases {
+		obtained := c.normalization.Normalize(c.title)
+		if obtained != c.expected {
+			t.Errorf("TitleNormalization(%s).Normalize(%q): expected %q, obtained %q", c.normalization, c.title, c.expected, obtained)
+		}
+	}
+}
+
+func TestParseTitleNormalization(t *testing.T) {
+	for _, normalization := range []TitleNormalization{EXACT_TITLES, FOLD_CASE | COLLAPSE_SPACES, DEFAULT_TITLE_NORMALIZATION} {
+		parsed, err := ParseTitleNormalization(normalization.String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if parsed != normalization {
+			t.Errorf("ParseTitleNormalization(%q): expected %d, obtained %d", normalization.String(), normalization, parsed)
+			return
+		}
+	}
+
+	_, err := ParseTitleNormalization("fold,unknown")
+	if err == nil {
+		t.Error("ParseTitleNormalization: an error was expected")
+		return
+	}
+}
+
+func TestStoresMatchTitlesWithTheirNormalization(t *testing.T) {
+	keys, err := ParseKeyRing(strings.NewReader(mustNewKeyLine(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact := NewMemoryStoreWith(EXACT_TITLES)
+	encrypted, err := NewEncryptedStore(NewMemoryStoreWith(EXACT_TITLES), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]PageStore{"memory": exact, "encrypted": encrypted} {
+		if store.TitleMatching() != EXACT_TITLES {
+			t.Errorf("%s store: expected exact titles, found %s", name, store.TitleMatching())
+		}
+		mustCreateChild(t, store, "Go Tips", "")
+		second := mustCreateChild(t, store, "go tips", "")
+		if found, _ := store.FindByTitle("go tips"); found != second {
+			t.Errorf("%s store: expected %q for %q, found %q", name, second, "go tips", found)
+		}
+
+		duplicates, err := FindDuplicateTitles(store)
+		if err != nil || len(duplicates) != 0 {
+			t.Errorf("FindDuplicateTitles: expected no duplicates among exact titles, found %v (%v)", duplicates, err)
+		}
+	}
+
+	if NewMemoryStore().TitleMatching() != DEFAULT_TITLE_NORMALIZATION {
+		t.Errorf("NewMemoryStore: expected the default title normalization")
+	}
+}
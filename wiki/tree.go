@@ -0,0 +1,73 @@
+package wiki
+
+// Returns the ancestors of a page, from the top-level page down to its parent
+func FindAncestors(store PageStore, id PageId) ([]*Page, error) {
+	page, err := store.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ancestors []*Page
+	visited := map[PageId]bool{id: true}
+	for parent := page.Parent; parent != "" && !visited[parent]; parent = page.Parent {
+		visited[parent] = true
+		page, err = store.Read(parent)
+		if _, ok := err.(UnexistentPageError); ok {  // deleted meanwhile
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ancestors = append([]*Page{page}, ancestors...)
+	}
+	return ancestors, nil
+}
+
+// Moves a page and all its descendants to the trash, children first. Returns the number of deleted pages.
+func DeleteSubtree(store PageStore, id PageId) (int, error) {
+	children, err := store.FindChildren(id)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, child := range children {
+		count, err := DeleteSubtree(store, child)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	err = store.Delete(id)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted + 1, nil
+}
+
+// Moves the children of a page to its parent, or to the top level, so that the page can be deleted
+func MoveChildren(store PageStore, id PageId, author string) error {
+	page, err := store.Read(id)
+	if err != nil {
+		return err
+	}
+	children, err := store.FindChildren(id)
+	if err != nil {
+		return err
+	}
+
+	for _, childId := range children {
+		child, err := store.Read(childId)
+		if err != nil {
+			return err
+		}
+		child.Parent = page.Parent
+		child.Author = author
+		err = store.Update(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
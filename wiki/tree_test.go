@@ -0,0 +1,75 @@
+package wiki
+
+import (
+	"testing"
+)
+
+// Creates a page under the given parent in a memory store
+func mustCreateChild(t *testing.T, store PageStore, title string, parent PageId) PageId {
+	id, err := store.Create(&Page{Title: title, Body: "Body of " + title, Parent: parent})
+	if err != nil {
+		t.Fatalf("PageStore.Create(%q): %s", title, err)
+	}
+	return id
+}
+
+func TestFindAncestors(t *testing.T) {
+	store := NewMemoryStore()
+	root := mustCreateChild(t, store, "Root", "")
+	child := mustCreateChild(t, store, "Child", root)
+	grandchild := mustCreateChild(t, store, "Grandchild", child)
+
+	ancestors, err := FindAncestors(store, grandchild)
+	if err != nil {
+		t.Fatalf("FindAncestors(%q): %s", grandchild, err)
+	}
+	if len(ancestors) != 2 || ancestors[0].Id != root || ancestors[1].Id != child {
+		t.Errorf("FindAncestors(%q): expected [%s %s], found %+v", grandchild, root, child, ancestors)
+	}
+
+	ancestors, err = FindAncestors(store, root)
+	if err != nil || len(ancestors) != 0 {
+		t.Errorf("FindAncestors(%q): expected no ancestors, found %+v (%v)", root, ancestors, err)
+	}
+}
+
+func TestDeleteSubtree(t *testing.T) {
+	store := NewMemoryStore()
+	root := mustCreateChild(t, store, "Root", "")
+	child := mustCreateChild(t, store, "Child", root)
+	mustCreateChild(t, store, "Grandchild", child)
+	other := mustCreateChild(t, store, "Other", "")
+
+	deleted, err := DeleteSubtree(store, root)
+	if err != nil || deleted != 3 {
+		t.Fatalf("DeleteSubtree(%q): expected 3 pages deleted, deleted %d (%v)", root, deleted, err)
+	}
+	ids, _ := store.ListAll()
+	if len(ids) != 1 || ids[0] != other {
+		t.Errorf("DeleteSubtree(%q): expected only %q to be left, found %v", root, other, ids)
+	}
+}
+
+func TestMoveChildren(t *testing.T) {
+	store := NewMemoryStore()
+	root := mustCreateChild(t, store, "Root", "")
+	child := mustCreateChild(t, store, "Child", root)
+	grandchild1 := mustCreateChild(t, store, "Grandchild 1", child)
+	grandchild2 := mustCreateChild(t, store, "Grandchild 2", child)
+
+	err := MoveChildren(store, child, "tester")
+	if err != nil {
+		t.Fatalf("MoveChildren(%q): %s", child, err)
+	}
+	for _, id := range []PageId{grandchild1, grandchild2} {
+		page, _ := store.Read(id)
+		if page.Parent != root || page.Author != "tester" {
+			t.Errorf("MoveChildren(%q): expected page %q under %q, found parent %q by %q", child, id, root, page.Parent, page.Author)
+		}
+	}
+
+	err = store.Delete(child)
+	if err != nil {
+		t.Errorf("PageStore.Delete(%q): %s", child, err)
+	}
+}
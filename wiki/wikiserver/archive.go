@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Writes all the pages of the store, with their history and attachments, into an archive,
+// which the import command restores into any store
+func exportArchive(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("output", "", "archive to write (- for the standard output)")
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	if *output == "" {
+		return fmt.Errorf("missing -output archive")
+	}
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+	attachments, err := storeFlags.openAttachments()
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = os.Stdout
+	var file *os.File
+	if *output != "-" {
+		file, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		writer = file
+	}
+
+	report, err := wiki.ExportArchive(store, attachments, writer)
+	if file != nil {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d pages and %d attachments exported\n", report.Pages, report.Attachments)
+	return nil
+}
+
+// Imports the pages of an archive written by the export command, with their history and attachments
+func importArchive(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	input := flags.String("input", "", "archive to read (- for the standard input)")
+	onConflict := flags.String("on-conflict", "fail", "what to do with pages already in the store: fail, skip or replace")
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("missing -input archive")
+	}
+	policy, err := wiki.ParseImportPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+	attachments, err := storeFlags.openAttachments()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	report, err := wiki.ImportArchive(store, attachments, reader, policy)
+	if report != nil {
+		fmt.Printf("%d pages imported (%d replaced, %d skipped), %d attachments imported\n",
+			report.Pages, report.Replaced, report.Skipped, report.Attachments)
+	}
+	return err
+}
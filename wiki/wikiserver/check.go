@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+)
+
+// Checks the storage directory of a disk store for damaged and stray files, dangling references and
+// duplicate titles, and quarantines or repairs what can be, as the mode says
+func checkStorage(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	modeName := flags.String("mode", "report", "what to do about the problems found: report, quarantine or repair")
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	mode, err := wiki.ParseCheckMode(*modeName)
+	if err != nil {
+		return err
+	}
+	if *storeFlags.kind != "disk" {
+		return fmt.Errorf("only disk stores can be checked")
+	}
+	normalization, err := wiki.ParseTitleNormalization(*storeFlags.titleMatching)
+	if err != nil {
+		return err
+	}
+
+	report, err := wiki.Check(*storeFlags.storageDir, mode, normalization)
+	if report == nil {
+		return err
+	}
+	unfixed := 0
+	for _, problem := range report.Problems {
+		fmt.Printf("%s: %s: %s\n", problem.Filename, problem.Kind, problem.Detail)
+		if problem.Fix != "" {
+			fmt.Printf("\t%s\n", problem.Fix)
+		} else {
+			unfixed++
+		}
+	}
+	fmt.Printf("%d pages checked, %d problems found, %d fixed\n", report.Pages, len(report.Problems), len(report.Problems)-unfixed)
+	if err == nil && unfixed > 0 {
+		err = fmt.Errorf("%d problems left", unfixed)
+	}
+	return err
+}
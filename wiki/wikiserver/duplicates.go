@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+	"sort"
+)
+
+// Reports the titles shared by several pages, written before titles were unique,
+// so that they can be renamed by hand
+func reportDuplicates(args []string) error {
+	flags := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+
+	duplicates, err := wiki.FindDuplicateTitles(store)
+	if err != nil {
+		return err
+	}
+
+	titles := make([]string, 0, len(duplicates))
+	for title := range duplicates {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	for _, title := range titles {
+		fmt.Printf("%q:", title)
+		for _, id := range duplicates[title] {
+			fmt.Printf(" %s", id)
+		}
+		fmt.Println()
+	}
+
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%d duplicated titles found", len(duplicates))
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+)
+
+// Adds a new key to the key file, creating it if needed, and re-encrypts all the pages with it,
+// including those kept in clear. Servers must be restarted to load the new key.
+func rotateKey(args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	if *storeFlags.keyFile == "" {
+		return fmt.Errorf("missing -key-file")
+	}
+
+	store, err := storeFlags.openUnencrypted()
+	if err != nil {
+		return err
+	}
+	id, err := wiki.AddKey(*storeFlags.keyFile)
+	if err != nil {
+		return err
+	}
+	keys, err := wiki.LoadKeyRing(*storeFlags.keyFile)
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := wiki.RotateKeys(store, keys)
+	fmt.Printf("Key %s added, %d pages re-encrypted with it\n", id, rewritten)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Previous keys can be removed from the key file once the pages in the trash are restored (and the key rotated again) or purged")
+	return nil
+}
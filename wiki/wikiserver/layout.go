@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+)
+
+// Moves the pages of a disk store to the places of another layout, in the same storage directory, as in
+//
+//	wikiserver migrate-layout -storage=data/wiki/pages -to=sharded
+//
+// The wiki must be stopped meanwhile. An interrupted migration is resumed by running it again.
+func migrateLayout(args []string) error {
+	flags := flag.NewFlagSet("migrate-layout", flag.ExitOnError)
+	storageDir := flags.String("storage", DEFAULT_STORAGE_DIR, "storage directory for wiki pages")
+	to := flags.String("to", wiki.SHARDED_LAYOUT.String(), "layout to move the pages to: flat or sharded")
+	flags.Parse(args)
+
+	layout, err := wiki.ParseDiskLayout(*to)
+	if err != nil {
+		return err
+	}
+
+	moved, err := wiki.MigrateDiskLayout(*storageDir, layout)
+	fmt.Printf("%d page files and histories moved\n", moved)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The storage directory has the %s layout\n", layout)
+	return nil
+}
@@ -4,26 +4,80 @@ import (
 	"github.com/joansais/go-practices/wiki"
 	"log"
 	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 )
 
 const (
 	DEFAULT_ADDR = ":8080"
 	DEFAULT_ASSETS_DIR = "assets/wiki"
-	DEFAULT_STORAGE_DIR = "data/wiki/pages"
+	DEFAULT_COMMAND = "serve"
+	DEFAULT_TRASH_RETENTION = 30 * 24 * time.Hour
 )
 
+// Run as "wikiserver <command> [flags]". Without a command, the wiki is served.
+var commands = map[string]func(args []string) error{
+	"serve": serve,
+	"duplicates": reportDuplicates,
+	"purge-trash": purgeTrash,
+	"migrate": migrate,
+	"export": exportArchive,
+	"import": importArchive,
+	"import-mediawiki": importMediaWiki,
+	"export-static": exportStatic,
+	"fsck": checkStorage,
+	"rotate-key": rotateKey,
+	"migrate-layout": migrateLayout,
+}
+
 func main() {
-	addr := flag.String("addr", DEFAULT_ADDR, "network address to listen on")
-	assetsDir := flag.String("assets", DEFAULT_ASSETS_DIR, "location of HTML templates")
-	storageDir := flag.String("storage", DEFAULT_STORAGE_DIR, "storage directory for wiki pages")
-	flag.Parse()
+	name, args := DEFAULT_COMMAND, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	command, found := commands[name]
+	if !found {
+		log.Fatalf("Unknown command %q", name)
+		return
+	}
+
+	err := command(args)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+}
+
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", DEFAULT_ADDR, "network address to listen on")
+	assetsDir := flags.String("assets", DEFAULT_ASSETS_DIR, "location of HTML templates")
+	trashRetention := flags.Duration("trash-retention", DEFAULT_TRASH_RETENTION, "how long deleted pages are kept in the trash (0 to keep them until purged by hand)")
+	userHeader := flags.String("user-header", "", "request header with the authenticated user, set by a trusted proxy (none to record the addresses of editors)")
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return fmt.Errorf("Error opening page store: %s", err)
+	}
+
+	attachments, err := storeFlags.openAttachments()
+	if err != nil {
+		return fmt.Errorf("Error opening attachment store: %s", err)
+	}
 
-	store := wiki.NewDiskStore(*storageDir)
 	syntax := wiki.NewMarkdownSyntax(store)
 	server := wiki.NewServer(store, syntax, *assetsDir)
-	err := server.Start(*addr)
+	server.KeepTrashFor(*trashRetention)
+	server.TrustUserHeader(*userHeader)
+	server.StoreAttachmentsIn(attachments)
+	err = server.Start(*addr)
 	if err != nil {
-		log.Fatal("Error starting server: ", err)
-		return
+		return fmt.Errorf("Error starting server: %s", err)
 	}
+	return nil
 }
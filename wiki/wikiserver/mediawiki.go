@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Imports the pages of a MediaWiki XML dump, converting their wikitext into Markdown, and lists
+// the pages that were not imported or not converted cleanly
+func importMediaWiki(args []string) error {
+	flags := flag.NewFlagSet("import-mediawiki", flag.ExitOnError)
+	input := flags.String("input", "", "MediaWiki XML dump to read (- for the standard input)")
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("missing -input dump")
+	}
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	report, err := wiki.ImportMediaWiki(store, reader)
+	if report != nil {
+		for _, issue := range report.Issues {
+			fmt.Printf("%q %s\n", issue.Title, issue.Id)
+			fmt.Printf("\t%s\n", strings.Join(issue.Problems, "\n\t"))
+		}
+		fmt.Printf("%d pages imported, %d with problems; %d redirects and %d pages outside the main namespace skipped\n",
+			report.Imported, len(report.Issues), report.Redirects, report.Skipped)
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Copies all the pages of a store into another one, preserving their ids, metadata and history, as in
+//
+//	wikiserver migrate -from=disk:data/wiki/pages -to=sql:data/wiki/pages.db
+//
+// Stores are given as kind:location, where the location is the storage directory of a disk store, the
+// data source name of a SQL store, or the JSON snapshot of a memory store. An interrupted migration is
+// resumed by running it again. Attachments and pages in the trash are not migrated.
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "store to copy the pages from, as kind:location")
+	to := flags.String("to", "", "store to copy the pages to, as kind:location")
+	titleMatching := flags.String("title-matching", wiki.DEFAULT_TITLE_NORMALIZATION.String(),
+		"normalizations applied when matching titles: exact, or any of fold, nfc and spaces (comma-separated)")
+	flags.Parse(args)
+
+	normalization, err := wiki.ParseTitleNormalization(*titleMatching)
+	if err != nil {
+		return err
+	}
+
+	fromStore, err := openStoreSpec(*from, normalization)
+	if err != nil {
+		return fmt.Errorf("Error opening source store: %s", err)
+	}
+	toStore, err := openStoreSpec(*to, normalization)
+	if err != nil {
+		return fmt.Errorf("Error opening target store: %s", err)
+	}
+
+	report, err := wiki.MigratePages(fromStore, toStore)
+	if report != nil {
+		fmt.Printf("%d pages copied, %d already copied\n", report.Copied, report.Skipped)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("All pages verified")
+	return nil
+}
+
+// Opens a store given as kind:location
+func openStoreSpec(spec string, normalization wiki.TitleNormalization) (wiki.PageStore, error) {
+	kind, location, found := strings.Cut(spec, ":")
+	if !found && kind != "memory" {
+		return nil, fmt.Errorf("invalid store %q: expected kind:location", spec)
+	}
+
+	switch kind {
+	case "disk":
+		return openStore(kind, location, wiki.FLAT_LAYOUT, "", "", normalization)
+	case "sql":
+		return openStore(kind, "", wiki.FLAT_LAYOUT, location, "", normalization)
+	default:
+		return openStore(kind, "", wiki.FLAT_LAYOUT, "", location, normalization)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+)
+
+// Renders the pages into a directory of HTML files, as a read-only copy of the wiki that any file server can publish
+func exportStatic(args []string) error {
+	flags := flag.NewFlagSet("export-static", flag.ExitOnError)
+	out := flags.String("out", "", "directory to write the HTML files into")
+	assetsDir := flags.String("assets", DEFAULT_ASSETS_DIR, "location of HTML templates")
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("missing -out directory")
+	}
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+	attachments, err := storeFlags.openAttachments()
+	if err != nil {
+		return err
+	}
+
+	server := wiki.NewServer(store, wiki.NewMarkdownSyntax(store), *assetsDir)
+	server.StoreAttachmentsIn(attachments)
+	report, err := server.ExportStatic(*out)
+	if report != nil {
+		fmt.Printf("%d pages and %d attachments exported\n", report.Pages, report.Attachments)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"github.com/joansais/go-practices/wikisql"
+	_ "modernc.org/sqlite"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	DEFAULT_STORAGE_DIR = "data/wiki/pages"
+	DEFAULT_ATTACHMENTS_DIR = "data/wiki/attachments"
+	DEFAULT_STORE = "disk"
+	SQL_DRIVER = "sqlite"
+)
+
+// Flags selecting the page store, shared by all commands
+type storeFlags struct {
+	kind		*string
+	storageDir	*string
+	layout		*string
+	dsn			*string
+	seed		*string
+	titleMatching	*string
+	attachmentsDir	*string
+	keyFile			*string
+}
+
+func addStoreFlags(flags *flag.FlagSet) *storeFlags {
+	return &storeFlags{
+		kind: flags.String("store", DEFAULT_STORE, "page store: disk, sql or memory"),
+		storageDir: flags.String("storage", DEFAULT_STORAGE_DIR, "storage directory for wiki pages, when -store=disk"),
+		layout: flags.String("layout", wiki.FLAT_LAYOUT.String(),
+			"layout of a storage directory without pages yet: flat, or sharded for large wikis; see migrate-layout for the others"),
+		dsn: flags.String("dsn", "", "data source name of the SQL database, when -store=sql"),
+		seed: flags.String("seed", "", "JSON snapshot with the initial pages, when -store=memory"),
+		titleMatching: flags.String("title-matching", wiki.DEFAULT_TITLE_NORMALIZATION.String(),
+			"normalizations applied when matching titles: exact, or any of fold, nfc and spaces (comma-separated)"),
+		attachmentsDir: flags.String("attachments", DEFAULT_ATTACHMENTS_DIR, "storage directory for page attachments, unless -store=memory"),
+		keyFile: flags.String("key-file", "", "file with the keys to encrypt page titles and bodies with (none to keep them in clear); "+
+			"only one server may use an encrypted store, as titles are indexed in memory")}
+}
+
+// Opens the page store, encrypted with the keys of the key file, if any. Encrypted stores keep the index
+// of their titles in memory, so no other process may write to them while they are open.
+func (storeFlags *storeFlags) open() (wiki.PageStore, error) {
+	store, err := storeFlags.openUnencrypted()
+	if err != nil || *storeFlags.keyFile == "" {
+		return store, err
+	}
+
+	keys, err := wiki.LoadKeyRing(*storeFlags.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return wiki.NewEncryptedStore(store, keys)
+}
+
+func (storeFlags *storeFlags) openUnencrypted() (wiki.PageStore, error) {
+	normalization, err := wiki.ParseTitleNormalization(*storeFlags.titleMatching)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := wiki.ParseDiskLayout(*storeFlags.layout)
+	if err != nil {
+		return nil, err
+	}
+
+	return openStore(*storeFlags.kind, *storeFlags.storageDir, layout, *storeFlags.dsn, *storeFlags.seed, normalization)
+}
+
+// Attachments are kept in memory along with the pages of a memory store, and on disk otherwise
+func (storeFlags *storeFlags) openAttachments() (wiki.AttachmentStore, error) {
+	if *storeFlags.kind == "memory" {
+		return wiki.NewMemoryAttachmentStore(), nil
+	}
+	return wiki.NewDiskAttachmentStore(*storeFlags.attachmentsDir)
+}
+
+func openStore(kind, storageDir string, layout wiki.DiskLayout, dsn, seed string,
+		normalization wiki.TitleNormalization) (wiki.PageStore, error) {
+	switch kind {
+	case "disk":
+		return wiki.NewDiskStoreWith(storageDir, layout, normalization)
+	case "sql":
+		return wikisql.Open(SQL_DRIVER, dsn, normalization)
+	case "memory":
+		if seed == "" {
+			return wiki.NewMemoryStoreWith(normalization), nil
+		}
+		file, err := os.Open(seed)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return wiki.LoadMemoryStore(file, normalization)
+	default:
+		return nil, fmt.Errorf("unknown page store %q", kind)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/joansais/go-practices/wiki"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Purges the pages that have been in the trash for longer than the given duration (the default retention
+// period of the server, unless given), together with their attachments; for instance from a periodic job,
+// when the server has no retention period
+func purgeTrash(args []string) error {
+	flags := flag.NewFlagSet("purge-trash", flag.ExitOnError)
+	olderThan := flags.Duration("older-than", DEFAULT_TRASH_RETENTION, "purge only the pages deleted longer ago than this (0 to purge them all)")
+	storeFlags := addStoreFlags(flags)
+	flags.Parse(args)
+
+	store, err := storeFlags.open()
+	if err != nil {
+		return err
+	}
+
+	attachments, err := storeFlags.openAttachments()
+	if err != nil {
+		return err
+	}
+
+	purged, err := wiki.PurgeTrashWithAttachments(store, attachments, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d pages purged\n", purged)
+	return nil
+}
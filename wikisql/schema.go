@@ -0,0 +1,154 @@
+package wikisql
+
+import (
+	"database/sql"
+	"github.com/joansais/go-practices/wiki"
+)
+
+// Schema migrations, applied in order. Once released, a migration must never be modified:
+// schema changes are made by appending new migrations.
+var migrations = []string{
+	`CREATE TABLE pages (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		body TEXT NOT NULL,
+		version INTEGER NOT NULL
+	);
+	CREATE INDEX pages_title ON pages (title);
+	CREATE TABLE revisions (
+		page_id TEXT NOT NULL,
+		number INTEGER NOT NULL,
+		timestamp INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		body TEXT NOT NULL,
+		PRIMARY KEY (page_id, number)
+	);`,
+	`ALTER TABLE pages ADD COLUMN title_key TEXT NOT NULL DEFAULT '';
+	CREATE INDEX pages_title_key ON pages (title_key);
+	CREATE TABLE settings (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`,
+	`CREATE TABLE trash (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		body TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		deleted INTEGER NOT NULL
+	);`,
+	`ALTER TABLE pages ADD COLUMN created INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE pages ADD COLUMN modified INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE pages ADD COLUMN author TEXT NOT NULL DEFAULT '';
+	UPDATE pages SET
+		created = COALESCE((SELECT MIN(timestamp) FROM revisions WHERE page_id = pages.id), 0),
+		modified = COALESCE((SELECT MAX(timestamp) FROM revisions WHERE page_id = pages.id), 0);
+	ALTER TABLE trash ADD COLUMN created INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE trash ADD COLUMN modified INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE trash ADD COLUMN author TEXT NOT NULL DEFAULT '';
+	UPDATE trash SET
+		created = COALESCE((SELECT MIN(timestamp) FROM revisions WHERE page_id = trash.id), 0),
+		modified = COALESCE((SELECT MAX(timestamp) FROM revisions WHERE page_id = trash.id), 0);
+	ALTER TABLE revisions ADD COLUMN author TEXT NOT NULL DEFAULT '';`,
+	`CREATE TABLE tags (
+		page_id TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (page_id, tag)
+	);
+	CREATE INDEX tags_tag ON tags (tag);`,
+	`ALTER TABLE pages ADD COLUMN parent TEXT NOT NULL DEFAULT '';
+	CREATE INDEX pages_parent ON pages (parent);
+	ALTER TABLE trash ADD COLUMN parent TEXT NOT NULL DEFAULT '';`,
+}
+
+// Brings the database schema up to date, recording the applied migrations in schema_version
+func migrate(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[version])
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_version (version) VALUES (?)", version+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Recomputes the title keys (normalized titles) if they were computed with another normalization
+func reindexTitles(db *sql.DB, normalization wiki.TitleNormalization) error {
+	var current string
+	err := db.QueryRow("SELECT value FROM settings WHERE name = 'title_normalization'").Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && current == normalization.String() {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()  // no effect after commit
+
+	rows, err := tx.Query("SELECT id, title FROM pages")
+	if err != nil {
+		return err
+	}
+	titles := make(map[wiki.PageId]string)
+	for rows.Next() {
+		var id wiki.PageId
+		var title string
+		err = rows.Scan(&id, &title)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		titles[id] = title
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, title := range titles {
+		_, err = tx.Exec("UPDATE pages SET title_key = ? WHERE id = ?", normalization.Normalize(title), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM settings WHERE name = 'title_normalization'")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO settings (name, value) VALUES ('title_normalization', ?)", normalization.String())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
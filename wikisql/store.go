@@ -0,0 +1,716 @@
+package wikisql
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+	"github.com/joansais/go-practices/wiki"
+)
+
+// A wiki.PageStore backed by a SQL database, accessed through database/sql.
+// Statements use '?' placeholders, as supported by SQLite and MySQL drivers.
+type DbPageStore struct {
+	db *sql.DB
+	normalization wiki.TitleNormalization
+}
+
+// Opens a store over the given database, creating or migrating its schema as needed. Titles are matched
+// with the given normalization; the titles of the database are reindexed if it was used with another one.
+func NewDbPageStore(db *sql.DB, normalization wiki.TitleNormalization) (*DbPageStore, error) {
+	err := migrate(db)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &DbPageStore{db: db, normalization: normalization}
+	err = reindexTitles(db, store.normalization)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// How long SQLite waits for the writes of other processes before failing with SQLITE_BUSY
+const SQLITE_BUSY_TIMEOUT = 10 * time.Second
+
+// Opens a database with the given driver and data source name, and a store over it. SQLite databases
+// are given a single connection, since they take one writer at a time.
+func Open(driverName, dataSourceName string, normalization wiki.TitleNormalization) (*DbPageStore, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if driverName == "sqlite" || driverName == "sqlite3" {
+		err = configureSqlite(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	store, err := NewDbPageStore(db, normalization)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Serializes the statements of this process, which would otherwise fail with SQLITE_BUSY instead of
+// waiting for each other, and makes them wait for those of other processes
+func configureSqlite(db *sql.DB) error {
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)  // keeping the connection, and so the busy timeout set on it
+	_, err := db.Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", SQLITE_BUSY_TIMEOUT.Milliseconds()))
+	return err
+}
+
+func (store *DbPageStore) Close() error {
+	return store.db.Close()
+}
+
+func (store *DbPageStore) Create(page *wiki.Page) (wiki.PageId, error) {
+	id, err := wiki.NewPageId()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().Round(0)  // without monotonic clock reading, as when read back
+	tags := wiki.NormalizeTags(page.Tags)
+	err = store.inTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO pages (id, title, title_key, body, version, created, modified, author, parent) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			id, page.Title, store.titleKey(page.Title), page.Body, 1, now.UnixNano(), now.UnixNano(), page.Author, page.Parent)
+		if err != nil {
+			return err
+		}
+		err = store.checkUniqueTitle(tx, id, page.Title)
+		if err != nil {
+			return err
+		}
+		err = checkParent(tx, id, page.Parent)
+		if parentErr, ok := err.(wiki.InvalidParentError); ok {
+			parentErr.Id = ""  // the page is not created
+			return parentErr
+		}
+		if err != nil {
+			return err
+		}
+		err = writeTags(tx, id, tags)
+		if err != nil {
+			return err
+		}
+		return insertRevision(tx, id, 1, page, now)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	page.Id = id
+	page.Version = 1
+	page.Tags = tags
+	page.Created, page.Modified = now, now
+	return id, nil
+}
+
+func (store *DbPageStore) Read(id wiki.PageId) (*wiki.Page, error) {
+	return readPage(store.db, id)
+}
+
+func (store *DbPageStore) Update(page *wiki.Page) error {
+	now := time.Now().Round(0)
+	tags := wiki.NormalizeTags(page.Tags)
+	var created int64
+	err := store.inTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE pages SET title = ?, title_key = ?, body = ?, version = version + 1, modified = ?, author = ?, parent = ? WHERE id = ? AND version = ?",
+			page.Title, store.titleKey(page.Title), page.Body, now.UnixNano(), page.Author, page.Parent, page.Id, page.Version)
+		if err != nil {
+			return err
+		}
+
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {  // either the page does not exist or its version has changed
+			current, err := readPage(tx, page.Id)
+			if err != nil {
+				return err
+			}
+			return wiki.ConflictError{Current: current, Rejected: page}
+		}
+
+		var last int
+		var previousTitle string
+		err = tx.QueryRow("SELECT number, title FROM revisions WHERE page_id = ? ORDER BY number DESC LIMIT 1", page.Id).
+			Scan(&last, &previousTitle)
+		if err != nil {
+			return err
+		}
+		if store.titleKey(page.Title) != store.titleKey(previousTitle) {  // pages sharing their title since before titles were unique can still be edited
+			err = store.checkUniqueTitle(tx, page.Id, page.Title)
+			if err != nil {
+				return err
+			}
+		}
+		err = checkParent(tx, page.Id, page.Parent)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow("SELECT created FROM pages WHERE id = ?", page.Id).Scan(&created)
+		if err != nil {
+			return err
+		}
+		err = writeTags(tx, page.Id, tags)
+		if err != nil {
+			return err
+		}
+		return insertRevision(tx, page.Id, last+1, page, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	page.Version++
+	page.Tags = tags
+	page.Created, page.Modified = unixTime(created), now
+	return nil
+}
+
+func (store *DbPageStore) Import(page *wiki.Page, revisions []*wiki.Revision) error {
+	if !wiki.ValidPageId(page.Id) {
+		return wiki.InvalidPageIdError{Id: page.Id}
+	}
+
+	tags := wiki.NormalizeTags(page.Tags)
+	err := store.inTransaction(func(tx *sql.Tx) error {
+		// a page in the trash with the same id is replaced too, since trashed pages share the revisions and tags tables
+		for _, statement := range []string{"DELETE FROM pages WHERE id = ?", "DELETE FROM trash WHERE id = ?", "DELETE FROM revisions WHERE page_id = ?"} {
+			_, err := tx.Exec(statement, page.Id)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec("INSERT INTO pages (id, title, title_key, body, version, created, modified, author, parent) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			page.Id, page.Title, store.titleKey(page.Title), page.Body, page.Version, unixNanos(page.Created), unixNanos(page.Modified), page.Author, page.Parent)
+		if err != nil {
+			return err
+		}
+		err = store.checkUniqueTitle(tx, page.Id, page.Title)
+		if err != nil {
+			return err
+		}
+		err = checkParent(tx, page.Id, page.Parent)
+		if err != nil {
+			return err
+		}
+		err = writeTags(tx, page.Id, tags)
+		if err != nil {
+			return err
+		}
+
+		for _, revision := range revisions {
+			err = insertRevision(tx, page.Id, revision.Number, &wiki.Page{Title: revision.Title, Body: revision.Body, Author: revision.Author}, revision.Timestamp)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	page.Tags = tags
+	return nil
+}
+
+// Deleted pages are moved to the trash table, keeping their revisions
+func (store *DbPageStore) Delete(id wiki.PageId) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO trash (id, title, body, version, created, modified, author, parent, deleted) "+
+			"SELECT id, title, body, version, created, modified, author, parent, ? FROM pages WHERE id = ?",
+			time.Now().UnixNano(), id)
+		if err != nil {
+			return err
+		}
+
+		children, err := queryPageIds(tx, "SELECT id FROM pages WHERE parent = ? ORDER BY id", id)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return wiki.HasChildrenError{Id: id, Children: children}
+		}
+
+		result, err := tx.Exec("DELETE FROM pages WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return wiki.UnexistentPageError{Id: id}
+		}
+		return nil
+	})
+}
+
+func (store *DbPageStore) ListTrash() ([]*wiki.TrashedPage, error) {
+	rows, err := store.db.Query("SELECT id, title, version, deleted FROM trash ORDER BY deleted DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*wiki.TrashedPage
+	for rows.Next() {
+		trashed := &wiki.TrashedPage{}
+		var deleted int64
+		err = rows.Scan(&trashed.Id, &trashed.Title, &trashed.Version, &deleted)
+		if err != nil {
+			return nil, err
+		}
+		trashed.Deleted = time.Unix(0, deleted)
+		result = append(result, trashed)
+	}
+	return result, rows.Err()
+}
+
+func (store *DbPageStore) Restore(id wiki.PageId) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		var title string
+		err := tx.QueryRow("SELECT title FROM trash WHERE id = ?", id).Scan(&title)
+		if err == sql.ErrNoRows {
+			return wiki.NotInTrashError{Id: id}
+		}
+		if err != nil {
+			return err
+		}
+		exists, err := pageExists(tx, id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return wiki.ExistingPageError{Id: id}
+		}
+
+		_, err = tx.Exec("INSERT INTO pages (id, title, title_key, body, version, created, modified, author, parent) "+
+			"SELECT id, title, ?, body, version, created, modified, author, parent FROM trash WHERE id = ?",
+			store.titleKey(title), id)
+		if err != nil {
+			return err
+		}
+		err = store.checkUniqueTitle(tx, id, title)
+		if err != nil {
+			return err
+		}
+
+		// restored to the top level if its parent is gone
+		_, err = tx.Exec("UPDATE pages SET parent = '' WHERE id = ? AND parent NOT IN (SELECT id FROM pages)", id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM trash WHERE id = ?", id)
+		return err
+	})
+}
+
+func (store *DbPageStore) Purge(id wiki.PageId) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		return purge(tx, id)
+	})
+}
+
+func (store *DbPageStore) PurgeTrash(before time.Time) (int, error) {
+	purged := 0
+	err := store.inTransaction(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id FROM trash WHERE deleted < ?", before.UnixNano())
+		if err != nil {
+			return err
+		}
+		var ids []wiki.PageId
+		for rows.Next() {
+			var id wiki.PageId
+			err = rows.Scan(&id)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			err = purge(tx, id)
+			if err != nil {
+				return err
+			}
+		}
+		purged = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func purge(tx *sql.Tx, id wiki.PageId) error {
+	result, err := tx.Exec("DELETE FROM trash WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if purged == 0 {
+		return wiki.NotInTrashError{Id: id}
+	}
+
+	_, err = tx.Exec("DELETE FROM revisions WHERE page_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE page_id = ?", id)
+	return err
+}
+
+func (store *DbPageStore) ListAll() ([]wiki.PageId, error) {
+	return queryPageIds(store.db, "SELECT id FROM pages ORDER BY id")
+}
+
+func (store *DbPageStore) ListRange(options wiki.ListOptions) ([]wiki.PageId, error) {
+	query, cursor, descending := pageRangeQuery("id", options)
+	ids, err := queryPageIds(store.db, query, cursor)
+	if err != nil {
+		return nil, err
+	}
+	if descending {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+	}
+	return ids, nil
+}
+
+func (store *DbPageStore) ListSummaries(options wiki.ListOptions) ([]*wiki.PageSummary, error) {
+	query, cursor, descending := pageRangeQuery("id, title, version, created, modified, author, parent", options)
+	rows, err := store.db.Query(query, cursor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*wiki.PageSummary
+	for rows.Next() {
+		summary := &wiki.PageSummary{}
+		var created, modified int64
+		err = rows.Scan(&summary.Id, &summary.Title, &summary.Version, &created, &modified, &summary.Author, &summary.Parent)
+		if err != nil {
+			return nil, err
+		}
+		summary.Created, summary.Modified = unixTime(created), unixTime(modified)
+		result = append(result, summary)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if descending {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+	return result, nil
+}
+
+// Builds the query of a range of pages and its cursor argument. Pages before a cursor are queried
+// in descending order, to apply the limit, and must be reversed by the caller.
+func pageRangeQuery(columns string, options wiki.ListOptions) (query string, cursor wiki.PageId, descending bool) {
+	limit := ""
+	if options.Limit > 0 {
+		limit = " LIMIT " + strconv.Itoa(options.Limit)
+	}
+	if options.Before == "" {
+		return "SELECT " + columns + " FROM pages WHERE id > ? ORDER BY id" + limit, options.After, false
+	}
+	return "SELECT " + columns + " FROM pages WHERE id < ? ORDER BY id DESC" + limit, options.Before, true
+}
+
+// Listing the summaries takes a single query anyway
+func (store *DbPageStore) ListIndexedSummaries() ([]*wiki.PageSummary, error) {
+	return store.ListSummaries(wiki.ListOptions{})
+}
+
+func (store *DbPageStore) FindByTitle(title string) (wiki.PageId, error) {
+	var id wiki.PageId
+	err := store.db.QueryRow("SELECT id FROM pages WHERE title_key = ? ORDER BY id LIMIT 1", store.titleKey(title)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// Tags of trashed pages are kept, to be restored with them
+func (store *DbPageStore) FindByTag(tag string) ([]wiki.PageId, error) {
+	return queryPageIds(store.db, "SELECT tags.page_id FROM tags JOIN pages ON pages.id = tags.page_id WHERE tags.tag = ? ORDER BY tags.page_id",
+		wiki.NormalizeTag(tag))
+}
+
+func (store *DbPageStore) FindChildren(id wiki.PageId) ([]wiki.PageId, error) {
+	return queryPageIds(store.db, "SELECT id FROM pages WHERE parent = ? ORDER BY id", id)
+}
+
+func (store *DbPageStore) ListTags() (map[string]int, error) {
+	rows, err := store.db.Query("SELECT tags.tag, COUNT(*) FROM tags JOIN pages ON pages.id = tags.page_id GROUP BY tags.tag")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var count int
+		err = rows.Scan(&tag, &count)
+		if err != nil {
+			return nil, err
+		}
+		result[tag] = count
+	}
+	return result, rows.Err()
+}
+
+func (store *DbPageStore) ListRevisions(id wiki.PageId) ([]*wiki.Revision, error) {
+	_, err := store.Read(id)  // check that page exists
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := store.db.Query("SELECT number, timestamp, title, body, author FROM revisions WHERE page_id = ? ORDER BY number", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*wiki.Revision
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, revision)
+	}
+	return result, rows.Err()
+}
+
+// Revisions of trashed pages are kept in the same table, so only those of live pages are read
+func (store *DbPageStore) ReadRevision(id wiki.PageId, number int) (*wiki.Revision, error) {
+	row := store.db.QueryRow("SELECT number, timestamp, revisions.title, revisions.body, revisions.author FROM revisions "+
+		"JOIN pages ON pages.id = revisions.page_id WHERE page_id = ? AND number = ?", id, number)
+	revision, err := scanRevision(row)
+	if err != sql.ErrNoRows {
+		return revision, err
+	}
+
+	exists, err := pageExists(store.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, wiki.UnexistentPageError{Id: id}
+	}
+	return nil, wiki.UnexistentRevisionError{Id: id, Number: number}
+}
+
+// Runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise
+func (store *DbPageStore) inTransaction(fn func(*sql.Tx) error) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Either a *sql.DB or a *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func readPage(db queryer, id wiki.PageId) (*wiki.Page, error) {
+	page := &wiki.Page{}
+	var created, modified int64
+	err := db.QueryRow("SELECT id, title, body, version, created, modified, author, parent FROM pages WHERE id = ?", id).
+		Scan(&page.Id, &page.Title, &page.Body, &page.Version, &created, &modified, &page.Author, &page.Parent)
+	if err == sql.ErrNoRows {
+		return nil, wiki.UnexistentPageError{Id: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	page.Created, page.Modified = unixTime(created), unixTime(modified)
+
+	page.Tags, err = readTags(db, id)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func pageExists(db queryer, id wiki.PageId) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pages WHERE id = ?", id).Scan(&count)
+	return count > 0, err
+}
+
+func queryPageIds(db queryer, query string, args ...interface{}) ([]wiki.PageId, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []wiki.PageId
+	for rows.Next() {
+		var id wiki.PageId
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+func readTags(db queryer, id wiki.PageId) ([]string, error) {
+	rows, err := db.Query("SELECT tag FROM tags WHERE page_id = ? ORDER BY tag", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func writeTags(tx *sql.Tx, id wiki.PageId, tags []string) error {
+	_, err := tx.Exec("DELETE FROM tags WHERE page_id = ?", id)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		_, err = tx.Exec("INSERT INTO tags (page_id, tag) VALUES (?, ?)", id, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *DbPageStore) TitleMatching() wiki.TitleNormalization {
+	return store.normalization
+}
+
+func (store *DbPageStore) titleKey(title string) string {
+	return store.normalization.Normalize(title)
+}
+
+// Checked after writing the page, so that the transaction already holds a write lock
+func (store *DbPageStore) checkUniqueTitle(tx *sql.Tx, id wiki.PageId, title string) error {
+	var owner wiki.PageId
+	err := tx.QueryRow("SELECT id FROM pages WHERE title_key = ? AND id <> ? ORDER BY id LIMIT 1", store.titleKey(title), id).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wiki.DuplicateTitleError{Title: title, Id: owner}
+}
+
+// Checked after writing the page, like unique titles: the parent must exist, and not be the page itself or one of its descendants
+func checkParent(tx *sql.Tx, id wiki.PageId, parent wiki.PageId) error {
+	visited := make(map[wiki.PageId]bool)
+	for ancestor := parent; ancestor != "" && !visited[ancestor]; {
+		if ancestor == id {
+			return wiki.InvalidParentError{Id: id, Parent: parent, Reason: "the page cannot be moved under itself or one of its descendants"}
+		}
+		visited[ancestor] = true
+
+		err := tx.QueryRow("SELECT parent FROM pages WHERE id = ?", ancestor).Scan(&ancestor)
+		if err == sql.ErrNoRows {
+			if ancestor == parent {
+				return wiki.InvalidParentError{Id: id, Parent: parent, Reason: "unexistent page"}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertRevision(tx *sql.Tx, id wiki.PageId, number int, page *wiki.Page, timestamp time.Time) error {
+	_, err := tx.Exec("INSERT INTO revisions (page_id, number, timestamp, title, body, author) VALUES (?, ?, ?, ?, ?, ?)",
+		id, number, timestamp.UnixNano(), page.Title, page.Body, page.Author)
+	return err
+}
+
+// Timestamps are stored as nanoseconds since the epoch, or 0 if unknown
+func unixTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func unixNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// Either a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRevision(row scanner) (*wiki.Revision, error) {
+	revision := &wiki.Revision{}
+	var timestamp int64
+	err := row.Scan(&revision.Number, &timestamp, &revision.Title, &revision.Body, &revision.Author)
+	if err != nil {
+		return nil, err
+	}
+	revision.Timestamp = time.Unix(0, timestamp)
+	return revision, nil
+}
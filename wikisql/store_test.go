@@ -0,0 +1,278 @@
+package wikisql
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"github.com/joansais/go-practices/wiki"
+	"github.com/joansais/go-practices/wiki/storetest"
+	_ "modernc.org/sqlite"
+)
+
+func setupPageStore() *DbPageStore {
+	file, err := ioutil.TempFile("", "wikisqltest")
+	if err != nil {
+		panic(err)
+	}
+	file.Close()
+
+	store, err := Open("sqlite", file.Name(), wiki.DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+func cleanPageStore(store *DbPageStore) {
+	var filename string
+	store.db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&filename)
+	store.Close()
+	os.Remove(filename)
+}
+
+func TestStoreConformance(t *testing.T) {
+	storetest.RunPageStoreTests(t, func(t *testing.T) wiki.PageStore {
+		dsn := t.TempDir() + "/wiki.db"  // as given to wikiserver, without pragmas
+		store, err := Open("sqlite", dsn, wiki.DEFAULT_TITLE_NORMALIZATION)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestStoreCreateRead(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &wiki.Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if page.Id != id {
+		t.Errorf("DbPageStore.Create: expected %q, found %q", page.Id, id)
+		return
+	}
+
+	pageRead, err := store.Read(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(pageRead, page) {
+		t.Errorf("DbPageStore.Read(%q): expected %v, found %v", id, page, pageRead)
+		return
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &wiki.Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	stale := *page
+	page.Title = "Modified Page Title"
+	page.Body = "This is a modified page body."
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	pageRead, err := store.Read(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(pageRead, page) {
+		t.Errorf("DbPageStore.Read(%q): expected %v, found %v", id, page, pageRead)
+		return
+	}
+
+	err = store.Update(&stale)
+	if _, ok := err.(wiki.ConflictError); !ok {
+		t.Errorf("DbPageStore.Update: ConflictError was expected, found %v", err)
+		return
+	}
+
+	err = store.Update(&wiki.Page{Id: "unexistent", Title: "Unexistent Page"})
+	if _, ok := err.(wiki.UnexistentPageError); !ok {
+		t.Errorf("DbPageStore.Update: UnexistentPageError was expected, found %v", err)
+		return
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &wiki.Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = store.Delete(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = store.Read(id)
+	if _, ok := err.(wiki.UnexistentPageError); !ok {
+		t.Errorf("DbPageStore.Delete(%q): page was not deleted", id)
+		return
+	}
+}
+
+func TestStoreListAllFindByTitle(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	pages := []*wiki.Page{
+		&wiki.Page{Title: "Sample Page 1", Body: "This is a sample page for testing purposes."},
+		&wiki.Page{Title: "Sample Page 2", Body: "This is a sample page for testing purposes."},
+		&wiki.Page{Title: "Sample Page 3", Body: "This is a sample page for testing purposes."}}
+
+	for _, page := range pages {
+		_, err := store.Create(page)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	ids, err := store.ListAll()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ids) != len(pages) {
+		t.Errorf("DbPageStore.ListAll: expected %d pages, found %d", len(pages), len(ids))
+		return
+	}
+
+	for _, page := range pages {
+		id, err := store.FindByTitle(page.Title)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if id != page.Id {
+			t.Errorf("DbPageStore.FindByTitle: expected %q, found %q", page.Id, id)
+			return
+		}
+	}
+
+	id, err := store.FindByTitle("unexistent page")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if id != "" {
+		t.Errorf("DbPageStore.FindByTitle: expected nil, found %q", id)
+		return
+	}
+}
+
+func TestStoreRevisions(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	page := &wiki.Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id, err := store.Create(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	page.Body = "This is a modified page body."
+	err = store.Update(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	revisions, err := store.ListRevisions(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(revisions) != 2 || revisions[0].Body != "This is a sample page for testing purposes." || revisions[1].Body != page.Body {
+		t.Errorf("DbPageStore.ListRevisions(%q): unexpected revisions %v", id, revisions)
+		return
+	}
+
+	_, err = store.ReadRevision(id, 3)
+	if _, ok := err.(wiki.UnexistentRevisionError); !ok {
+		t.Errorf("DbPageStore.ReadRevision(%q, 3): UnexistentRevisionError was expected, found %v", id, err)
+		return
+	}
+}
+
+func TestTitlesReindexedOnNormalizationChange(t *testing.T) {
+	dsn := t.TempDir() + "/wiki.db"
+	store, err := Open("sqlite", dsn, wiki.DEFAULT_TITLE_NORMALIZATION)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	id, err := store.Create(&wiki.Page{Title: "Go Tips", Body: "Some tips."})
+	store.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	store, err = Open("sqlite", dsn, wiki.EXACT_TITLES)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer store.Close()
+
+	for title, expected := range map[string]wiki.PageId{"go tips": "", "Go Tips": id} {
+		found, err := store.FindByTitle(title)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if found != expected {
+			t.Errorf("DbPageStore.FindByTitle(%q): expected %q, found %q", title, expected, found)
+			return
+		}
+	}
+}
+
+func TestMigrationsAreIdempotent(t *testing.T) {
+	store := setupPageStore()
+	defer cleanPageStore(store)
+
+	err := migrate(store.db)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var version int
+	err = store.db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if version != len(migrations) {
+		t.Errorf("migrate: expected schema version %d, found %d", len(migrations), version)
+		return
+	}
+}
@@ -0,0 +1,19 @@
+package wikix_test
+
+import (
+	"testing"
+	"github.com/joansais/go-practices/wikix"
+	"github.com/joansais/go-practices/wikix/storetest"
+)
+
+func TestDiskStoreConformance(t *testing.T) {
+	storetest.RunPageStoreTests(t, func(t *testing.T) wikix.PageStore {
+		return wikix.NewDiskStore(t.TempDir())
+	})
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.RunPageStoreTests(t, func(t *testing.T) wikix.PageStore {
+		return wikix.NewMemoryStore()
+	})
+}
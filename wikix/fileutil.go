@@ -0,0 +1,67 @@
+package wikix
+
+import (
+	"os"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+	. "github.com/joansais/go-practices/exception"
+)
+
+const (
+	TEMP_FILE_INFIX = ".tmp-"
+)
+
+// Writes a file through a temporary file that is synced and then renamed over the target,
+// so that a crash or a full disk never leaves a truncated file behind
+func writeFileAtomically(filename string, content []byte, perm os.FileMode) {
+	dir := filepath.Dir(filename)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(filename)+TEMP_FILE_INFIX)
+	ThrowIf(err)
+	defer func() {
+		if r := recover(); r != nil {
+			os.Remove(tmp.Name())
+			panic(r)
+		}
+	}()
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	ThrowIf(err)
+
+	ThrowIf(os.Chmod(tmp.Name(), perm))
+	ThrowIf(os.Rename(tmp.Name(), filename))
+	syncDir(dir)  // make the rename itself durable
+}
+
+func syncDir(dir string) {
+	file, err := os.Open(dir)
+	ThrowIf(err)
+	defer file.Close()
+	ThrowIf(file.Sync())
+}
+
+// Removes the temporary files left behind by writes interrupted by a crash, unless they are younger
+// than minAge, since they may belong to writes still in progress
+func removeTempFiles(dir string, minAge time.Duration) {
+	limit := time.Now().Add(-minAge)
+	ThrowIf(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && isTempFile(info.Name()) && info.ModTime().Before(limit) {
+			return os.Remove(path)
+		}
+		return nil
+	}))
+}
+
+func isTempFile(name string) bool {
+	return strings.Contains(name, TEMP_FILE_INFIX)
+}
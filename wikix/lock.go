@@ -0,0 +1,43 @@
+package wikix
+
+import (
+	"sync"
+)
+
+// Per-page mutexes, serializing the writers of each page within the process
+type pageLocks struct {
+	mutex	sync.Mutex
+	locks	map[PageId]*pageLock
+}
+
+type pageLock struct {
+	sync.Mutex
+	refs int  // goroutines holding or waiting for the lock
+}
+
+func newPageLocks() *pageLocks {
+	return &pageLocks{locks: make(map[PageId]*pageLock)}
+}
+
+// Locks a page; the returned function releases the lock
+func (locks *pageLocks) lock(id PageId) (unlock func()) {
+	locks.mutex.Lock()
+	lock, found := locks.locks[id]
+	if !found {
+		lock = &pageLock{}
+		locks.locks[id] = lock
+	}
+	lock.refs++
+	locks.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		locks.mutex.Lock()
+		defer locks.mutex.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(locks.locks, id)
+		}
+	}
+}
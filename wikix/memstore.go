@@ -0,0 +1,138 @@
+package wikix
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	. "github.com/joansais/go-practices/exception"
+)
+
+// A PageStore that keeps pages in memory, for tests and ephemeral wikis
+type memoryStore struct {
+	mutex sync.RWMutex
+	pages map[PageId]*Page
+}
+
+func NewMemoryStore() PageStore {
+	return &memoryStore{pages: make(map[PageId]*Page)}
+}
+
+// Creates a memory store with the pages of a JSON snapshot, as written by WriteSnapshot
+func LoadMemoryStore(reader io.Reader) PageStore {
+	var pages []*Page
+	ThrowIf(json.NewDecoder(reader).Decode(&pages))
+
+	store := &memoryStore{pages: make(map[PageId]*Page)}
+	for _, page := range pages {
+		if page.Id == "" {
+			page.Id = newPageId()
+		}
+		if page.Version == 0 {
+			page.Version = 1
+		}
+		store.put(page)
+	}
+	return store
+}
+
+// Writes all the pages of a store as a JSON snapshot
+func WriteSnapshot(store PageStore, writer io.Writer) {
+	ids := store.ListAll()
+	pages := make([]*Page, len(ids))
+	for k, id := range ids {
+		pages[k] = store.Read(id)
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "\t")
+	ThrowIf(encoder.Encode(pages))
+}
+
+func (store *memoryStore) Create(page *Page) PageId {
+	id := newPageId()
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	page.Id = id
+	page.Version = 1
+	store.put(page)
+	return id
+}
+
+func (store *memoryStore) Read(id PageId) *Page {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	page, found := store.pages[id]
+	if !found {
+		Throw(UnexistentPageError{id})
+	}
+	pageCopy := *page
+	return &pageCopy
+}
+
+func (store *memoryStore) Update(page *Page) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, found := store.pages[page.Id]
+	if !found {
+		Throw(UnexistentPageError{page.Id})
+	}
+	if page.Version != current.Version {
+		currentCopy := *current
+		Throw(ConflictError{Current: &currentCopy, Rejected: page})
+	}
+
+	page.Version = current.Version + 1
+	store.put(page)
+}
+
+func (store *memoryStore) Delete(id PageId) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, found := store.pages[id]; !found {
+		Throw(UnexistentPageError{id})
+	}
+	delete(store.pages, id)
+}
+
+func (store *memoryStore) ListAll() []PageId {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result := make([]PageId, 0, len(store.pages))
+	for id := range store.pages {
+		result = append(result, id)
+	}
+	sort.Sort(pageIdList(result))
+	return result
+}
+
+func (store *memoryStore) FindByTitle(title string) PageId {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	result := PageId("")
+	for id, page := range store.pages {  // return the lowest id, as diskStore does
+		if page.Title == title && (result == "" || id < result) {
+			result = id
+		}
+	}
+	return result
+}
+
+// Stores a copy of the page; the caller must hold the write lock
+func (store *memoryStore) put(page *Page) {
+	pageCopy := *page
+	store.pages[page.Id] = &pageCopy
+}
+
+type pageIdList []PageId
+
+func (list pageIdList) Len() int           { return len(list) }
+func (list pageIdList) Less(i, j int) bool { return list[i] < list[j] }
+func (list pageIdList) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
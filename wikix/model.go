@@ -9,6 +9,12 @@ type PageModel struct {
 	Title		string
 	BodyToEdit	string
 	BodyAsHtml	template.HTML
+	Version		int
+}
+
+type ConflictModel struct {
+	Current		*PageModel
+	Rejected	*PageModel
 }
 
 type PageListModel []*PageModel
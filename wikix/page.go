@@ -6,4 +6,5 @@ type Page struct {
 	Id		PageId
 	Title	string
 	Body	string
+	Version	int  // incremented on every update, to detect conflicting writes
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"regexp"
 	"sort"
+	"strconv"
 	. "github.com/joansais/go-practices/exception"
 )
 
@@ -22,6 +23,9 @@ const (
 
 var (
 	pageRequestPattern = regexp.MustCompile(`^/(view|edit|delete)/([a-zA-Z0-9]+)$`)
+	// The templates are those of the wiki package, where basic tells them to leave out its many features,
+	// from the history of pages to their attachments
+	templateFuncs = template.FuncMap{"basic": func() bool { return true }}
 )
 
 type Server struct {
@@ -34,7 +38,7 @@ func NewServer(store PageStore, syntax SyntaxHandler, assetsDir string) *Server
 	return &Server{
 		pageStore:     store,
 		syntaxHandler: syntax,
-		htmlTemplates: template.Must(template.ParseGlob(assetsDir + HTML_TEMPLATE_FILES))}
+		htmlTemplates: template.Must(template.New("").Funcs(templateFuncs).ParseGlob(assetsDir + HTML_TEMPLATE_FILES))}
 }
 
 func (server *Server) Start(addr string) error {
@@ -48,7 +52,7 @@ func (server *Server) Start(addr string) error {
 }
 
 func (server *Server) handleList(res http.ResponseWriter, req *http.Request) {
-	defer Catch(errorHandler(res))
+	defer Catch(server.errorHandler(res))
 
 	ids := server.pageStore.ListAll()
 
@@ -63,7 +67,7 @@ func (server *Server) handleList(res http.ResponseWriter, req *http.Request) {
 }
 
 func (server *Server) handleView(res http.ResponseWriter, req *http.Request) {
-	defer Catch(errorHandler(res))
+	defer Catch(server.errorHandler(res))
 
 	page := server.pageStore.Read(getRequestedPageId(req))
 	bodyAsHtml := server.syntaxHandler.BodyToHtml(page.Body)
@@ -73,7 +77,7 @@ func (server *Server) handleView(res http.ResponseWriter, req *http.Request) {
 }
 
 func (server *Server) handleCreate(res http.ResponseWriter, req *http.Request) {
-	defer Catch(errorHandler(res))
+	defer Catch(server.errorHandler(res))
 
 	title := req.URL.Query().Get("title")
 	if title == "" {
@@ -85,17 +89,17 @@ func (server *Server) handleCreate(res http.ResponseWriter, req *http.Request) {
 }
 
 func (server *Server) handleEdit(res http.ResponseWriter, req *http.Request) {
-	defer Catch(errorHandler(res))
+	defer Catch(server.errorHandler(res))
 
 	page := server.pageStore.Read(getRequestedPageId(req))
 	bodyToEdit := server.syntaxHandler.BodyToEdit(page.Body)
 
-	pageModel := &PageModel{Id: page.Id, Title: page.Title, BodyToEdit: bodyToEdit}
+	pageModel := &PageModel{Id: page.Id, Title: page.Title, BodyToEdit: bodyToEdit, Version: page.Version}
 	ThrowIf(server.htmlTemplates.ExecuteTemplate(res, "edit", pageModel))
 }
 
 func (server *Server) handleSave(res http.ResponseWriter, req *http.Request) {
-	defer Catch(errorHandler(res))
+	defer Catch(server.errorHandler(res))
 
 	err := req.ParseForm()
 	if err != nil {
@@ -108,6 +112,13 @@ func (server *Server) handleSave(res http.ResponseWriter, req *http.Request) {
 	body := server.syntaxHandler.EditToBody(bodyFromEdit)
 	page := &Page{Id: id, Title: title, Body: body}
 
+	if id != "" {
+		page.Version, err = strconv.Atoi(req.Form.Get("version")) // version of the page when edition started
+		if err != nil {
+			Throw(InvalidRequestError{err})
+		}
+	}
+
 	if id == "" {
 		id = server.pageStore.Create(page)
 	} else {
@@ -118,7 +129,7 @@ func (server *Server) handleSave(res http.ResponseWriter, req *http.Request) {
 }
 
 func (server *Server) handleDelete(res http.ResponseWriter, req *http.Request) {
-	defer Catch(errorHandler(res))
+	defer Catch(server.errorHandler(res))
 	server.pageStore.Delete(getRequestedPageId(req))
 	http.Redirect(res, req, LIST_ENTRYPOINT_PATH, http.StatusFound)
 }
@@ -143,13 +154,32 @@ func (err InvalidRequestError) Error() string {
 	}
 }
 
-func errorHandler(res http.ResponseWriter) ErrorHandler {
+// Shows both the current version of the page and the rejected changes, so that the user can merge them
+func (server *Server) handleConflict(res http.ResponseWriter, conflict ConflictError) {
+	current, rejected := conflict.Current, conflict.Rejected
+	conflictModel := &ConflictModel{
+		Current: &PageModel{Id: current.Id, Title: current.Title, Version: current.Version,
+			BodyToEdit: server.syntaxHandler.BodyToEdit(current.Body)},
+		Rejected: &PageModel{Id: rejected.Id, Title: rejected.Title, Version: rejected.Version,
+			BodyToEdit: server.syntaxHandler.BodyToEdit(rejected.Body)}}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusConflict)
+	err := server.htmlTemplates.ExecuteTemplate(res, "conflict", conflictModel)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (server *Server) errorHandler(res http.ResponseWriter) ErrorHandler {
 	return func(err error) {
-		switch err.(type) {
+		switch err := err.(type) {
 		case InvalidRequestError:
 			http.Error(res, err.Error(), http.StatusBadRequest)
 		case UnexistentPageError:
 			http.Error(res, err.Error(), http.StatusNotFound)
+		case ConflictError:
+			server.handleConflict(res, err)
 		default:
 			http.Error(res, "internal error", http.StatusInternalServerError) // do not return internal error details to client
 			log.Println(err)
@@ -9,34 +9,43 @@ import (
 	"strings"
 	"errors"
 	"fmt"
+	"time"
 	. "github.com/joansais/go-practices/exception"
 )
 
+// Storage strategy for wiki pages. Implementations can be checked with the storetest package.
 type PageStore interface {
 	Create(*Page) PageId
 	Read(PageId) *Page
 	Update(*Page)
 	Delete(PageId)
-	ListAll() []PageId
-	FindByTitle(string) PageId
+	ListAll() []PageId  // sorted by id
+	FindByTitle(string) PageId  // "" if there is no such page
 }
 
 const (
 	PAGE_ID_LEN = 6  // in bytes
 	FILE_SUFFIX = ".wiki"
+	TEMP_FILE_MIN_AGE = time.Minute  // younger temporary files may belong to writes in progress
 )
 
+// Pages are stored in files named after their ids. The directory must not be shared with other processes,
+// since writers only lock pages within this one.
 type diskStore struct {
 	path string
+	locks *pageLocks
 }
 
+// Opens a store in an existing directory, first cleaning up after any write interrupted by a crash
 func NewDiskStore(path string) PageStore {
-	return &diskStore{path: path}
+	removeTempFiles(path, TEMP_FILE_MIN_AGE)
+	return &diskStore{path: path, locks: newPageLocks()}
 }
 
 func (store *diskStore) Create(page *Page) PageId {
 	id := newPageId()
 	page.Id = id
+	page.Version = 1
 	store.writePageToFile(page)
 	return id
 }
@@ -45,14 +54,33 @@ func (store *diskStore) Read(id PageId) *Page {
 	return store.readPageFromFile(id)
 }
 
+// The page is locked from the version check to the write, so that concurrent updates of the same
+// version cannot both succeed
 func (store *diskStore) Update(page *Page) {
-	_ = store.readPageFromFile(page.Id)  // check that page exists
-	store.writePageToFile(page)
+	defer store.locks.lock(page.Id)()
+
+	current := store.readPageFromFile(page.Id)  // also checks that page exists
+	if page.Version != current.Version {
+		Throw(ConflictError{Current: current, Rejected: page})
+	}
+
+	page.Version = current.Version + 1
+	err := Try(func() { store.writePageToFile(page) })
+	if err != nil {
+		page.Version = current.Version
+		Throw(err)
+	}
 }
 
 func (store *diskStore) Delete(id PageId) {
+	defer store.locks.lock(id)()
+
 	filename := store.getPageFilename(id)
-	ThrowIf(os.Remove(filename))
+	err := os.Remove(filename)
+	if os.IsNotExist(err) {
+		Throw(UnexistentPageError{id})
+	}
+	ThrowIf(err)
 }
 
 func (store *diskStore) ListAll() (result []PageId) {
@@ -107,8 +135,7 @@ func (store *diskStore) writePageToFile(page *Page) {
 	content, err := json.Marshal(page)
 	ThrowIf(err)
 	filename := store.getPageFilename(page.Id)
-	err = ioutil.WriteFile(filename, content, 0600)
-	ThrowIf(err)
+	writeFileAtomically(filename, content, 0600)
 }
 
 func (store *diskStore) getPageFilename(id PageId) string {
@@ -139,3 +166,13 @@ func (err CorruptedFileError) Error() string {
     return fmt.Sprintf("corrupted file %q: %s", err.Filename, err.Cause.Error())
 }
 
+// Thrown when updating a page that was modified since it was read
+type ConflictError struct {
+    Current *Page
+    Rejected *Page
+}
+
+func (err ConflictError) Error() string {
+    return fmt.Sprintf("conflicting update of page %q: version %d was modified (current version is %d)",
+        err.Current.Id, err.Rejected.Version, err.Current.Version)
+}
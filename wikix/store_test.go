@@ -1,8 +1,12 @@
 package wikix
 
 import (
+	"bytes"
 	"testing"
 	"sort"
+	"os"
+	"io/ioutil"
+	"time"
 	. "gopkg.in/check.v1"
 	. "github.com/joansais/go-practices/exception"
 )
@@ -10,13 +14,36 @@ import (
 func Test(t *testing.T) { TestingT(t) }
 
 var _ = Suite(&StoreSuite{})
+var _ = Suite(&MemoryStoreSuite{})
 
 type StoreSuite struct {
 	store PageStore
 }
 
 func (s *StoreSuite) SetUpSuite(c *C) {
-	s.store = &diskStore{path: c.MkDir()}
+	s.store = &diskStore{path: c.MkDir(), locks: newPageLocks()}
+}
+
+// Runs the same tests over a memory store
+type MemoryStoreSuite struct {
+	StoreSuite
+}
+
+func (s *MemoryStoreSuite) SetUpSuite(c *C) {
+	s.store = NewMemoryStore()
+}
+
+func (s *MemoryStoreSuite) TestRecoveryRemovesTempFiles(c *C) {
+	c.Skip("memory stores have no files")
+}
+
+func (s *MemoryStoreSuite) TestSnapshot(c *C) {
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id := s.store.Create(page)
+
+	var snapshot bytes.Buffer
+	WriteSnapshot(s.store, &snapshot)
+	c.Assert(LoadMemoryStore(&snapshot).Read(id), DeepEquals, page)
 }
 
 func (s *StoreSuite) TearDownTest(c *C) {
@@ -95,3 +122,39 @@ func (s *StoreSuite) TestUnexistentPageError(c *C) {
 	c.Assert(Try(func() { s.store.Read(id) }), DeepEquals, UnexistentPageError{id})
 }
 
+func (s *StoreSuite) TestConflictError(c *C) {
+	page := &Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."}
+	id := s.store.Create(page)
+
+	first := s.store.Read(id)
+	second := s.store.Read(id)
+
+	first.Body = "First modification."
+	s.store.Update(first)
+	c.Assert(first.Version, Equals, 2)
+
+	second.Body = "Second modification."
+	err := Try(func() { s.store.Update(second) })
+	c.Assert(err, DeepEquals, ConflictError{Current: first, Rejected: second})
+	c.Assert(s.store.Read(id), DeepEquals, first)
+}
+
+func (s *StoreSuite) TestRecoveryRemovesTempFiles(c *C) {
+	store := s.store.(*diskStore)
+	id := store.Create(&Page{Title: "Sample Page", Body: "This is a sample page for testing purposes."})
+
+	leftover := store.getPageFilename(id) + TEMP_FILE_INFIX + "123456" // as left by an interrupted write
+	c.Assert(ioutil.WriteFile(leftover, []byte("{\"Id\":"), 0600), IsNil)
+	old := time.Now().Add(-2 * TEMP_FILE_MIN_AGE)
+	c.Assert(os.Chtimes(leftover, old, old), IsNil)
+	inProgress := store.getPageFilename(id) + TEMP_FILE_INFIX + "654321" // may belong to a write in progress
+	c.Assert(ioutil.WriteFile(inProgress, []byte("{\"Id\":"), 0600), IsNil)
+	defer os.Remove(inProgress)
+
+	NewDiskStore(store.path)
+	_, err := os.Stat(leftover)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(inProgress)
+	c.Assert(err, IsNil)
+	c.Assert(store.ListAll(), DeepEquals, []PageId{id})
+}
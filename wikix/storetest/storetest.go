@@ -0,0 +1,246 @@
+// Package storetest provides a conformance test suite for wikix.PageStore implementations,
+// so that new storage backends can prove they behave like the disk store.
+package storetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"github.com/joansais/go-practices/wikix"
+	. "github.com/joansais/go-practices/exception"
+)
+
+// Returns a new, empty store. Resources can be released with t.Cleanup.
+type StoreFactory func(t *testing.T) wikix.PageStore
+
+func RunPageStoreTests(t *testing.T, newStore StoreFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, wikix.PageStore)
+	}{
+		{"CreateRead", testCreateRead},
+		{"Update", testUpdate},
+		{"UpdateConflict", testUpdateConflict},
+		{"Delete", testDelete},
+		{"UnexistentPage", testUnexistentPage},
+		{"ListAll", testListAll},
+		{"FindByTitle", testFindByTitle},
+		{"ConcurrentAccess", testConcurrentAccess},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store := newStore(t)
+			err := Try(func() { test.test(t, store) })
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func newSamplePage(n int) *wikix.Page {
+	return &wikix.Page{Title: fmt.Sprintf("Sample Page %d", n), Body: "This is a sample page for testing purposes."}
+}
+
+func assertSamePage(t *testing.T, expected, found *wikix.Page) {
+	if *found != *expected {
+		t.Fatalf("PageStore.Read(%q): expected %+v, found %+v", expected.Id, expected, found)
+	}
+}
+
+func assertUnexistentPage(t *testing.T, operation string, id wikix.PageId, err error) {
+	if err != (wikix.UnexistentPageError{Id: id}) {
+		t.Fatalf("PageStore.%s(%q): UnexistentPageError was expected, found %v", operation, id, err)
+	}
+}
+
+func testCreateRead(t *testing.T, store wikix.PageStore) {
+	page := newSamplePage(1)
+	id := store.Create(page)
+	if id == "" || page.Id != id || page.Version != 1 {
+		t.Fatalf("PageStore.Create: unexpected id %q for page %+v", id, page)
+	}
+	assertSamePage(t, page, store.Read(id))
+}
+
+func testUpdate(t *testing.T, store wikix.PageStore) {
+	page := newSamplePage(1)
+	id := store.Create(page)
+
+	page.Title = "Modified Page Title"
+	page.Body = "This is a modified page body."
+	store.Update(page)
+	if page.Version != 2 {
+		t.Fatalf("PageStore.Update: expected version 2, found %d", page.Version)
+	}
+	assertSamePage(t, page, store.Read(id))
+}
+
+func testUpdateConflict(t *testing.T, store wikix.PageStore) {
+	id := store.Create(newSamplePage(1))
+	first := store.Read(id)
+	second := store.Read(id)
+
+	first.Body = "First modification."
+	store.Update(first)
+
+	second.Body = "Second modification."
+	err := Try(func() { store.Update(second) })
+	conflictErr, ok := err.(wikix.ConflictError)
+	if !ok {
+		t.Fatalf("PageStore.Update: ConflictError was expected, found %v", err)
+	}
+	if *conflictErr.Current != *first || *conflictErr.Rejected != *second {
+		t.Fatalf("ConflictError: unexpected versions %+v and %+v", conflictErr.Current, conflictErr.Rejected)
+	}
+	assertSamePage(t, first, store.Read(id))
+}
+
+func testDelete(t *testing.T, store wikix.PageStore) {
+	id := store.Create(newSamplePage(1))
+	store.Delete(id)
+
+	assertUnexistentPage(t, "Read", id, Try(func() { store.Read(id) }))
+	if ids := store.ListAll(); len(ids) != 0 {
+		t.Fatalf("PageStore.ListAll: expected no pages, found %v", ids)
+	}
+}
+
+func testUnexistentPage(t *testing.T, store wikix.PageStore) {
+	id := wikix.PageId("unexistent")
+	assertUnexistentPage(t, "Read", id, Try(func() { store.Read(id) }))
+	assertUnexistentPage(t, "Update", id, Try(func() { store.Update(&wikix.Page{Id: id, Title: "Unexistent Page"}) }))
+	assertUnexistentPage(t, "Delete", id, Try(func() { store.Delete(id) }))
+}
+
+func testListAll(t *testing.T, store wikix.PageStore) {
+	var expected []string
+	for n := 1; n <= 3; n++ {
+		expected = append(expected, string(store.Create(newSamplePage(n))))
+	}
+	sort.Strings(expected)
+
+	found := store.ListAll()
+	if len(found) != len(expected) {
+		t.Fatalf("PageStore.ListAll: expected %d pages, found %d", len(expected), len(found))
+	}
+	for k := range expected {  // sorted by id
+		if string(found[k]) != expected[k] {
+			t.Fatalf("PageStore.ListAll: expected %q, found %q", expected[k], found[k])
+		}
+	}
+}
+
+func testFindByTitle(t *testing.T, store wikix.PageStore) {
+	var pages []*wikix.Page
+	for n := 1; n <= 3; n++ {
+		page := newSamplePage(n)
+		store.Create(page)
+		pages = append(pages, page)
+	}
+
+	for _, page := range pages {
+		if id := store.FindByTitle(page.Title); id != page.Id {
+			t.Fatalf("PageStore.FindByTitle(%q): expected %q, found %q", page.Title, page.Id, id)
+		}
+	}
+	if id := store.FindByTitle("unexistent page"); id != "" {
+		t.Fatalf("PageStore.FindByTitle: expected no page, found %q", id)
+	}
+
+	pages[0].Title = "Renamed Page"
+	store.Update(pages[0])
+	if id := store.FindByTitle("Renamed Page"); id != pages[0].Id {
+		t.Fatalf("PageStore.FindByTitle(%q): expected %q, found %q", "Renamed Page", pages[0].Id, id)
+	}
+}
+
+const CONCURRENT_WRITERS = 8
+
+// Each writer creates and repeatedly updates its own page while readers list and read all pages
+func testConcurrentAccess(t *testing.T, store wikix.PageStore) {
+	var wait sync.WaitGroup
+	errs := make(chan error, 2*CONCURRENT_WRITERS)
+
+	for n := 0; n < CONCURRENT_WRITERS; n++ {
+		wait.Add(2)
+		go func(n int) {
+			defer wait.Done()
+			errs <- Try(func() {
+				page := newSamplePage(n)
+				store.Create(page)
+				for k := 0; k < 5; k++ {
+					page.Body = fmt.Sprintf("Modification #%d", k)
+					store.Update(page)
+				}
+			})
+		}(n)
+		go func() {
+			defer wait.Done()
+			errs <- Try(func() {
+				for _, id := range store.ListAll() {
+					store.Read(id)
+				}
+			})
+		}()
+	}
+	wait.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent access: %s", err)
+		}
+	}
+
+	ids := store.ListAll()
+	if len(ids) != CONCURRENT_WRITERS {
+		t.Fatalf("PageStore.ListAll: expected %d pages, found %d", CONCURRENT_WRITERS, len(ids))
+	}
+	for _, id := range ids {
+		if page := store.Read(id); page.Version != 6 || page.Body != "Modification #4" {
+			t.Fatalf("PageStore.Read(%q): unexpected page %+v", id, page)
+		}
+	}
+}
+
+// Writers update the same version of a page at once: only one of them may succeed
+func testConcurrentUpdates(t *testing.T, store wikix.PageStore) {
+	id := store.Create(newSamplePage(1))
+
+	var wait sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, CONCURRENT_WRITERS)
+	for n := 0; n < CONCURRENT_WRITERS; n++ {
+		wait.Add(1)
+		go func(n int) {
+			defer wait.Done()
+			<-start  // all at once
+			errs <- Try(func() {
+				store.Update(&wikix.Page{Id: id, Title: "Sample Page 1", Body: fmt.Sprintf("Modification by writer #%d", n), Version: 1})
+			})
+		}(n)
+	}
+	close(start)
+	wait.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if _, ok := err.(wikix.ConflictError); !ok {
+			t.Fatalf("PageStore.Update(%q): expected nil or ConflictError, got %v", id, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("PageStore.Update(%q): %d concurrent updates of the same version succeeded, expected 1", id, succeeded)
+	}
+	if page := store.Read(id); page.Version != 2 {
+		t.Fatalf("PageStore.Read(%q): expected version 2, found %d", id, page.Version)
+	}
+}
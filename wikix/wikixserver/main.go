@@ -4,26 +4,56 @@ import (
 	"github.com/joansais/go-practices/wikix"
 	"log"
 	"flag"
+	"fmt"
+	"os"
+	. "github.com/joansais/go-practices/exception"
 )
 
 const (
 	DEFAULT_ADDR = ":8080"
 	DEFAULT_ASSETS_DIR = "assets/wiki"
 	DEFAULT_STORAGE_DIR = "data/wiki/pages"
+	DEFAULT_STORE = "disk"
 )
 
 func main() {
 	addr := flag.String("addr", DEFAULT_ADDR, "network address to listen on")
 	assetsDir := flag.String("assets", DEFAULT_ASSETS_DIR, "location of HTML templates")
 	storageDir := flag.String("storage", DEFAULT_STORAGE_DIR, "storage directory for wiki pages")
+	storeKind := flag.String("store", DEFAULT_STORE, "page store: disk or memory")
+	seed := flag.String("seed", "", "JSON snapshot with the initial pages, when -store=memory")
 	flag.Parse()
 
-	store := wikix.NewDiskStore(*storageDir)
+	var store wikix.PageStore
+	err := Try(func() { store = openStore(*storeKind, *storageDir, *seed) })
+	if err != nil {
+		log.Fatal("Error opening page store: ", err)
+		return
+	}
+
 	syntax := wikix.NewMarkdownSyntax(store)
 	server := wikix.NewServer(store, syntax, *assetsDir)
-	err := server.Start(*addr)
+	err = server.Start(*addr)
 	if err != nil {
 		log.Fatal("Error starting server: ", err)
 		return
 	}
 }
+
+func openStore(kind, storageDir, seed string) wikix.PageStore {
+	switch kind {
+	case "disk":
+		return wikix.NewDiskStore(storageDir)
+	case "memory":
+		if seed == "" {
+			return wikix.NewMemoryStore()
+		}
+		file, err := os.Open(seed)
+		ThrowIf(err)
+		defer file.Close()
+		return wikix.LoadMemoryStore(file)
+	default:
+		Throw(fmt.Errorf("unknown page store %q", kind))
+		return nil
+	}
+}